@@ -0,0 +1,447 @@
+package alerts
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/auth"
+	"github.com/tmullender/network-log-monitor/notify"
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+// The events that a rule can match, new devices are also device events
+const (
+	RequestEvent   = "request"
+	DeviceEvent    = "device"
+	NewDeviceEvent = "new-device"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+const defaultCooldown = 15 * time.Minute
+const defaultDedup = 24 * time.Hour
+
+// maxPending : the most events held back during a cooldown, any more are only counted
+const maxPending = 500
+
+// maxFirstRequests : the most requests included in a new device alert
+const maxFirstRequests = 50
+
+// maxDeliveries : the most alerts waiting to be sent, any more are dropped
+const maxDeliveries = 100
+
+// stopTimeout : how long Stop waits for the alerts that are waiting to be sent
+const stopTimeout = 10 * time.Second
+
+// firstRequestsDelay : how long to wait after a new device joins before alerting,
+// so that the alert can include its first requests
+const firstRequestsDelay = 2 * time.Minute
+
+// Rule : sends an alert as soon as an event matches it, conditions that are not set match every event.
+// Hosts use the same syntax as the authorized hosts and HostsFile is read for more, one per line.
+// From and To (15:04) limit the rule to a time of day and wrap around midnight, so 23:00 to 06:00 matches
+// activity during the night. After an alert is sent, matches are held back for CooldownMinutes and sent
+// together, and each device and host only alerts once every DedupMinutes
+type Rule struct {
+	Name            string
+	Event           string
+	Macs            []string
+	Hosts           []string
+	HostsFile       string
+	From            string
+	To              string
+	CooldownMinutes uint64
+	DedupMinutes    uint64
+	notify.Targets
+}
+
+// Event : a device being seen or a request it made, the host and type are only set for requests
+// and the requests are the first made by a new device
+type Event struct {
+	Name     string
+	Device   *state.Device
+	At       *time.Time
+	Host     string
+	Type     string
+	Requests []*state.DeviceRequest
+}
+
+type compiled struct {
+	*Rule
+	event    string
+	macs     map[string]bool
+	matcher  *state.HostMatcher
+	window   bool
+	from     int
+	to       int
+	cooldown time.Duration
+	dedup    time.Duration
+	lastSent time.Time
+	alerted  map[string]time.Time
+	pending  []*Event
+	dropped  int
+	timer    *time.Timer
+}
+
+// delivery is an alert waiting to be sent with the rule's notifiers
+type delivery struct {
+	rule    *Rule
+	content *notify.Content
+}
+
+// Engine : checks each event against the rules and sends the alerts
+type Engine struct {
+	lock       sync.Mutex
+	rules      []*compiled
+	newDevices bool
+	store      *state.Store
+	root       string
+	signer     *auth.Signer
+	deliver    func(rule *Rule, content *notify.Content)
+	after      func(delay time.Duration, check func())
+	deliveries chan *delivery
+	delivered  chan bool
+	stopped    bool
+}
+
+// NewEngine : Create an engine for the rules, an error is returned if any of them are invalid.
+// The store is read for the first requests of new devices, root and the signer are used for the links
+func NewEngine(rules []*Rule, store *state.Store, root string, signer *auth.Signer) (*Engine, error) {
+	engine := &Engine{rules: make([]*compiled, 0, len(rules)), store: store, root: root, signer: signer,
+		deliveries: make(chan *delivery, maxDeliveries), delivered: make(chan bool)}
+	engine.deliver = engine.queue
+	engine.after = func(delay time.Duration, check func()) { time.AfterFunc(delay, check) }
+	for _, rule := range rules {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid alert rule %s: %v", rule.Name, err)
+		}
+		engine.rules = append(engine.rules, compiled)
+		engine.newDevices = engine.newDevices || compiled.event == NewDeviceEvent
+	}
+	go engine.sendDeliveries()
+	return engine, nil
+}
+
+func compile(rule *Rule) (*compiled, error) {
+	result := &compiled{Rule: rule, event: rule.Event, macs: make(map[string]bool, 0),
+		cooldown: defaultCooldown, dedup: defaultDedup, alerted: make(map[string]time.Time, 0)}
+	if len(result.event) == 0 {
+		result.event = RequestEvent
+	}
+	if result.event != RequestEvent && result.event != DeviceEvent && result.event != NewDeviceEvent {
+		return nil, fmt.Errorf("Unknown event %s", rule.Event)
+	}
+	for _, mac := range rule.Macs {
+		result.macs[strings.ToLower(mac)] = true
+	}
+	hosts, err := readHosts(rule)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) > 0 {
+		if result.event != RequestEvent {
+			return nil, fmt.Errorf("Hosts can only be matched by request events")
+		}
+		result.matcher = state.NewHostMatcher(hosts)
+	}
+	if len(rule.From) > 0 || len(rule.To) > 0 {
+		if result.from, err = minuteOfDay(rule.From); err != nil {
+			return nil, err
+		}
+		if result.to, err = minuteOfDay(rule.To); err != nil {
+			return nil, err
+		}
+		result.window = true
+	}
+	if rule.CooldownMinutes > 0 {
+		result.cooldown = time.Duration(rule.CooldownMinutes) * time.Minute
+	}
+	if rule.DedupMinutes > 0 {
+		result.dedup = time.Duration(rule.DedupMinutes) * time.Minute
+	}
+	return result, nil
+}
+
+// readHosts validates the hosts of the rule along with those in the file, blank lines and
+// comments are skipped and lines in hosts file format (0.0.0.0 example.com) use the host
+func readHosts(rule *Rule) (map[string]bool, error) {
+	hosts := make(map[string]bool, 0)
+	for _, host := range rule.Hosts {
+		if _, err := state.ParseRule(host); err != nil {
+			return nil, err
+		}
+		hosts[host] = true
+	}
+	if len(rule.HostsFile) == 0 {
+		return hosts, nil
+	}
+	file, err := os.Open(rule.HostsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.SplitN(scanner.Text(), "#", 2)[0])
+		if len(fields) == 0 {
+			continue
+		}
+		host := fields[len(fields)-1]
+		if _, err := state.ParseRule(host); err != nil {
+			return nil, err
+		}
+		hosts[host] = true
+	}
+	return hosts, scanner.Err()
+}
+
+func minuteOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day %s, expected 15:04", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+func (rule *compiled) matches(event *Event) bool {
+	if event.Name != rule.event {
+		return false
+	}
+	if len(rule.macs) > 0 && !rule.macs[strings.ToLower(event.Device.Mac)] {
+		return false
+	}
+	if rule.matcher != nil && !rule.matcher.Matches(event.Host) {
+		return false
+	}
+	if rule.window {
+		minute := event.At.Hour()*60 + event.At.Minute()
+		if rule.from <= rule.to {
+			return minute >= rule.from && minute < rule.to
+		}
+		return minute >= rule.from || minute < rule.to
+	}
+	return true
+}
+
+// Device : Check a device that has been seen against the rules
+func (engine *Engine) Device(device *state.Device) {
+	at := device.At
+	if at == nil || at.IsZero() {
+		now := time.Now()
+		at = &now
+	}
+	engine.Check(&Event{Name: DeviceEvent, Device: device, At: at})
+}
+
+// NewDevice : Check a device that has joined the network for the first time against the rules,
+// once it has had time to make its first requests
+func (engine *Engine) NewDevice(device *state.Device) {
+	if engine == nil || !engine.newDevices {
+		return
+	}
+	joined := *device.At
+	if joined.IsZero() {
+		joined = time.Now()
+	}
+	snapshot := *device
+	engine.after(firstRequestsDelay, func() {
+		requests, _, err := engine.store.SearchRequests(&state.RequestQuery{Mac: snapshot.Mac, From: &joined, Limit: maxFirstRequests})
+		if err != nil {
+			log.Printf("Error reading the first requests of %s: %v\n", snapshot.Mac, err)
+		}
+		engine.Check(&Event{Name: NewDeviceEvent, Device: &snapshot, At: &joined, Requests: requests})
+	})
+}
+
+// Request : Check a request made by the device against the rules
+func (engine *Engine) Request(device *state.Device, at *time.Time, host string, qtype string) {
+	engine.Check(&Event{Name: RequestEvent, Device: device, At: at, Host: host, Type: qtype})
+}
+
+// Check : Send an alert for each rule the event matches, unless the rule is cooling down
+// or has already alerted about the device and host recently
+func (engine *Engine) Check(event *Event) {
+	if engine == nil || len(engine.rules) == 0 {
+		return
+	}
+	device := *event.Device
+	event = &Event{event.Name, &device, event.At, event.Host, event.Type, event.Requests}
+	now := time.Now()
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	if engine.stopped {
+		return
+	}
+	for _, rule := range engine.rules {
+		rule.prune(now)
+		if !rule.matches(event) {
+			continue
+		}
+		key := device.Mac + " " + event.Host
+		if alerted, exists := rule.alerted[key]; exists && now.Sub(alerted) < rule.dedup {
+			continue
+		}
+		rule.alerted[key] = now
+		if rule.timer == nil && now.Sub(rule.lastSent) >= rule.cooldown {
+			engine.send(rule, []*Event{event}, 0, now)
+			continue
+		}
+		if len(rule.pending) < maxPending {
+			rule.pending = append(rule.pending, event)
+		} else {
+			rule.dropped++
+		}
+		if rule.timer == nil {
+			current := rule
+			rule.timer = time.AfterFunc(rule.lastSent.Add(rule.cooldown).Sub(now), func() { engine.flush(current) })
+		}
+	}
+}
+
+// flush sends the events held back while the rule was cooling down
+func (engine *Engine) flush(rule *compiled) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	rule.timer = nil
+	if len(rule.pending) > 0 {
+		engine.send(rule, rule.pending, rule.dropped, time.Now())
+	}
+	rule.pending = nil
+	rule.dropped = 0
+}
+
+// Stop : Stop waiting to send the alerts held back by cooling down rules,
+// and wait up to stopTimeout for the alerts that are waiting to be sent
+func (engine *Engine) Stop() {
+	if engine == nil {
+		return
+	}
+	engine.lock.Lock()
+	for _, rule := range engine.rules {
+		if rule.timer != nil {
+			rule.timer.Stop()
+			rule.timer = nil
+		}
+	}
+	if !engine.stopped {
+		engine.stopped = true
+		close(engine.deliveries)
+	}
+	engine.lock.Unlock()
+	select {
+	case <-engine.delivered:
+	case <-time.After(stopTimeout):
+		log.Printf("Stopped before %d alerts were sent\n", len(engine.deliveries))
+	}
+}
+
+// prune removes the devices and hosts that can be alerted about again, the lock must be held
+func (rule *compiled) prune(now time.Time) {
+	for key, alerted := range rule.alerted {
+		if now.Sub(alerted) >= rule.dedup {
+			delete(rule.alerted, key)
+		}
+	}
+}
+
+// send delivers the events, it is called holding the lock
+func (engine *Engine) send(rule *compiled, events []*Event, dropped int, now time.Time) {
+	rule.lastSent = now
+	log.Printf("Alert %s matched %d events\n", rule.Name, len(events)+dropped)
+	engine.deliver(rule.Rule, engine.content(rule, events, dropped))
+}
+
+// profile is the current profile of the device, the devices in events are not snapshots so do not have one
+func (engine *Engine) profile(device *state.Device) *state.Profile {
+	if engine.store == nil {
+		return device.Profile
+	}
+	return engine.store.GetProfile(device.Mac)
+}
+
+// content groups the events by device so they are formatted like a digest
+func (engine *Engine) content(rule *compiled, events []*Event, dropped int) *notify.Content {
+	devices := make(map[*state.Device]*map[string]*state.Host, 0)
+	byMac := make(map[string]*state.Device, 0)
+	seen := make([]string, 0)
+	for _, event := range events {
+		device, exists := byMac[event.Device.Mac]
+		if !exists {
+			hosts := make(map[string]*state.Host, 0)
+			device = &state.Device{At: event.Device.At, Hostname: event.Device.Hostname,
+				Mac: event.Device.Mac, IP: event.Device.IP, Requests: &hosts, Profile: engine.profile(event.Device)}
+			byMac[device.Mac] = device
+			devices[device] = device.Requests
+		}
+		switch event.Name {
+		case DeviceEvent:
+			seen = append(seen, fmt.Sprintf("%s %s (%s) was seen at %s", device.Name(), device.Mac,
+				device.IP, event.At.Format(timeFormat)))
+		case NewDeviceEvent:
+			seen = append(seen, fmt.Sprintf("a new device %s %s (%s, %s) joined the network at %s", device.Name(),
+				device.Mac, device.IP, device.Vendor(), event.At.Format(timeFormat)))
+			for _, request := range event.Requests {
+				addRequest(device, request.At, request.Host, request.Type)
+			}
+		default:
+			addRequest(device, event.At, event.Host, event.Type)
+		}
+	}
+	message := fmt.Sprintf("The %s alert matched %d events", rule.Name, len(events))
+	if len(events) == 1 {
+		message = fmt.Sprintf("The %s alert matched", rule.Name)
+	}
+	if len(seen) > 0 {
+		message += ": " + strings.Join(seen, ", ")
+	}
+	if dropped > 0 {
+		message += fmt.Sprintf(", %d more were left out", dropped)
+	}
+	return &notify.Content{
+		Devices: &devices,
+		Root:    engine.root,
+		Actions: engine.signer,
+		Title:   "Alert: " + rule.Name,
+		Message: message,
+	}
+}
+
+func addRequest(device *state.Device, at *time.Time, host string, qtype string) {
+	requested := *at
+	hosts := *device.Requests
+	if existing, exists := hosts[host]; exists {
+		existing.AddRequest(&requested, qtype)
+	} else {
+		hosts[host] = &state.Host{Host: host, Requests: []state.HostRequest{{At: &requested, Type: qtype}}}
+	}
+}
+
+// queue adds the alert to those waiting to be sent so that processing is not held up by the notifiers,
+// it is dropped when too many are waiting or the engine has stopped. The lock must be held
+func (engine *Engine) queue(rule *Rule, content *notify.Content) {
+	if engine.stopped {
+		log.Printf("Dropped the %s alert as alerts have stopped\n", rule.Name)
+		return
+	}
+	select {
+	case engine.deliveries <- &delivery{rule, content}:
+	default:
+		log.Printf("Dropped the %s alert as %d alerts are waiting to be sent\n", rule.Name, maxDeliveries)
+	}
+}
+
+// sendDeliveries sends the alerts that are waiting one at a time until the engine is stopped
+func (engine *Engine) sendDeliveries() {
+	defer close(engine.delivered)
+	for delivery := range engine.deliveries {
+		if err := delivery.rule.Send(delivery.content); err != nil {
+			log.Printf("Error sending the %s alert: %v\n", delivery.rule.Name, err)
+		}
+	}
+}
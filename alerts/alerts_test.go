@@ -0,0 +1,214 @@
+package alerts
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/notify"
+	"github.com/tmullender/network-log-monitor/oui"
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+var laptop = &state.Device{Hostname: "laptop", Mac: "AA:BB:CC:DD:EE:01", IP: "192.168.0.2"}
+var phone = &state.Device{Hostname: "phone", Mac: "AA:BB:CC:DD:EE:02", IP: "192.168.0.3"}
+
+func createEngine(t *testing.T, rules ...*Rule) (*Engine, chan *notify.Content) {
+	engine, err := NewEngine(rules, nil, "http://monitor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan *notify.Content, 100)
+	engine.deliver = func(rule *Rule, content *notify.Content) {
+		sent <- content
+	}
+	return engine, sent
+}
+
+func at(clock string) *time.Time {
+	parsed, _ := time.Parse("2006-01-02 15:04", "2019-05-24 "+clock)
+	return &parsed
+}
+
+func received(sent chan *notify.Content) []*notify.Content {
+	contents := make([]*notify.Content, 0)
+	for {
+		select {
+		case content := <-sent:
+			contents = append(contents, content)
+		default:
+			return contents
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	list := "/tmp/alert-hosts"
+	os.WriteFile(list, []byte("# blocked\n0.0.0.0 tracker.example.com\n\n*.ads.example.org # adverts\n"), 0644)
+	defer os.Remove(list)
+	engine, sent := createEngine(t,
+		&Rule{Name: "laptop-video", Macs: []string{"aa:bb:cc:dd:ee:01"}, Hosts: []string{"domain:youtube.com"}},
+		&Rule{Name: "blocklist", HostsFile: list},
+		&Rule{Name: "night", From: "23:00", To: "06:00"},
+		&Rule{Name: "devices", Event: DeviceEvent, Macs: []string{"AA:BB:CC:DD:EE:02"}})
+	for _, rule := range engine.rules {
+		rule.cooldown = 0
+	}
+
+	engine.Request(laptop, at("12:00"), "www.youtube.com", "A")
+	engine.Request(phone, at("12:00"), "www.youtube.com", "A")
+	engine.Request(phone, at("12:00"), "tracker.example.com", "A")
+	engine.Request(phone, at("12:01"), "pixel.ads.example.org", "AAAA")
+	engine.Request(phone, at("12:00"), "www.google.com", "A")
+	engine.Request(phone, at("23:30"), "www.google.com", "A")
+	engine.Request(laptop, at("05:59"), "www.google.com", "A")
+	engine.Request(laptop, at("06:00"), "www.bing.com", "A")
+	engine.Device(phone)
+	engine.Device(laptop)
+
+	titles := make([]string, 0)
+	for _, content := range received(sent) {
+		titles = append(titles, content.Title)
+	}
+	expected := "Alert: laptop-video,Alert: blocklist,Alert: blocklist,Alert: night,Alert: night,Alert: devices"
+	if strings.Join(titles, ",") != expected {
+		t.Errorf("unexpected alerts %v", titles)
+	}
+}
+
+func TestContent(t *testing.T) {
+	engine, sent := createEngine(t, &Rule{Name: "all"}, &Rule{Name: "seen", Event: DeviceEvent})
+	engine.Request(laptop, at("12:00"), "www.google.com", "A")
+	engine.Device(&state.Device{At: at("12:30"), Hostname: "phone", Mac: "AA:BB:CC:DD:EE:02", IP: "192.168.0.3"})
+	contents := received(sent)
+	if len(contents) != 2 {
+		t.Fatalf("expected 2 alerts %d", len(contents))
+	}
+	for device, hosts := range *contents[0].Devices {
+		if device.Mac != laptop.Mac || device == laptop || len((*hosts)["www.google.com"].Requests) != 1 {
+			t.Errorf("unexpected device %v %v", device, hosts)
+		}
+	}
+	if contents[0].Root != "http://monitor" || contents[0].Message != "The all alert matched" ||
+		contents[1].Message != "The seen alert matched: phone AA:BB:CC:DD:EE:02 (192.168.0.3) was seen at 2019-05-24 12:30:00" {
+		t.Errorf("unexpected content %v %v", contents[0], contents[1])
+	}
+}
+
+func TestNewDevice(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	oui.Use(map[string]string{"B827EB": "Raspberry Pi Foundation"})
+	engine, sent := createEngine(t, &Rule{Name: "joined", Event: NewDeviceEvent}, &Rule{Name: "seen", Event: DeviceEvent})
+	engine.store = store
+	var check func()
+	engine.after = func(delay time.Duration, scheduled func()) {
+		if delay != firstRequestsDelay {
+			t.Errorf("expected the alert to wait for the first requests %v", delay)
+		}
+		check = scheduled
+	}
+	joined := time.Now()
+	device, _ := store.AddDeviceLease(&joined, "pi", "192.168.0.4", "B8:27:EB:00:00:01")
+	engine.NewDevice(device)
+	store.AddRequest(device, &joined, "www.google.com", "A")
+	store.AddRequest(device, &joined, "time.google.com", "A")
+	if len(received(sent)) != 0 || check == nil {
+		t.Fatalf("expected the alert to wait for the first requests")
+	}
+	check()
+	contents := received(sent)
+	if len(contents) != 1 || !strings.Contains(contents[0].Message,
+		"a new device pi B8:27:EB:00:00:01 (192.168.0.4, Raspberry Pi Foundation) joined the network at") {
+		t.Fatalf("expected a new device alert %v", contents)
+	}
+	for _, hosts := range *contents[0].Devices {
+		if len(*hosts) != 2 {
+			t.Errorf("expected the first requests %v", hosts)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	engine, sent := createEngine(t, &Rule{Name: "all"})
+	engine.rules[0].cooldown = 0
+	engine.Request(laptop, at("12:00"), "www.google.com", "A")
+	engine.Request(laptop, at("12:01"), "www.google.com", "AAAA")
+	engine.Request(phone, at("12:02"), "www.google.com", "A")
+	if alerts := received(sent); len(alerts) != 2 {
+		t.Errorf("expected the repeated request to be left out %d", len(alerts))
+	}
+	engine.rules[0].dedup = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	engine.Request(laptop, at("12:03"), "www.google.com", "A")
+	if alerts := received(sent); len(alerts) != 1 || len(engine.rules[0].alerted) != 1 {
+		t.Errorf("expected the request to alert again %d %d", len(alerts), len(engine.rules[0].alerted))
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	engine, sent := createEngine(t, &Rule{Name: "all"})
+	defer engine.Stop()
+	engine.rules[0].cooldown = 100 * time.Millisecond
+	engine.Request(laptop, at("12:00"), "www.google.com", "A")
+	engine.Request(laptop, at("12:01"), "www.bing.com", "A")
+	engine.Request(phone, at("12:02"), "www.bing.com", "A")
+	engine.Request(phone, at("12:02"), "www.bing.com", "A")
+	if alerts := received(sent); len(alerts) != 1 {
+		t.Errorf("expected the later requests to be held back %d", len(alerts))
+	}
+	time.Sleep(200 * time.Millisecond)
+	alerts := received(sent)
+	if len(alerts) != 1 || len(*alerts[0].Devices) != 2 || alerts[0].Message != "The all alert matched 2 events" {
+		t.Fatalf("expected the held back requests to be sent together %v", alerts)
+	}
+}
+
+func TestDeliveries(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received <- string(body)
+	}))
+	defer server.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	engine, err := NewEngine([]*Rule{
+		{Name: "failing", Targets: notify.Targets{Webhook: &notify.WebhookConfig{URL: failing.URL}}},
+		{Name: "all", Targets: notify.Targets{Webhook: &notify.WebhookConfig{URL: server.URL}}},
+	}, nil, "http://monitor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Request(laptop, at("12:00"), "www.google.com", "A")
+	// stopping waits for the alerts that are waiting, later alerts are dropped
+	engine.Stop()
+	engine.Request(phone, at("12:01"), "www.bing.com", "A")
+	engine.Stop()
+	if len(received) != 1 || !strings.Contains(<-received, "www.google.com") {
+		t.Errorf("expected a single alert to be sent")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rule := range []*Rule{
+		{Name: "event", Event: "dhcp"},
+		{Name: "host", Hosts: []string{"/[/"}},
+		{Name: "file", HostsFile: "/tmp/missing-alert-hosts"},
+		{Name: "device", Event: DeviceEvent, Hosts: []string{"www.google.com"}},
+		{Name: "time", From: "23:00"},
+		{Name: "format", From: "11pm", To: "6am"},
+	} {
+		if _, err := NewEngine([]*Rule{rule}, nil, "", nil); err == nil {
+			t.Errorf("expected %s to be invalid", rule.Name)
+		}
+	}
+	var engine *Engine
+	engine.Request(laptop, at("12:00"), "www.google.com", "A")
+}
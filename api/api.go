@@ -0,0 +1,803 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/auth"
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+// Prefix : the path that the version 1 API is served under
+const Prefix = "/api/v1"
+
+const defaultLimit = 100
+const maxLimit = 1000
+const dateFormat = "2006-01-02"
+
+// Page : a page of results, total is the number of results before pagination
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+}
+
+// Error : the body returned when a request fails
+type Error struct {
+	Error string `json:"error"`
+}
+
+// DeviceContent : a device as returned by the API
+type DeviceContent struct {
+	Mac        string         `json:"mac"`
+	IP         string         `json:"ip"`
+	Hostname   string         `json:"hostname"`
+	Name       string         `json:"name"`
+	Owner      string         `json:"owner"`
+	Notes      string         `json:"notes"`
+	Vendor     string         `json:"vendor"`
+	Randomized bool           `json:"randomized"`
+	Status     string         `json:"status"`
+	FirstSeen  *time.Time     `json:"firstSeen"`
+	LastSeen   *time.Time     `json:"lastSeen"`
+	Ignored    bool           `json:"ignored"`
+	Aliases    []string       `json:"aliases"`
+	Group      string         `json:"group"`
+	Outcomes   map[string]int `json:"outcomes"`
+	Origins    map[string]int `json:"origins"`
+}
+
+// NewDomainContent : a host that a device requested for the first time
+type NewDomainContent struct {
+	Mac       string     `json:"mac"`
+	Hostname  string     `json:"hostname"`
+	Name      string     `json:"name"`
+	Host      string     `json:"host"`
+	FirstSeen *time.Time `json:"firstSeen"`
+	LastSeen  *time.Time `json:"lastSeen"`
+}
+
+// StatusContent : the status of a device, quarantined or approved
+type StatusContent struct {
+	Status string `json:"status"`
+}
+
+// ProfileContent : the friendly name, owner and notes of a device, empty values remove them
+type ProfileContent struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	Notes string `json:"notes"`
+}
+
+// DeviceGroupContent : the group a device is in, empty when it is not in one
+type DeviceGroupContent struct {
+	Group string `json:"group"`
+}
+
+// GroupContent : a device group with the rules that are authorized for its devices,
+// only the name is used when adding a group and only ignored when changing one
+type GroupContent struct {
+	Name    string         `json:"name"`
+	Ignored bool           `json:"ignored"`
+	Rules   []*RuleContent `json:"rules"`
+}
+
+// AliasContent : a device that has been, or is to be, merged into another
+type AliasContent struct {
+	Mac string `json:"mac"`
+}
+
+// MergeSuggestionContent : a device that looks like another device using a different MAC address
+type MergeSuggestionContent struct {
+	Primary string   `json:"primary"`
+	Alias   string   `json:"alias"`
+	Reasons []string `json:"reasons"`
+}
+
+// HostContent : the requests a device has made for a host
+type HostContent struct {
+	Host  string         `json:"host"`
+	Count int            `json:"count"`
+	First *time.Time     `json:"first"`
+	Last  *time.Time     `json:"last"`
+	Types map[string]int `json:"types"`
+	Times []*time.Time   `json:"times"`
+}
+
+// RequestContent : a request from the history, along with how it was answered when that is known
+type RequestContent struct {
+	Seq      uint64     `json:"seq"`
+	Mac      string     `json:"mac"`
+	At       *time.Time `json:"at"`
+	Host     string     `json:"host"`
+	Type     string     `json:"type"`
+	Outcome  string     `json:"outcome,omitempty"`
+	Upstream string     `json:"upstream,omitempty"`
+	Origin   string     `json:"origin,omitempty"`
+}
+
+// LatestContent : the requests that have not been delivered to a consumer, they are acknowledged
+// by posting the mark back, more is set when there were too many requests to include
+type LatestContent struct {
+	Consumer  string            `json:"consumer"`
+	Watermark uint64            `json:"watermark"`
+	Mark      uint64            `json:"mark"`
+	More      bool              `json:"more"`
+	Items     []*RequestContent `json:"items"`
+}
+
+// AcknowledgeContent : the body posted to acknowledge the requests up to the mark
+type AcknowledgeContent struct {
+	Consumer string `json:"consumer"`
+	Mark     uint64 `json:"mark"`
+}
+
+// RuleContent : an authorized host rule, the key identifies the rule when removing it
+type RuleContent struct {
+	Key     string `json:"key"`
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+}
+
+// IgnoredContent : an ignored device
+type IgnoredContent struct {
+	Mac string `json:"mac"`
+}
+
+// Devices : Returns a handler for listing devices, filtered by mac, ip, hostname (a case insensitive
+// substring of the hostname or name), owner, status, seenAfter and seenBefore
+func Devices(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet) {
+			return
+		}
+		offset, limit, err := pagination(req)
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		after, err := parseTime(req.FormValue("seenAfter"))
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		before, err := parseTime(req.FormValue("seenBefore"))
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		mac := strings.ToUpper(req.FormValue("mac"))
+		ip := req.FormValue("ip")
+		hostname := strings.ToLower(req.FormValue("hostname"))
+		owner := req.FormValue("owner")
+		status := req.FormValue("status")
+		statuses := store.GetDeviceStatuses()
+		devices := make([]*DeviceContent, 0)
+		for _, device := range store.GetDevices() {
+			content := deviceContent(store, device, statuses[device.Mac])
+			switch {
+			case len(mac) > 0 && strings.ToUpper(device.Mac) != mac,
+				len(status) > 0 && content.Status != status,
+				len(ip) > 0 && device.IP != ip,
+				len(hostname) > 0 && !strings.Contains(strings.ToLower(device.Hostname), hostname) &&
+					!strings.Contains(strings.ToLower(device.Name()), hostname),
+				len(owner) > 0 && device.Owner() != owner,
+				after != nil && (content.LastSeen == nil || content.LastSeen.Before(*after)),
+				before != nil && (content.LastSeen == nil || !content.LastSeen.Before(*before)):
+				continue
+			}
+			devices = append(devices, content)
+		}
+		start, end := pageBounds(len(devices), offset, limit)
+		writeJSON(resp, http.StatusOK, &Page{devices[start:end], len(devices), offset, limit})
+	}
+}
+
+// Device : Returns a handler for getting a device by MAC address at Prefix/devices/{mac},
+// its hosts are listed at Prefix/devices/{mac}/hosts optionally between from and to,
+// its status is changed by a PUT of a StatusContent to Prefix/devices/{mac}/status,
+// its name, owner and notes by a PUT of a ProfileContent to Prefix/devices/{mac}/profile
+// and other devices are merged into it by a POST of an AliasContent to Prefix/devices/{mac}/aliases
+// and split from it again by a DELETE with a mac parameter
+func Device(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(req.URL.Path, Prefix+"/devices/"), "/")
+		parts := strings.Split(path, "/")
+		if len(parts) > 2 || (len(parts) == 2 && parts[1] != "hosts" && parts[1] != "status" &&
+			parts[1] != "profile" && parts[1] != "aliases" && parts[1] != "group") {
+			writeError(resp, http.StatusNotFound, fmt.Errorf("Unknown resource: %s", req.URL.Path))
+			return
+		}
+		methods := []string{http.MethodGet}
+		if len(parts) == 2 && (parts[1] == "status" || parts[1] == "profile" || parts[1] == "group") {
+			methods = []string{http.MethodPut}
+		} else if len(parts) == 2 && parts[1] == "aliases" {
+			methods = []string{http.MethodPost, http.MethodDelete}
+		}
+		if !allowMethods(resp, req, methods...) {
+			return
+		}
+		device := store.GetDevice(parts[0])
+		if device == nil {
+			writeError(resp, http.StatusNotFound, fmt.Errorf("Unknown device: %s", parts[0]))
+			return
+		}
+		switch {
+		case len(parts) == 1:
+			writeJSON(resp, http.StatusOK, deviceContent(store, device, store.GetDeviceStatus(device.Mac)))
+		case parts[1] == "status":
+			setStatus(store, device, resp, req)
+		case parts[1] == "profile":
+			setProfile(store, device, resp, req)
+		case parts[1] == "aliases":
+			setAliases(store, device, resp, req)
+		case parts[1] == "group":
+			setGroup(store, device, resp, req)
+		default:
+			hosts(store, device, resp, req)
+		}
+	}
+}
+
+func setStatus(store *state.Store, device *state.Device, resp http.ResponseWriter, req *http.Request) {
+	var content StatusContent
+	if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	if err := store.SetDeviceStatus(device.Mac, content.Status); err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(resp, http.StatusOK, deviceContent(store, device, store.GetDeviceStatus(device.Mac)))
+}
+
+func setProfile(store *state.Store, device *state.Device, resp http.ResponseWriter, req *http.Request) {
+	var content ProfileContent
+	if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	profile := &state.Profile{Name: strings.TrimSpace(content.Name), Owner: strings.TrimSpace(content.Owner), Notes: content.Notes}
+	if err := store.SetProfile(device.Mac, profile); err != nil {
+		log.Printf("Error saving profile: %v\n", err)
+		writeError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	device = store.GetDevice(device.Mac)
+	writeJSON(resp, http.StatusOK, deviceContent(store, device, store.GetDeviceStatus(device.Mac)))
+}
+
+// setAliases merges the device in the body into the device or splits the device in the mac parameter from it
+func setGroup(store *state.Store, device *state.Device, resp http.ResponseWriter, req *http.Request) {
+	var content DeviceGroupContent
+	if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	if err := store.SetDeviceGroup(device.Mac, content.Group); err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	device = store.GetDevice(device.Mac)
+	writeJSON(resp, http.StatusOK, deviceContent(store, device, store.GetDeviceStatus(device.Mac)))
+}
+
+func setAliases(store *state.Store, device *state.Device, resp http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodDelete {
+		mac := req.FormValue("mac")
+		if store.Primary(mac) != device.Mac || mac == device.Mac {
+			writeError(resp, http.StatusNotFound, fmt.Errorf("%s has not been merged into %s", mac, device.Mac))
+			return
+		}
+		if err := store.SplitDevice(mac); err != nil {
+			log.Printf("Error splitting device: %v\n", err)
+			writeError(resp, http.StatusInternalServerError, err)
+			return
+		}
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var content AliasContent
+	if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	if err := store.MergeDevices(device.Mac, content.Mac); err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	device = store.GetDevice(device.Mac)
+	writeJSON(resp, http.StatusOK, deviceContent(store, device, store.GetDeviceStatus(device.Mac)))
+}
+
+func hosts(store *state.Store, device *state.Device, resp http.ResponseWriter, req *http.Request) {
+	offset, limit, err := pagination(req)
+	if err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	from, to, err := timeRange(req)
+	if err != nil {
+		writeError(resp, http.StatusBadRequest, err)
+		return
+	}
+	found, err := store.GetHosts(device.Mac, from, to)
+	if err != nil {
+		log.Printf("Error reading hosts: %v\n", err)
+		writeError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	start, end := pageBounds(len(found), offset, limit)
+	hosts := make([]*HostContent, 0, end-start)
+	for _, host := range found[start:end] {
+		times := host.Times()
+		hosts = append(hosts, &HostContent{host.Host, len(times), times[0], times[len(times)-1], host.TypeCounts(), times})
+	}
+	writeJSON(resp, http.StatusOK, &Page{hosts, len(found), offset, limit})
+}
+
+// NewDomains : Returns a handler for listing the hosts that devices requested for the first time
+// between from and to, most recent first, optionally for a single device by mac
+func NewDomains(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet) {
+			return
+		}
+		offset, limit, err := pagination(req)
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		from, to, err := timeRange(req)
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		found := store.GetNewHosts(req.FormValue("mac"), from, to)
+		start, end := pageBounds(len(found), offset, limit)
+		domains := make([]*NewDomainContent, 0, end-start)
+		for _, seen := range found[start:end] {
+			hostname, name := "", ""
+			if device := store.GetDevice(seen.Mac); device != nil {
+				hostname, name = device.Hostname, device.Name()
+			}
+			domains = append(domains, &NewDomainContent{seen.Mac, hostname, name, seen.Host, seen.First, seen.Last})
+		}
+		writeJSON(resp, http.StatusOK, &Page{domains, len(found), offset, limit})
+	}
+}
+
+// MergeSuggestions : Returns a handler for listing the devices that look like other devices
+// using different MAC addresses, so could be merged into them
+func MergeSuggestions(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet) {
+			return
+		}
+		offset, limit, err := pagination(req)
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		found := store.SuggestMerges()
+		start, end := pageBounds(len(found), offset, limit)
+		suggestions := make([]*MergeSuggestionContent, 0, end-start)
+		for _, suggestion := range found[start:end] {
+			suggestions = append(suggestions, &MergeSuggestionContent{suggestion.Primary, suggestion.Alias, suggestion.Reasons})
+		}
+		writeJSON(resp, http.StatusOK, &Page{suggestions, len(found), offset, limit})
+	}
+}
+
+// Requests : Returns a handler for searching the request history by mac, host and time,
+// the host is a pattern in the same form as an authorized host rule
+func Requests(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet) {
+			return
+		}
+		offset, limit, err := pagination(req)
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		from, to, err := timeRange(req)
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		found, total, err := store.SearchRequests(&state.RequestQuery{Mac: req.FormValue("mac"), Host: req.FormValue("host"),
+			From: from, To: to, Offset: offset, Limit: limit})
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		requests := make([]*RequestContent, 0, len(found))
+		for _, request := range found {
+			requests = append(requests, requestContent(request))
+		}
+		writeJSON(resp, http.StatusOK, &Page{requests, total, offset, limit})
+	}
+}
+
+// Latest : Returns a handler for getting (GET with consumer and limit parameters) the requests that have
+// not been delivered to a consumer and acknowledging (POST an AcknowledgeContent) their delivery,
+// only acknowledged requests are excluded the next time. Each user has their own consumers
+// and a mark after the last request is rejected
+func Latest(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet, http.MethodPost) {
+			return
+		}
+		if req.Method == http.MethodPost {
+			var content AcknowledgeContent
+			if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			if len(content.Consumer) == 0 {
+				writeError(resp, http.StatusBadRequest, fmt.Errorf("A consumer is required"))
+				return
+			}
+			if err := store.AdvanceWatermark(apiConsumer(req, content.Consumer), content.Mark); err == state.ErrUnknownMark {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			} else if err != nil {
+				log.Printf("Error advancing watermark: %v\n", err)
+				writeError(resp, http.StatusInternalServerError, err)
+				return
+			}
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+		consumer := req.FormValue("consumer")
+		if len(consumer) == 0 {
+			writeError(resp, http.StatusBadRequest, fmt.Errorf("A consumer is required"))
+			return
+		}
+		_, limit, err := pagination(req)
+		if err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+			return
+		}
+		watermark := store.GetWatermark(apiConsumer(req, consumer))
+		pending, mark, err := store.PendingRequests(apiConsumer(req, consumer))
+		if err != nil {
+			log.Printf("Error reading latest requests: %v\n", err)
+			writeError(resp, http.StatusInternalServerError, err)
+			return
+		}
+		more := len(pending) > limit
+		if more {
+			pending = pending[:limit]
+			mark = pending[limit-1].Seq
+		}
+		requests := make([]*RequestContent, 0, len(pending))
+		for _, request := range pending {
+			requests = append(requests, requestContent(request))
+		}
+		writeJSON(resp, http.StatusOK, &LatestContent{consumer, watermark, mark, more, requests})
+	}
+}
+
+// AuthorizedHosts : Returns a handler for listing (GET), adding (POST a RuleContent)
+// and removing (DELETE with a key parameter) authorized host rules
+func AuthorizedHosts(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet, http.MethodPost, http.MethodDelete) {
+			return
+		}
+		switch req.Method {
+		case http.MethodPost:
+			var content RuleContent
+			if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			key, err := state.NewRule(content.Type, content.Pattern)
+			if err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			store.AuthoriseHost(key)
+			rule, _ := state.ParseRule(key)
+			writeJSON(resp, http.StatusCreated, &RuleContent{rule.Key, rule.Type, rule.Pattern})
+		case http.MethodDelete:
+			key := req.FormValue("key")
+			if !(*store.GetAuthorisedHosts())[key] {
+				writeError(resp, http.StatusNotFound, fmt.Errorf("Unknown rule: %s", key))
+				return
+			}
+			store.DeauthoriseHost(key)
+			resp.WriteHeader(http.StatusNoContent)
+		default:
+			offset, limit, err := pagination(req)
+			if err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			rules := store.GetAuthorisedRules()
+			start, end := pageBounds(len(rules), offset, limit)
+			content := make([]*RuleContent, 0, end-start)
+			for _, rule := range rules[start:end] {
+				content = append(content, &RuleContent{rule.Key, rule.Type, rule.Pattern})
+			}
+			writeJSON(resp, http.StatusOK, &Page{content, len(rules), offset, limit})
+		}
+	}
+}
+
+// Groups : Returns a handler for listing (GET), adding (POST a GroupContent with a name)
+// and removing (DELETE with a name parameter) device groups
+func Groups(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet, http.MethodPost, http.MethodDelete) {
+			return
+		}
+		switch req.Method {
+		case http.MethodPost:
+			var content GroupContent
+			if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			if err := store.AddGroup(content.Name); err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(resp, http.StatusCreated, groupContent(store.GetGroup(strings.TrimSpace(content.Name))))
+		case http.MethodDelete:
+			if err := store.RemoveGroup(req.FormValue("name")); err != nil {
+				writeError(resp, http.StatusNotFound, err)
+				return
+			}
+			resp.WriteHeader(http.StatusNoContent)
+		default:
+			offset, limit, err := pagination(req)
+			if err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			groups := store.GetGroups()
+			start, end := pageBounds(len(groups), offset, limit)
+			content := make([]*GroupContent, 0, end-start)
+			for _, group := range groups[start:end] {
+				content = append(content, groupContent(group))
+			}
+			writeJSON(resp, http.StatusOK, &Page{content, len(groups), offset, limit})
+		}
+	}
+}
+
+// Group : Returns a handler for getting (GET) a device group at Prefix/groups/{name}, ignoring or no longer
+// ignoring its devices (PUT a GroupContent) and adding (POST a RuleContent) and removing (DELETE with a key
+// parameter) the rules that are authorized for its devices at Prefix/groups/{name}/authorized-hosts
+func Group(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(req.URL.Path, Prefix+"/groups/"), "/")
+		parts := strings.Split(path, "/")
+		if len(parts) > 2 || (len(parts) == 2 && parts[1] != "authorized-hosts") {
+			writeError(resp, http.StatusNotFound, fmt.Errorf("Unknown resource: %s", req.URL.Path))
+			return
+		}
+		methods := []string{http.MethodGet, http.MethodPut}
+		if len(parts) == 2 {
+			methods = []string{http.MethodPost, http.MethodDelete}
+		}
+		if !allowMethods(resp, req, methods...) {
+			return
+		}
+		group := store.GetGroup(parts[0])
+		if group == nil {
+			writeError(resp, http.StatusNotFound, fmt.Errorf("Unknown group: %s", parts[0]))
+			return
+		}
+		switch {
+		case req.Method == http.MethodGet:
+			writeJSON(resp, http.StatusOK, groupContent(group))
+		case req.Method == http.MethodPut:
+			var content GroupContent
+			if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			if err := store.SetGroupIgnored(group.Name, content.Ignored); err != nil {
+				writeError(resp, http.StatusInternalServerError, err)
+				return
+			}
+			writeJSON(resp, http.StatusOK, groupContent(store.GetGroup(group.Name)))
+		case req.Method == http.MethodPost:
+			var content RuleContent
+			if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			key, err := state.NewRule(content.Type, content.Pattern)
+			if err == nil {
+				err = store.AuthoriseGroupHost(group.Name, key)
+			}
+			if err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			rule, _ := state.ParseRule(key)
+			writeJSON(resp, http.StatusCreated, &RuleContent{rule.Key, rule.Type, rule.Pattern})
+		default:
+			key := req.FormValue("key")
+			if !group.Authorized[key] {
+				writeError(resp, http.StatusNotFound, fmt.Errorf("Unknown rule: %s", key))
+				return
+			}
+			if err := store.DeauthoriseGroupHost(group.Name, key); err != nil {
+				writeError(resp, http.StatusInternalServerError, err)
+				return
+			}
+			resp.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func groupContent(group *state.Group) *GroupContent {
+	content := &GroupContent{group.Name, group.Ignored, make([]*RuleContent, 0)}
+	for _, rule := range group.Rules() {
+		content.Rules = append(content.Rules, &RuleContent{rule.Key, rule.Type, rule.Pattern})
+	}
+	return content
+}
+
+// IgnoredDevices : Returns a handler for listing (GET), adding (POST an IgnoredContent)
+// and removing (DELETE with a mac parameter) ignored devices
+func IgnoredDevices(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !allowMethods(resp, req, http.MethodGet, http.MethodPost, http.MethodDelete) {
+			return
+		}
+		switch req.Method {
+		case http.MethodPost:
+			var content IgnoredContent
+			if err := json.NewDecoder(req.Body).Decode(&content); err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			if len(content.Mac) == 0 {
+				writeError(resp, http.StatusBadRequest, fmt.Errorf("A mac is required"))
+				return
+			}
+			store.IgnoreDevice(content.Mac)
+			writeJSON(resp, http.StatusCreated, &content)
+		case http.MethodDelete:
+			mac := req.FormValue("mac")
+			if !store.IsIgnored(mac) {
+				writeError(resp, http.StatusNotFound, fmt.Errorf("Unknown device: %s", mac))
+				return
+			}
+			store.UnIgnoreDevice(mac)
+			resp.WriteHeader(http.StatusNoContent)
+		default:
+			offset, limit, err := pagination(req)
+			if err != nil {
+				writeError(resp, http.StatusBadRequest, err)
+				return
+			}
+			macs := make([]string, 0)
+			for mac := range *store.GetIgnoredDevices() {
+				macs = append(macs, mac)
+			}
+			sort.Strings(macs)
+			start, end := pageBounds(len(macs), offset, limit)
+			content := make([]*IgnoredContent, 0, end-start)
+			for _, mac := range macs[start:end] {
+				content = append(content, &IgnoredContent{mac})
+			}
+			writeJSON(resp, http.StatusOK, &Page{content, len(macs), offset, limit})
+		}
+	}
+}
+
+// apiConsumer is the name that the watermark of the user's consumer is kept under, so that
+// API users cannot move the watermarks of the digests or of each other's consumers
+func apiConsumer(req *http.Request, consumer string) string {
+	return fmt.Sprintf("api/%s/%s", auth.UserName(req), consumer)
+}
+
+// deviceContent uses the status when it has been recorded, otherwise the device is
+// approved and when it was first seen is not known
+func deviceContent(store *state.Store, device *state.Device, status *state.DeviceStatus) *DeviceContent {
+	content := &DeviceContent{device.Mac, device.IP, device.Hostname, device.Name(), device.Owner(), device.Notes(),
+		device.Vendor(), device.Randomized(), state.Approved,
+		nil, store.LastSeen(device.Mac), store.IsIgnored(device.Mac), device.Aliases, device.Group, device.OutcomeCounts(), device.OriginCounts()}
+	if status != nil {
+		content.Status = status.Status
+		content.FirstSeen = status.FirstSeen
+	}
+	return content
+}
+
+func requestContent(request *state.DeviceRequest) *RequestContent {
+	return &RequestContent{request.Seq, request.Mac, request.At, request.Host, request.Type, request.Outcome, request.Upstream, request.Origin}
+}
+
+// allowMethods writes a 405 response if the request does not use one of the methods
+func allowMethods(resp http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if req.Method == method {
+			return true
+		}
+	}
+	resp.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(resp, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", req.Method))
+	return false
+}
+
+// pagination reads the offset and limit parameters, the limit defaults to defaultLimit
+func pagination(req *http.Request) (int, int, error) {
+	offset, limit := 0, defaultLimit
+	var err error
+	if value := req.FormValue("offset"); len(value) > 0 {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("Invalid offset: %s", value)
+		}
+	}
+	if value := req.FormValue("limit"); len(value) > 0 {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxLimit {
+			return 0, 0, fmt.Errorf("Invalid limit, it must be between 1 and %d: %s", maxLimit, value)
+		}
+	}
+	return offset, limit, nil
+}
+
+func pageBounds(total int, offset int, limit int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+func timeRange(req *http.Request) (*time.Time, *time.Time, error) {
+	from, err := parseTime(req.FormValue("from"))
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := parseTime(req.FormValue("to"))
+	return from, to, err
+}
+
+// parseTime accepts RFC 3339 times or dates in the local timezone, nil is returned for an empty value
+func parseTime(value string) (*time.Time, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return &at, nil
+	}
+	at, err := time.ParseInLocation(dateFormat, value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time, use RFC 3339 or %s: %s", dateFormat, value)
+	}
+	return &at, nil
+}
+
+func writeJSON(resp http.ResponseWriter, status int, body interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	err := json.NewEncoder(resp).Encode(body)
+	if err != nil {
+		log.Printf("Error writing response: %v\n", err)
+	}
+}
+
+func writeError(resp http.ResponseWriter, status int, err error) {
+	writeJSON(resp, status, &Error{err.Error()})
+}
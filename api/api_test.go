@@ -0,0 +1,362 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+func createStore() *state.Store {
+	store, _ := state.NewStore("memory://")
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	first := store.AddDevice(&at, "laptop", "192.168.0.2", "AA:BB:CC:DD:EE:01")
+	second := store.AddDevice(&at, "phone", "192.168.0.3", "AA:BB:CC:DD:EE:02")
+	for i := 0; i < 3; i++ {
+		next := at.Add(time.Duration(i) * time.Hour)
+		store.AddRequest(first, &next, "www.google.com", "A")
+		store.AddRequest(first, &next, "mail.google.com", "AAAA")
+	}
+	later := at.AddDate(0, 0, 1)
+	store.AddRequest(second, &later, "www.example.com", "A")
+	return store
+}
+
+func call(handler func(http.ResponseWriter, *http.Request), method string, url string, body string) (*httptest.ResponseRecorder, *Page) {
+	resp := httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(method, url, strings.NewReader(body)))
+	var page Page
+	json.Unmarshal(resp.Body.Bytes(), &page)
+	return resp, &page
+}
+
+func TestDevices(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := Devices(store)
+	checks := map[string]int{
+		"/api/v1/devices":                                 1,
+		"/api/v1/devices?hostname=LAP":                    1,
+		"/api/v1/devices?mac=aa:bb:cc:dd:ee:02":           1,
+		"/api/v1/devices?ip=192.168.0.9":                  0,
+		"/api/v1/devices?seenAfter=2019-05-25":            1,
+		"/api/v1/devices?seenBefore=2019-05-24T14:30:00Z": 1,
+		"/api/v1/devices?offset=1":                        1,
+		"/api/v1/devices?offset=5":                        0,
+	}
+	for url, count := range checks {
+		separator := "?"
+		if strings.Contains(url, "?") {
+			separator = "&"
+		}
+		resp, page := call(handler, "GET", url+separator+"limit=1", "")
+		if resp.Code != http.StatusOK || len(page.Items.([]interface{})) != count || page.Limit != 1 {
+			t.Errorf("%s: unexpected response %d %s", url, resp.Code, resp.Body.String())
+		}
+	}
+	if resp, page := call(handler, "GET", "/api/v1/devices", ""); page.Total != 2 || resp.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected devices %s", resp.Body.String())
+	}
+	for _, url := range []string{"/api/v1/devices?limit=0", "/api/v1/devices?offset=-1", "/api/v1/devices?seenAfter=yesterday"} {
+		if resp, _ := call(handler, "GET", url, ""); resp.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request %d", url, resp.Code)
+		}
+	}
+	if resp, _ := call(handler, "POST", "/api/v1/devices", ""); resp.Code != http.StatusMethodNotAllowed || resp.Header().Get("Allow") != "GET" {
+		t.Errorf("expected method not allowed %d", resp.Code)
+	}
+}
+
+func TestDevice(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := Device(store)
+	resp, _ := call(handler, "GET", "/api/v1/devices/AA:BB:CC:DD:EE:01", "")
+	var device DeviceContent
+	json.Unmarshal(resp.Body.Bytes(), &device)
+	if resp.Code != http.StatusOK || device.Hostname != "laptop" || device.LastSeen.Hour() != 14 {
+		t.Errorf("unexpected device %s", resp.Body.String())
+	}
+	resp, page := call(handler, "GET", "/api/v1/devices/AA:BB:CC:DD:EE:01/hosts?from=2019-05-24T13:00:00Z", "")
+	hosts := page.Items.([]interface{})
+	if resp.Code != http.StatusOK || page.Total != 2 || hosts[0].(map[string]interface{})["host"] != "mail.google.com" ||
+		hosts[0].(map[string]interface{})["count"].(float64) != 2 {
+		t.Errorf("unexpected hosts %s", resp.Body.String())
+	}
+	for _, url := range []string{"/api/v1/devices/AA:BB:CC:DD:EE:09", "/api/v1/devices/AA:BB:CC:DD:EE:01/other"} {
+		if resp, _ := call(handler, "GET", url, ""); resp.Code != http.StatusNotFound {
+			t.Errorf("%s: expected not found %d", url, resp.Code)
+		}
+	}
+}
+
+func TestDeviceStatus(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := Device(store)
+	resp, _ := call(handler, "PUT", "/api/v1/devices/AA:BB:CC:DD:EE:01/status", `{"status":"quarantined"}`)
+	var device DeviceContent
+	json.Unmarshal(resp.Body.Bytes(), &device)
+	if resp.Code != http.StatusOK || device.Status != state.Quarantined || device.Vendor != "Unknown" {
+		t.Errorf("unexpected device %d %s", resp.Code, resp.Body.String())
+	}
+	if resp, page := call(Devices(store), "GET", "/api/v1/devices?status=quarantined", ""); resp.Code != http.StatusOK || page.Total != 1 {
+		t.Errorf("expected one quarantined device %s", resp.Body.String())
+	}
+	if resp, _ := call(handler, "PUT", "/api/v1/devices/AA:BB:CC:DD:EE:01/status", `{"status":"trusted"}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown status to be rejected %d", resp.Code)
+	}
+	if resp, _ := call(handler, "GET", "/api/v1/devices/AA:BB:CC:DD:EE:01/status", ""); resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected the status to only be set %d", resp.Code)
+	}
+}
+
+func TestDeviceProfile(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := Device(store)
+	resp, _ := call(handler, "PUT", "/api/v1/devices/AA:BB:CC:DD:EE:02/profile", `{"name":" Sam's phone ","owner":"Sam","notes":"Work"}`)
+	var device DeviceContent
+	json.Unmarshal(resp.Body.Bytes(), &device)
+	if resp.Code != http.StatusOK || device.Name != "Sam's phone" || device.Hostname != "phone" || device.Owner != "Sam" || device.Notes != "Work" {
+		t.Errorf("unexpected device %d %s", resp.Code, resp.Body.String())
+	}
+	checks := map[string]int{
+		"/api/v1/devices?owner=Sam":        1,
+		"/api/v1/devices?owner=Alex":       0,
+		"/api/v1/devices?hostname=sam%27s": 1,
+	}
+	for url, total := range checks {
+		if resp, page := call(Devices(store), "GET", url, ""); resp.Code != http.StatusOK || page.Total != total {
+			t.Errorf("%s: unexpected response %d %s", url, resp.Code, resp.Body.String())
+		}
+	}
+	if resp, _ := call(handler, "PUT", "/api/v1/devices/AA:BB:CC:DD:EE:02/profile", `{"name":`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid JSON to be rejected %d", resp.Code)
+	}
+	if resp, _ := call(handler, "GET", "/api/v1/devices/AA:BB:CC:DD:EE:02/profile", ""); resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected the profile to only be set %d", resp.Code)
+	}
+}
+
+func TestDeviceAliases(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := Device(store)
+	at := time.Date(2019, 5, 25, 12, 0, 0, 0, time.UTC)
+	store.AddDevice(&at, "phone", "192.168.0.4", "02:BB:CC:DD:EE:03")
+	if resp, page := call(MergeSuggestions(store), "GET", "/api/v1/merge-suggestions", ""); resp.Code != http.StatusOK || page.Total != 1 ||
+		page.Items.([]interface{})[0].(map[string]interface{})["alias"] != "02:BB:CC:DD:EE:03" {
+		t.Errorf("expected the phone to be suggested %s", resp.Body.String())
+	}
+	resp, _ := call(handler, "POST", "/api/v1/devices/AA:BB:CC:DD:EE:02/aliases", `{"mac":"02:BB:CC:DD:EE:03"}`)
+	var device DeviceContent
+	json.Unmarshal(resp.Body.Bytes(), &device)
+	if resp.Code != http.StatusOK || len(device.Aliases) != 1 || device.Aliases[0] != "02:BB:CC:DD:EE:03" {
+		t.Errorf("unexpected device %d %s", resp.Code, resp.Body.String())
+	}
+	if resp, page := call(Devices(store), "GET", "/api/v1/devices", ""); resp.Code != http.StatusOK || page.Total != 2 {
+		t.Errorf("expected the merged device to be left out %s", resp.Body.String())
+	}
+	if resp, _ := call(handler, "POST", "/api/v1/devices/AA:BB:CC:DD:EE:01/aliases", `{"mac":"02:BB:CC:DD:EE:03"}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a merged device to be rejected %d", resp.Code)
+	}
+	if resp, _ := call(handler, "DELETE", "/api/v1/devices/AA:BB:CC:DD:EE:01/aliases?mac=02:BB:CC:DD:EE:03", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("expected the device to only be split from the device it was merged into %d", resp.Code)
+	}
+	if resp, _ := call(handler, "DELETE", "/api/v1/devices/AA:BB:CC:DD:EE:02/aliases?mac=02:BB:CC:DD:EE:03", ""); resp.Code != http.StatusNoContent ||
+		store.GetDevice("02:BB:CC:DD:EE:03").Mac != "02:BB:CC:DD:EE:03" {
+		t.Errorf("expected the device to be split %d", resp.Code)
+	}
+}
+
+func TestNewDomains(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := NewDomains(store)
+	checks := map[string]int{
+		"/api/v1/new-domains":                                     3,
+		"/api/v1/new-domains?mac=AA:BB:CC:DD:EE:01":               2,
+		"/api/v1/new-domains?from=2019-05-24T12:30:00Z":           1,
+		"/api/v1/new-domains?to=2019-05-24T12:30:00Z&limit=1":     2,
+		"/api/v1/new-domains?mac=AA:BB:CC:DD:EE:02&to=2019-05-24": 0,
+	}
+	for url, total := range checks {
+		if resp, page := call(handler, "GET", url, ""); resp.Code != http.StatusOK || page.Total != total {
+			t.Errorf("%s: unexpected response %d %s", url, resp.Code, resp.Body.String())
+		}
+	}
+	resp, page := call(handler, "GET", "/api/v1/new-domains", "")
+	domain := page.Items.([]interface{})[0].(map[string]interface{})
+	if domain["host"] != "www.example.com" || domain["hostname"] != "phone" {
+		t.Errorf("expected the most recent first %s", resp.Body.String())
+	}
+	if resp, _ := call(handler, "GET", "/api/v1/new-domains?from=yesterday", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid time to be rejected %d", resp.Code)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := Requests(store)
+	checks := map[string]int{
+		"/api/v1/requests":                                             7,
+		"/api/v1/requests?host=*.google.com":                           6,
+		"/api/v1/requests?host=/^www/":                                 4,
+		"/api/v1/requests?host=www.google.com&to=2019-05-24T13:00:00Z": 1,
+		"/api/v1/requests?mac=AA:BB:CC:DD:EE:02":                       1,
+		"/api/v1/requests?from=2019-05-25":                             1,
+	}
+	for url, total := range checks {
+		if resp, page := call(handler, "GET", url, ""); resp.Code != http.StatusOK || page.Total != total {
+			t.Errorf("%s: unexpected response %d %s", url, resp.Code, resp.Body.String())
+		}
+	}
+	resp, page := call(handler, "GET", "/api/v1/requests?limit=2&offset=6", "")
+	items := page.Items.([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["host"] != "www.example.com" {
+		t.Errorf("unexpected page %s", resp.Body.String())
+	}
+	if resp, _ := call(handler, "GET", "/api/v1/requests?host=/[/", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request %d", resp.Code)
+	}
+}
+
+func TestAuthorizedHosts(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	handler := AuthorizedHosts(store)
+	resp, _ := call(handler, "POST", "/api/v1/authorized-hosts", `{"type": "wildcard", "pattern": "google.com"}`)
+	if resp.Code != http.StatusCreated || !store.IsAuthorised("www.google.com") {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	if resp, _ := call(handler, "POST", "/api/v1/authorized-hosts", `{"type": "regex", "pattern": "["}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request %d", resp.Code)
+	}
+	if resp, page := call(handler, "GET", "/api/v1/authorized-hosts", ""); page.Total != 1 || !strings.Contains(resp.Body.String(), `"key":"*.google.com"`) {
+		t.Errorf("unexpected rules %s", resp.Body.String())
+	}
+	if resp, _ := call(handler, "DELETE", "/api/v1/authorized-hosts?key=*.google.com", ""); resp.Code != http.StatusNoContent || store.IsAuthorised("www.google.com") {
+		t.Errorf("unexpected delete %d", resp.Code)
+	}
+	if resp, _ := call(handler, "DELETE", "/api/v1/authorized-hosts?key=*.google.com", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("expected not found %d", resp.Code)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	if resp, _ := call(Groups(store), "POST", "/api/v1/groups", `{"name":"kids"}`); resp.Code != http.StatusCreated {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	if resp, _ := call(Groups(store), "POST", "/api/v1/groups", `{"name":"kids"}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a duplicate group to be rejected %d", resp.Code)
+	}
+	handler := Group(store)
+	if resp, _ := call(handler, "POST", "/api/v1/groups/kids/authorized-hosts", `{"type":"wildcard","pattern":"example.com"}`); resp.Code != http.StatusCreated {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	resp, _ := call(Device(store), "PUT", "/api/v1/devices/AA:BB:CC:DD:EE:02/group", `{"group":"kids"}`)
+	var device DeviceContent
+	json.Unmarshal(resp.Body.Bytes(), &device)
+	if resp.Code != http.StatusOK || device.Group != "kids" {
+		t.Errorf("unexpected device %d %s", resp.Code, resp.Body.String())
+	}
+	if !store.IsAuthorisedFor(device.Mac, "www.example.com") || store.IsAuthorisedFor("AA:BB:CC:DD:EE:01", "www.example.com") {
+		t.Error("expected the rule to only authorize the host for the group")
+	}
+	resp, _ = call(handler, "PUT", "/api/v1/groups/kids", `{"ignored":true}`)
+	var group GroupContent
+	json.Unmarshal(resp.Body.Bytes(), &group)
+	if resp.Code != http.StatusOK || !group.Ignored || len(group.Rules) != 1 || group.Rules[0].Key != "*.example.com" || !store.IsIgnored(device.Mac) {
+		t.Errorf("unexpected group %d %s", resp.Code, resp.Body.String())
+	}
+	if resp, _ := call(handler, "DELETE", "/api/v1/groups/kids/authorized-hosts?key=*.example.com", ""); resp.Code != http.StatusNoContent {
+		t.Errorf("expected the rule to be removed %d", resp.Code)
+	}
+	checks := map[string]int{
+		"/api/v1/groups/kids/authorized-hosts?key=*.example.com": http.StatusNotFound,
+		"/api/v1/groups/work/authorized-hosts?key=*.example.com": http.StatusNotFound,
+		"/api/v1/groups/kids/devices":                            http.StatusNotFound,
+	}
+	for url, code := range checks {
+		if resp, _ := call(handler, "DELETE", url, ""); resp.Code != code {
+			t.Errorf("%s: unexpected response %d %s", url, resp.Code, resp.Body.String())
+		}
+	}
+	if resp, _ := call(Device(store), "PUT", "/api/v1/devices/AA:BB:CC:DD:EE:01/group", `{"group":"work"}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown group to be rejected %d", resp.Code)
+	}
+	if resp, page := call(Groups(store), "GET", "/api/v1/groups", ""); resp.Code != http.StatusOK || page.Total != 1 {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	if resp, _ := call(Groups(store), "DELETE", "/api/v1/groups?name=kids", ""); resp.Code != http.StatusNoContent || store.GetDevice(device.Mac).Group != "" {
+		t.Errorf("expected the group to be removed %d", resp.Code)
+	}
+}
+
+func TestIgnoredDevices(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	handler := IgnoredDevices(store)
+	if resp, _ := call(handler, "POST", "/api/v1/ignored-devices", `{"mac": "AA:BB:CC:DD:EE:01"}`); resp.Code != http.StatusCreated || !store.IsIgnored("AA:BB:CC:DD:EE:01") {
+		t.Errorf("unexpected response %d", resp.Code)
+	}
+	if resp, _ := call(handler, "POST", "/api/v1/ignored-devices", `{}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request %d", resp.Code)
+	}
+	if _, page := call(handler, "GET", "/api/v1/ignored-devices", ""); page.Total != 1 {
+		t.Fail()
+	}
+	if resp, _ := call(handler, "DELETE", "/api/v1/ignored-devices?mac=AA:BB:CC:DD:EE:01", ""); resp.Code != http.StatusNoContent || store.IsIgnored("AA:BB:CC:DD:EE:01") {
+		t.Errorf("unexpected delete %d", resp.Code)
+	}
+	if resp, _ := call(handler, "PUT", "/api/v1/ignored-devices", ""); resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected method not allowed %d", resp.Code)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	store := createStore()
+	defer store.Close()
+	handler := Latest(store)
+	read := func() *LatestContent {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest("GET", "/api/v1/latest?consumer=script&limit=5", nil))
+		var content LatestContent
+		json.Unmarshal(resp.Body.Bytes(), &content)
+		return &content
+	}
+	first := read()
+	if len(first.Items) != 5 || !first.More || first.Watermark != 0 || first.Mark != first.Items[4].Seq {
+		t.Errorf("unexpected first page %v", first)
+	}
+	if again := read(); again.Mark != first.Mark {
+		t.Errorf("expected the requests to be returned until they are acknowledged %v", again)
+	}
+	resp, _ := call(handler, "POST", "/api/v1/latest", fmt.Sprintf(`{"consumer": "script", "mark": %d}`, first.Mark))
+	second := read()
+	if resp.Code != http.StatusNoContent || len(second.Items) != 2 || second.More || second.Watermark != first.Mark ||
+		second.Items[1].Host != "www.example.com" {
+		t.Errorf("unexpected second page %d %v", resp.Code, second)
+	}
+	for _, body := range []string{`{"mark": 1}`, `mark`, fmt.Sprintf(`{"consumer": "script", "mark": %d}`, first.Mark+100)} {
+		if resp, _ := call(handler, "POST", "/api/v1/latest", body); resp.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request %d", body, resp.Code)
+		}
+	}
+	if resp, _ := call(handler, "GET", "/api/v1/latest", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a consumer to be required %d", resp.Code)
+	}
+	call(handler, "POST", "/api/v1/latest", fmt.Sprintf(`{"consumer": "%s", "mark": %d}`, state.DefaultConsumer, first.Mark))
+	if mark := store.GetWatermark(state.DefaultConsumer); mark != 0 {
+		t.Errorf("expected the digest's watermark not to move %d", mark)
+	}
+}
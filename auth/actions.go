@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+// The actions that can be performed with a signed link
+const (
+	AllowAction  = "allow"
+	IgnoreAction = "ignore"
+)
+
+const actionsSecret = "actions"
+
+// The reasons that a signed link can be rejected
+var (
+	ErrInvalidToken = errors.New("The link is not valid")
+	ErrExpiredToken = errors.New("The link has expired")
+	ErrUsedToken    = errors.New("The link has already been used")
+)
+
+// Action : an action that a signed link can perform without logging in,
+// the value is the authorized host rule or the MAC address of the device
+type Action struct {
+	Name    string
+	Value   string
+	Expires time.Time
+	nonce   string
+}
+
+// Signer : creates and checks tokens for signed links, each token can only be used once
+type Signer struct {
+	store    *state.Store
+	secret   []byte
+	validFor time.Duration
+}
+
+// NewSigner : Create a Signer using the secret held by the store, tokens expire after validFor
+func NewSigner(store *state.Store, validFor time.Duration) (*Signer, error) {
+	secret, err := store.Secret(actionsSecret, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{store, secret, validFor}, nil
+}
+
+// Sign : Create a token for the action
+func (signer *Signer) Sign(name string, value string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	expires := time.Now().Add(signer.validFor).Unix()
+	payload := strings.Join([]string{name, value, strconv.FormatInt(expires, 10), base64.RawURLEncoding.EncodeToString(nonce)}, "\n")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signer.mac(payload)), nil
+}
+
+// Verify : Check the token without using it, returning the action it performs
+func (signer *Signer) Verify(token string) (*Action, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, signer.mac(string(payload))) {
+		return nil, ErrInvalidToken
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 4 {
+		return nil, ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	action := &Action{fields[0], fields[1], time.Unix(expires, 0), fields[3]}
+	if action.Expires.Before(time.Now()) {
+		return nil, ErrExpiredToken
+	}
+	if signer.store.IsNonceUsed(action.nonce) {
+		return nil, ErrUsedToken
+	}
+	return action, nil
+}
+
+// Use : Check the token and mark it as used, returning the action it performs
+func (signer *Signer) Use(token string) (*Action, error) {
+	action, err := signer.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if !signer.store.UseNonce(action.nonce, &action.Expires) {
+		return nil, ErrUsedToken
+	}
+	return action, nil
+}
+
+func (signer *Signer) mac(payload string) []byte {
+	hash := hmac.New(sha256.New, signer.secret)
+	hash.Write([]byte(payload))
+	return hash.Sum(nil)
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CookieName : the name of the cookie holding the session id
+const CookieName = "network-log-session"
+
+// CSRFField : the form field that must contain the CSRF token of the session in a POST
+const CSRFField = "csrf"
+
+// CSRFHeader : the header that can contain the CSRF token instead of the form field
+const CSRFHeader = "X-CSRF-Token"
+
+// SessionDuration : how long a session lasts after logging in
+const SessionDuration = 7 * 24 * time.Hour
+
+type contextKey int
+
+const sessionKey contextKey = 0
+
+// AddUser : adds a user, or changes the password of an existing user
+func AddUser(store *state.Store, name string, password string) error {
+	if len(name) == 0 || len(password) == 0 {
+		return fmt.Errorf("A name and password are required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return store.SaveUser(&state.User{Name: name, Hash: hash})
+}
+
+// Authenticate : whether the password is correct for the user
+func Authenticate(store *state.Store, name string, password string) bool {
+	user := store.GetUser(name)
+	if user == nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(user.Hash, []byte(password)) == nil
+}
+
+// StartSession : creates a session for the user and sets its cookie on the response
+func StartSession(store *state.Store, resp http.ResponseWriter, req *http.Request, name string) (*state.Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(SessionDuration)
+	session := &state.Session{User: name, CSRF: csrf, Expires: &expires}
+	if err = store.SaveSession(hashID(id), session); err != nil {
+		return nil, err
+	}
+	http.SetCookie(resp, &http.Cookie{Name: CookieName, Value: id, Path: "/", Expires: expires,
+		HttpOnly: true, Secure: req.TLS != nil, SameSite: http.SameSiteLaxMode})
+	log.Printf("Started session for %s from %s\n", name, req.RemoteAddr)
+	return session, nil
+}
+
+// EndSession : removes the session of the request and clears its cookie
+func EndSession(store *state.Store, resp http.ResponseWriter, req *http.Request) {
+	if cookie, err := req.Cookie(CookieName); err == nil {
+		err = store.RemoveSession(hashID(cookie.Value))
+		if err != nil {
+			log.Printf("Error ending session: %v\n", err)
+		}
+	}
+	http.SetCookie(resp, &http.Cookie{Name: CookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+// Require : Returns a handler that only calls the handler for logged in users and logs who made each request,
+// a session cookie or HTTP basic authentication can be used, requests using the cookie that are not GET or HEAD
+// must include the CSRF token of the session. Pages redirect to /login while the API responds with 401
+func Require(store *state.Store, handler func(resp http.ResponseWriter, req *http.Request)) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		session, cookie := findSession(store, req)
+		if session == nil {
+			log.Printf("Access denied: %s %s from %s\n", req.Method, req.URL.RequestURI(), req.RemoteAddr)
+			if isAPI(req) {
+				deny(resp, req, http.StatusUnauthorized, "Login required")
+			} else {
+				http.Redirect(resp, req, "/login?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
+			}
+			return
+		}
+		if cookie && req.Method != http.MethodGet && req.Method != http.MethodHead && !validCSRF(session, req) {
+			log.Printf("Access denied, invalid CSRF token: %s %s by %s from %s\n", req.Method, req.URL.RequestURI(), session.User, req.RemoteAddr)
+			deny(resp, req, http.StatusForbidden, "Invalid CSRF token")
+			return
+		}
+		log.Printf("Access: %s %s by %s from %s\n", req.Method, req.URL.RequestURI(), session.User, req.RemoteAddr)
+		handler(resp, req.WithContext(context.WithValue(req.Context(), sessionKey, session)))
+	}
+}
+
+// CSRFToken : the CSRF token of the session the request was made in, to be included in forms
+func CSRFToken(req *http.Request) string {
+	if session, ok := req.Context().Value(sessionKey).(*state.Session); ok {
+		return session.CSRF
+	}
+	return ""
+}
+
+// UserName : the name of the user that made the request
+func UserName(req *http.Request) string {
+	if session, ok := req.Context().Value(sessionKey).(*state.Session); ok {
+		return session.User
+	}
+	return ""
+}
+
+// findSession returns the session of the request and whether it came from the cookie,
+// basic authentication is given a session that lasts for the request
+func findSession(store *state.Store, req *http.Request) (*state.Session, bool) {
+	if name, password, ok := req.BasicAuth(); ok {
+		if Authenticate(store, name, password) {
+			return &state.Session{User: name}, false
+		}
+		return nil, false
+	}
+	if cookie, err := req.Cookie(CookieName); err == nil {
+		return store.GetSession(hashID(cookie.Value)), true
+	}
+	return nil, false
+}
+
+func isAPI(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/api/")
+}
+
+// deny responds with the message as JSON for the API or as text for pages
+func deny(resp http.ResponseWriter, req *http.Request, status int, message string) {
+	if isAPI(req) {
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(status)
+		json.NewEncoder(resp).Encode(map[string]string{"error": message})
+		return
+	}
+	http.Error(resp, message, status)
+}
+
+func validCSRF(session *state.Session, req *http.Request) bool {
+	token := req.Header.Get(CSRFHeader)
+	if len(token) == 0 {
+		token = req.PostFormValue(CSRFField)
+	}
+	return len(token) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRF)) == 1
+}
+
+func randomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// hashID is used to store sessions so that the ids in the store cannot be used as cookies
+func hashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+func handled(called *string) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		*called = UserName(req) + " " + CSRFToken(req)
+	}
+}
+
+func login(t *testing.T, store *state.Store) (*http.Cookie, *state.Session) {
+	resp := httptest.NewRecorder()
+	session, err := StartSession(store, resp, httptest.NewRequest("POST", "/login", nil), "alice")
+	if err != nil || len(resp.Result().Cookies()) != 1 {
+		t.Fatal(err)
+	}
+	return resp.Result().Cookies()[0], session
+}
+
+func TestAuthenticate(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	if store.HasUsers() || AddUser(store, "alice", "") == nil {
+		t.Fail()
+	}
+	AddUser(store, "alice", "secret")
+	if !store.HasUsers() || !Authenticate(store, "alice", "secret") || Authenticate(store, "alice", "wrong") ||
+		Authenticate(store, "bob", "secret") || string(store.GetUser("alice").Hash) == "secret" {
+		t.Fail()
+	}
+}
+
+func TestRequire(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	AddUser(store, "alice", "secret")
+	called := ""
+	handler := Require(store, handled(&called))
+
+	resp := httptest.NewRecorder()
+	handler(resp, httptest.NewRequest("GET", "/latest?type=A", nil))
+	if called != "" || resp.Code != http.StatusSeeOther || resp.Header().Get("Location") != "/login?next="+url.QueryEscape("/latest?type=A") {
+		t.Errorf("expected a redirect to login %d %s", resp.Code, resp.Header().Get("Location"))
+	}
+	resp = httptest.NewRecorder()
+	handler(resp, httptest.NewRequest("GET", "/api/v1/devices", nil))
+	if called != "" || resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized %d", resp.Code)
+	}
+
+	cookie, session := login(t, store)
+	req := httptest.NewRequest("GET", "/latest", nil)
+	req.AddCookie(cookie)
+	handler(httptest.NewRecorder(), req)
+	if called != "alice "+session.CSRF {
+		t.Errorf("expected the session to be available %s", called)
+	}
+
+	called = ""
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/authorized-hosts/add?host=www.google.com", nil)
+	req.AddCookie(cookie)
+	handler(resp, req)
+	if called != "" || resp.Code != http.StatusForbidden {
+		t.Errorf("expected a missing CSRF token to be forbidden %d", resp.Code)
+	}
+	req = httptest.NewRequest("POST", "/authorized-hosts/add", strings.NewReader("host=www.google.com&csrf="+session.CSRF))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	handler(httptest.NewRecorder(), req)
+	if called == "" {
+		t.Error("expected the CSRF form field to be accepted")
+	}
+	called = ""
+	req = httptest.NewRequest("DELETE", "/api/v1/ignored-devices?mac=AA:BB:CC:DD:EE:FF", nil)
+	req.Header.Set(CSRFHeader, session.CSRF)
+	req.AddCookie(cookie)
+	handler(httptest.NewRecorder(), req)
+	if called == "" {
+		t.Error("expected the CSRF header to be accepted")
+	}
+
+	called = ""
+	req = httptest.NewRequest("DELETE", "/api/v1/ignored-devices?mac=AA:BB:CC:DD:EE:FF", nil)
+	req.SetBasicAuth("alice", "secret")
+	handler(httptest.NewRecorder(), req)
+	if called != "alice " {
+		t.Errorf("expected basic authentication without a CSRF token %s", called)
+	}
+	called = ""
+	req = httptest.NewRequest("GET", "/api/v1/devices", nil)
+	req.SetBasicAuth("alice", "wrong")
+	handler(httptest.NewRecorder(), req)
+	if called != "" {
+		t.Error("expected an incorrect password to be refused")
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	AddUser(store, "alice", "secret")
+	called := ""
+	handler := Require(store, handled(&called))
+	cookie, _ := login(t, store)
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(cookie)
+	EndSession(store, httptest.NewRecorder(), req)
+	req = httptest.NewRequest("GET", "/latest", nil)
+	req.AddCookie(cookie)
+	handler(httptest.NewRecorder(), req)
+
+	removed, _ := login(t, store)
+	store.RemoveUser("alice")
+	req = httptest.NewRequest("GET", "/latest", nil)
+	req.AddCookie(removed)
+	handler(httptest.NewRecorder(), req)
+	if called != "" {
+		t.Errorf("expected the sessions to have ended %s", called)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	signer, _ := NewSigner(store, time.Hour)
+	token, _ := signer.Sign(AllowAction, "domain:google.com")
+	action, err := signer.Verify(token)
+	if err != nil || action.Name != AllowAction || action.Value != "domain:google.com" {
+		t.Errorf("unexpected action %v %v", action, err)
+	}
+	if action, err = signer.Use(token); err != nil || action.Value != "domain:google.com" {
+		t.Errorf("unexpected use %v %v", action, err)
+	}
+	if _, err = signer.Use(token); err != ErrUsedToken {
+		t.Errorf("expected a replay to be rejected %v", err)
+	}
+
+	restarted, _ := NewSigner(store, time.Hour)
+	other, _ := restarted.Sign(IgnoreAction, "AA:BB:CC:DD:EE:FF")
+	if _, err = signer.Verify(other); err != nil {
+		t.Errorf("expected the secret to be kept %v", err)
+	}
+	parts := strings.Split(other, ".")
+	forged, _ := restarted.Sign(IgnoreAction, "AA:BB:CC:DD:EE:00")
+	for _, token := range []string{"", "token", parts[0] + "." + strings.Split(forged, ".")[1], strings.Split(forged, ".")[0] + "." + parts[1]} {
+		if _, err = signer.Verify(token); err != ErrInvalidToken {
+			t.Errorf("expected %s to be invalid %v", token, err)
+		}
+	}
+
+	expired, _ := NewSigner(store, -time.Minute)
+	token, _ = expired.Sign(IgnoreAction, "AA:BB:CC:DD:EE:FF")
+	if _, err = signer.Use(token); err != ErrExpiredToken {
+		t.Errorf("expected the token to have expired %v", err)
+	}
+}
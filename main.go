@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/jasonlvhit/gocron"
+	"github.com/tmullender/network-log-monitor/alerts"
+	"github.com/tmullender/network-log-monitor/api"
+	"github.com/tmullender/network-log-monitor/auth"
 	"github.com/tmullender/network-log-monitor/notify"
+	"github.com/tmullender/network-log-monitor/oui"
 	"github.com/tmullender/network-log-monitor/state"
 	"github.com/tmullender/network-log-monitor/syslog"
 	"github.com/tmullender/network-log-monitor/ui"
@@ -23,100 +31,380 @@ type Config struct {
 	HTTPHost     string
 	HTTPAddress  string
 	LogPath      string
+	Sources      []*syslog.SourceConfig
 	MailInterval uint64
 	MailConfig   *notify.Config
+	Retention    *state.Retention
+	LinkHours    uint64
+	Digests      []*DigestConfig
+	Alerts       []*alerts.Rule
+	OUIPath      string
 }
 
+// The modes of a digest, either every host requested since the last digest
+// or only those that each device requested for the first time
+const (
+	allMode        = "all"
+	newDomainsMode = "new-domains"
+)
+
+// DigestConfig : a digest sent on a schedule containing the requests since the last one was delivered,
+// the name identifies the requests that have been delivered so each digest needs its own. The digest is
+// sent with each of the notifiers that are configured and is resent to all of them if any fail.
+// The devices are grouped by owner when GroupByOwner is set, otherwise by their device group when there are any
+type DigestConfig struct {
+	Name         string
+	MailInterval uint64
+	Mode         string
+	GroupByOwner bool
+	notify.Targets
+}
+
+var addUser = flag.String("add-user", "", "Add a user, or change their password, reading the password from stdin and exit")
+
+// The command that imports the log files that follow it instead of running the monitor
+const importCommand = "import"
+
 func main() {
 	config := readConfig()
 	store, err := state.NewStore(config.DbURL)
 	defer store.Close()
 	exitOnError(err)
-	startProcessing(config.LogPath, store)
-	startUserInterface(config, store)
-	startScheduler(config, store)
+	if len(*addUser) > 0 {
+		exitOnError(createUser(store, *addUser, os.Stdin))
+		return
+	}
+	if flag.Arg(0) == importCommand {
+		exitOnError(importLogs(flag.Args()[1:], config, store, os.Stdout))
+		return
+	}
+	if len(config.OUIPath) > 0 {
+		exitOnError(useVendors(config.OUIPath))
+	}
+	store.StartCompactor(config.Retention)
+	signer, err := auth.NewSigner(store, time.Duration(config.LinkHours)*time.Hour)
+	exitOnError(err)
+	engine, err := alerts.NewEngine(config.Alerts, store, config.HTTPAddress, signer)
+	exitOnError(err)
+	startSources(config.Sources, store, engine)
+	startUserInterface(config, store, signer, engine)
+	startScheduler(config, store, signer)
 }
 
 func readConfig() *Config {
 	cfg := flag.String("cfg", "", "The configuration file to use")
 	flag.Parse()
-	config := &Config{"network-log.db", ":8080", "http://localhost:8080", flag.Arg(0), 0, nil}
+	logPath := flag.Arg(0)
+	if logPath == importCommand {
+		logPath = ""
+	}
+	config := &Config{"network-log.db", ":8080", "http://localhost:8080", logPath, nil, 0, nil, state.DefaultRetention(), 72, nil, nil, ""}
 	if len(*cfg) > 0 {
 		file, err := os.Open(*cfg)
 		exitOnError(err)
 		err = json.NewDecoder(file).Decode(config)
 		exitOnError(err)
 	}
+	if len(config.Sources) == 0 {
+		config.Sources = []*syslog.SourceConfig{{Type: syslog.FileSource, Path: config.LogPath}}
+	}
+	if len(config.Digests) == 0 && config.MailInterval > 0 {
+		config.Digests = []*DigestConfig{{
+			Name:         state.DefaultConsumer,
+			MailInterval: config.MailInterval,
+			Targets:      notify.Targets{MailConfig: config.MailConfig},
+		}}
+	}
+	for _, digest := range config.Digests {
+		if len(digest.Mode) > 0 && digest.Mode != allMode && digest.Mode != newDomainsMode {
+			exitOnError(fmt.Errorf("Unknown mode %s for the %s digest", digest.Mode, digest.Name))
+		}
+	}
 	return config
 }
 
+func createUser(store *state.Store, name string, input io.Reader) error {
+	fmt.Printf("Password for %s: ", name)
+	password, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	err = auth.AddUser(store, name, strings.TrimRight(password, "\r\n"))
+	if err == nil {
+		log.Printf("Added user %s\n", name)
+	}
+	return err
+}
+
+// useVendors uses the vendors from a downloaded copy of the IEEE registry instead of the built in registry
+func useVendors(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	vendors, err := oui.Parse(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the OUI registry %s: %v", path, err)
+	}
+	oui.Use(vendors)
+	log.Printf("Using the vendors of %d OUIs from %s\n", len(vendors), path)
+	return nil
+}
+
+// importLogs adds the devices and requests in the log files to the store, without starting the sources,
+// the user interface or the digests, and prints a summary of what was imported. Alerts are not sent for them
+func importLogs(paths []string, config *Config, store *state.Store, output io.Writer) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("No log files to import")
+	}
+	known := len(store.GetDevices())
+	devices := make(chan *syslog.Device, 3)
+	requests := make(chan *syslog.Request, 10)
+	processed := make(chan *syslog.Checkpoint)
+	recorded := make(chan *importCounts)
+	go record(devices, requests, processed, store, recorded)
+	imported, err := syslog.Import(paths, config.Sources, store, devices, requests, processed)
+	close(processed)
+	counts := <-recorded
+	lines, skipped := 0, 0
+	for _, file := range imported {
+		fmt.Fprintf(output, "%s: %d lines imported, %d already processed\n", file.Path, file.Lines, file.Skipped)
+		lines += file.Lines
+		skipped += file.Skipped
+	}
+	fmt.Fprintf(output, "Imported %d lines from %d files, skipped %d lines that had already been processed\n", lines, len(imported), skipped)
+	fmt.Fprintf(output, "Found %d requests, %d for authorized hosts, and %d new devices\n",
+		counts.requests, counts.authorised, len(store.GetDevices())-known)
+	return err
+}
+
+// importCounts are the requests that were found by an import
+type importCounts struct {
+	requests   int
+	authorised int
+}
+
+// record adds the devices and requests to the store as process does, until the checkpoints are closed
+func record(devices chan *syslog.Device, requests chan *syslog.Request, processed chan *syslog.Checkpoint,
+	store *state.Store, recorded chan *importCounts) {
+	counts := &importCounts{}
+	for {
+		select {
+		case device := <-devices:
+			importDevice(device, store)
+		case request := <-requests:
+			recordRequest(request, devices, store, counts)
+		case checkpoint, open := <-processed:
+			for pending := len(requests); pending > 0; pending-- {
+				recordRequest(<-requests, devices, store, counts)
+			}
+			importPendingDevices(devices, store)
+			if !open {
+				recorded <- counts
+				return
+			}
+			checkpoint.Save()
+		}
+	}
+}
+
+// recordRequest adds an imported request to the store, counting it, against the device that held its IP address at the time
+func recordRequest(request *syslog.Request, devices chan *syslog.Device, store *state.Store, counts *importCounts) {
+	importPendingDevices(devices, store)
+	device := store.FindDeviceByIPAt(request.Source, request.At)
+	counts.requests++
+	if isAuthorised(request, device, store) {
+		counts.authorised++
+	} else {
+		handleRequest(request, device, store)
+	}
+}
+
+// importPendingDevices adds any imported devices that were found before the request
+func importPendingDevices(devices chan *syslog.Device, store *state.Store) {
+	for {
+		select {
+		case device := <-devices:
+			importDevice(device, store)
+		default:
+			return
+		}
+	}
+}
+
+// importDevice adds the device from an imported DHCP lease, it is not quarantined
+// as it was on the network before the monitor was
+func importDevice(device *syslog.Device, store *state.Store) {
+	if len(device.ClientID) > 0 {
+		store.SetClientID(device.Mac, device.ClientID)
+		return
+	}
+	store.AddDevice(device.At, device.Hostname, device.IP, device.Mac)
+}
+
 func startProcessing(path string, store *state.Store) {
-	devices, requests, err := syslog.Tail(path)
+	startSources([]*syslog.SourceConfig{{Type: syslog.FileSource, Path: path}}, store, nil)
+}
+
+func startSources(sources []*syslog.SourceConfig, store *state.Store, engine *alerts.Engine) {
+	devices, requests, processed, err := syslog.Open(sources, store)
 	exitOnError(err)
-	log.Println("Starting file processing")
-	go process(devices, requests, store)
+	log.Printf("Starting processing of %d sources\n", len(sources))
+	go process(devices, requests, processed, store, engine)
 }
 
-func startUserInterface(config *Config, store *state.Store) {
+func startUserInterface(config *Config, store *state.Store, signer *auth.Signer, engine *alerts.Engine) {
 	server := &http.Server{Addr: config.HTTPHost}
-	setupExitListener(server)
-	go startServer(server, store, config.HTTPAddress)
+	setupExitListener(server, engine)
+	go startServer(server, store, signer, config.HTTPAddress)
 }
 
-func startScheduler(config *Config, store *state.Store) {
-	if config.MailInterval > 0 {
-		log.Println("Starting cron")
-		gocron.Every(config.MailInterval).Minutes().Do(sendUpdate, config, store)
+func startScheduler(config *Config, store *state.Store, signer *auth.Signer) {
+	for _, digest := range config.Digests {
+		log.Printf("Scheduling %s digest every %d minutes\n", digest.Name, digest.MailInterval)
+		gocron.Every(digest.MailInterval).Minutes().Do(sendUpdate, digest, config, store, signer)
 	}
 	<-gocron.Start()
 }
 
-func process(devices chan *syslog.Device, requests chan *syslog.Request, store *state.Store) {
+// process adds the devices and requests to the store and checks them against the alert rules,
+// the rules are checked for authorized hosts and ignored devices too so that they can name them.
+// Checkpoints are saved once the devices and requests that were sent before them have been added
+func process(devices chan *syslog.Device, requests chan *syslog.Request, processed chan *syslog.Checkpoint,
+	store *state.Store, engine *alerts.Engine) {
 	for true {
 		select {
 		case device := <-devices:
-			store.AddDevice(device.At, device.Hostname, device.IP, device.Mac)
+			addDevice(device, store, engine)
 		case request := <-requests:
-			if _, authorized := (*store.GetAuthorisedHosts())[request.Host]; !authorized {
-				handleRequest(request, store)
+			processRequest(request, devices, store, engine)
+		case checkpoint := <-processed:
+			for pending := len(requests); pending > 0; pending-- {
+				processRequest(<-requests, devices, store, engine)
 			}
+			addPendingDevices(devices, store, engine)
+			checkpoint.Save()
 		}
 	}
 }
 
-func handleRequest(request *syslog.Request, store *state.Store) {
+// processRequest adds the request to the store, after any devices that were found before it, and checks the rules
+func processRequest(request *syslog.Request, devices chan *syslog.Device, store *state.Store, engine *alerts.Engine) {
+	addPendingDevices(devices, store, engine)
 	device := store.FindDeviceByIP(request.Source)
+	checkRules(request, device, engine)
+	if !isAuthorised(request, device, store) {
+		handleRequest(request, device, store)
+	}
+}
+
+// addPendingDevices adds any devices that were found before the request
+// so that the request is associated with the correct device
+func addPendingDevices(devices chan *syslog.Device, store *state.Store, engine *alerts.Engine) {
+	for {
+		select {
+		case device := <-devices:
+			addDevice(device, store, engine)
+		default:
+			return
+		}
+	}
+}
+
+// addDevice adds the device from a DHCP lease, devices that have not been seen before
+// are quarantined until they are approved and alerted about once they have made some requests.
+// Devices with a client ID repeat a lease that has already been added so only the client ID is recorded
+func addDevice(device *syslog.Device, store *state.Store, engine *alerts.Engine) {
+	if len(device.ClientID) > 0 {
+		store.SetClientID(device.Mac, device.ClientID)
+		return
+	}
+	added, isNew := store.AddDeviceLease(device.At, device.Hostname, device.IP, device.Mac)
+	if isNew {
+		log.Printf("New device joined the network: %v\n", added)
+		engine.NewDevice(added)
+	}
+	engine.Device(added)
+}
+
+// isAuthorised is whether the host is authorized for every device or for the group
+// of the device that made the request, which is nil when it is not known
+func isAuthorised(request *syslog.Request, device *state.Device, store *state.Store) bool {
+	if device != nil {
+		return store.IsAuthorisedFor(device.Mac, request.Host)
+	}
+	return store.IsAuthorised(request.Host)
+}
+
+func checkRules(request *syslog.Request, device *state.Device, engine *alerts.Engine) {
+	if device == nil {
+		device = &state.Device{At: request.At, Hostname: request.Source, Mac: request.Source, IP: request.Source}
+	}
+	engine.Request(device, request.At, request.Host, request.Type)
+}
+
+func handleRequest(request *syslog.Request, device *state.Device, store *state.Store) {
 	log.Printf("handleRequest %v for %v\n", request, device)
 	if device == nil {
 		device = store.AddDevice(&time.Time{}, request.Source, request.Source, request.Source)
 	}
-	if _, ignored := (*store.GetIgnoredDevices())[device.Mac]; ignored {
+	if store.IsIgnored(device.Mac) {
 		return
 	}
-	device.AddRequest(request.At, request.Host)
+	store.RecordRequest(device, &state.Request{At: request.At, Host: request.Host, Type: request.Type,
+		Outcome: request.Outcome, Upstream: request.Upstream, Origin: request.Origin})
 }
 
-func startServer(server *http.Server, store *state.Store, address string) {
+func startServer(server *http.Server, store *state.Store, signer *auth.Signer, address string) {
 	log.Println("Starting UI")
-	http.HandleFunc("/", ui.Root())
-	http.HandleFunc("/authorized-hosts", ui.GetAuthorizedHosts(store))
-	http.HandleFunc("/authorized-hosts/add", ui.AddAuthorizedHosts(store))
-	http.HandleFunc("/authorized-hosts/remove", ui.RemoveAuthorizedHosts(store))
-	http.HandleFunc("/ignored-devices", ui.GetIgnoredDevices(store))
-	http.HandleFunc("/ignored-devices/add", ui.AddIgnoredDevice(store))
-	http.HandleFunc("/ignored-devices/remove", ui.RemoveIgnoredDevice(store))
-	http.HandleFunc("/latest", ui.Latest(store, address))
+	http.HandleFunc("/login", ui.Login(store))
+	http.HandleFunc("/action", ui.Action(store, signer))
+	http.HandleFunc("/logout", auth.Require(store, ui.Logout(store)))
+	http.HandleFunc("/", auth.Require(store, ui.Root()))
+	http.HandleFunc("/authorized-hosts", auth.Require(store, ui.GetAuthorizedHosts(store)))
+	http.HandleFunc("/authorized-hosts/add", auth.Require(store, ui.AddAuthorizedHosts(store)))
+	http.HandleFunc("/authorized-hosts/remove", auth.Require(store, ui.RemoveAuthorizedHosts(store)))
+	http.HandleFunc("/ignored-devices", auth.Require(store, ui.GetIgnoredDevices(store)))
+	http.HandleFunc("/ignored-devices/add", auth.Require(store, ui.AddIgnoredDevice(store)))
+	http.HandleFunc("/ignored-devices/remove", auth.Require(store, ui.RemoveIgnoredDevice(store)))
+	http.HandleFunc("/devices", auth.Require(store, ui.GetDevices(store)))
+	http.HandleFunc("/devices/status", auth.Require(store, ui.SetDeviceStatus(store)))
+	http.HandleFunc("/devices/profile", auth.Require(store, ui.SetDeviceProfile(store)))
+	http.HandleFunc("/devices/merge", auth.Require(store, ui.MergeDevices(store)))
+	http.HandleFunc("/devices/split", auth.Require(store, ui.SplitDevice(store)))
+	http.HandleFunc("/devices/group", auth.Require(store, ui.SetDeviceGroup(store)))
+	http.HandleFunc("/groups", auth.Require(store, ui.GetGroups(store)))
+	http.HandleFunc("/groups/add", auth.Require(store, ui.AddGroup(store)))
+	http.HandleFunc("/groups/remove", auth.Require(store, ui.RemoveGroup(store)))
+	http.HandleFunc("/groups/ignore", auth.Require(store, ui.SetGroupIgnored(store)))
+	http.HandleFunc("/groups/hosts/add", auth.Require(store, ui.AddGroupHost(store)))
+	http.HandleFunc("/groups/hosts/remove", auth.Require(store, ui.RemoveGroupHost(store)))
+	http.HandleFunc("/new-domains", auth.Require(store, ui.NewDomains(store)))
+	http.HandleFunc("/latest", auth.Require(store, ui.Latest(store, address)))
+	http.HandleFunc("/history", auth.Require(store, ui.History(store)))
+	http.HandleFunc(api.Prefix+"/devices", auth.Require(store, api.Devices(store)))
+	http.HandleFunc(api.Prefix+"/devices/", auth.Require(store, api.Device(store)))
+	http.HandleFunc(api.Prefix+"/merge-suggestions", auth.Require(store, api.MergeSuggestions(store)))
+	http.HandleFunc(api.Prefix+"/requests", auth.Require(store, api.Requests(store)))
+	http.HandleFunc(api.Prefix+"/new-domains", auth.Require(store, api.NewDomains(store)))
+	http.HandleFunc(api.Prefix+"/latest", auth.Require(store, api.Latest(store)))
+	http.HandleFunc(api.Prefix+"/authorized-hosts", auth.Require(store, api.AuthorizedHosts(store)))
+	http.HandleFunc(api.Prefix+"/ignored-devices", auth.Require(store, api.IgnoredDevices(store)))
+	http.HandleFunc(api.Prefix+"/groups", auth.Require(store, api.Groups(store)))
+	http.HandleFunc(api.Prefix+"/groups/", auth.Require(store, api.Group(store)))
 	err := server.ListenAndServe()
 	exitOnError(err)
 }
 
-func setupExitListener(server *http.Server) {
+func setupExitListener(server *http.Server, engine *alerts.Engine) {
 	signals := make(chan os.Signal)
 	signal.Notify(signals, syscall.SIGINT)
 	go func() {
 		<-signals
 		server.Shutdown(nil)
 		gocron.Clear()
+		engine.Stop()
 	}()
 }
 
@@ -126,12 +414,61 @@ func exitOnError(err error) {
 	}
 }
 
-func sendUpdate(config *Config, store *state.Store) {
-	log.Println("Sending update")
-	err := notify.SendUpdate(config.MailConfig, &notify.Content{
-		Devices: store.GetLatestRequests(true),
+// sendUpdate sends the requests that have not been delivered to each of the digest's notifiers, which each
+// have a watermark, and moves the digest's watermark to that of the notifier that is furthest behind
+func sendUpdate(digest *DigestConfig, config *Config, store *state.Store, signer *auth.Signer) {
+	log.Printf("Sending %s update\n", digest.Name)
+	named := digest.Named()
+	var delivered uint64
+	first := true
+	for _, name := range notify.TargetNames {
+		notifier, exists := named[name]
+		if !exists {
+			continue
+		}
+		consumer := digest.Name + "/" + name
+		// a notifier starts from the digest's watermark, which was shared by all of them before they had their own
+		if store.GetWatermark(consumer) == 0 {
+			logError(store.AdvanceWatermark(consumer, store.GetWatermark(digest.Name)))
+		}
+		sendTo(notifier, consumer, digest, config, store, signer)
+		if mark := store.GetWatermark(consumer); first || mark < delivered {
+			delivered, first = mark, false
+		}
+	}
+	logError(store.AdvanceWatermark(digest.Name, delivered))
+}
+
+// sendTo sends the requests that have not been delivered to the consumer with the notifier,
+// they are included in the next one if it cannot be sent
+func sendTo(notifier notify.Notifier, consumer string, digest *DigestConfig, config *Config, store *state.Store, signer *auth.Signer) {
+	devices, mark, err := store.GetRequestsSince(consumer)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	content := &notify.Content{
+		Devices: devices,
 		Root:    config.HTTPAddress,
-	})
+		Actions: signer,
+	}
+	if digest.GroupByOwner {
+		content.Group = (*state.Device).Owner
+	} else if len(store.GetGroups()) > 0 {
+		content.Group = func(device *state.Device) string { return device.Group }
+	}
+	if digest.Mode == newDomainsMode {
+		content.Devices = store.FilterNewHosts(devices)
+		content.Message = "These are the domains that each device requested for the first time since the last report"
+	}
+	if err := notifier.Send(content); err != nil {
+		log.Printf("Failed to send the %s update: %v\n", consumer, err)
+		return
+	}
+	logError(store.AdvanceWatermark(consumer, mark))
+}
+
+func logError(err error) {
 	if err != nil {
 		log.Println(err)
 	}
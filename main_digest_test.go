@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/notify"
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+func TestSendUpdate(t *testing.T) {
+	store, _ := state.NewStore("/tmp/send-update")
+	defer store.Close()
+	defer os.Remove("/tmp/send-update")
+	at := time.Now()
+	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	store.AddRequest(device, &at, "www.google.com", "A")
+	store.AdvanceWatermark("daily", 1)
+	store.AddRequest(device, &at, "www.example.com", "A")
+
+	sent := 0
+	working := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if body, _ := io.ReadAll(req.Body); strings.Contains(string(body), "www.example.com") {
+			sent++
+		}
+	}))
+	defer working.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	digest := &DigestConfig{Name: "daily", Targets: notify.Targets{
+		Webhook: &notify.WebhookConfig{URL: working.URL},
+		Slack:   &notify.SlackConfig{WebhookURL: failing.URL},
+	}}
+	config := &Config{HTTPAddress: "http://monitor"}
+	sendUpdate(digest, config, store, nil)
+	// the notifiers start from the digest's watermark and only the one that succeeded moves on
+	if sent != 1 || store.GetWatermark("daily/webhook") != 2 || store.GetWatermark("daily/slack") != 1 || store.GetWatermark("daily") != 1 {
+		t.Errorf("Unexpected watermarks %d %d %d", store.GetWatermark("daily/webhook"), store.GetWatermark("daily/slack"), store.GetWatermark("daily"))
+	}
+
+	// the notifier that succeeded is not sent the requests again
+	digest.Slack.WebhookURL = working.URL
+	sendUpdate(digest, config, store, nil)
+	if sent != 2 || store.GetWatermark("daily/slack") != 2 || store.GetWatermark("daily") != 2 {
+		t.Errorf("Unexpected watermarks %d %d", store.GetWatermark("daily/slack"), store.GetWatermark("daily"))
+	}
+}
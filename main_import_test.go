@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+	"github.com/tmullender/network-log-monitor/syslog"
+)
+
+func TestImportLogs(t *testing.T) {
+	path := "/tmp/import.log"
+	defer os.Remove(path)
+	os.WriteFile(path, []byte(strings.Join([]string{
+		"May 24 12:00:00 router dnsmasq-dhcp[123]: DHCPACK(eth0) 192.168.0.2 00:11:22:33:44:55 host1",
+		"May 24 12:00:03 router dnsmasq[126]: query[A] www.google.com from 192.168.0.2",
+		"May 24 12:00:04 router dnsmasq[126]: query[A] www.auth0.com from 192.168.0.2",
+		"May 24 12:00:05 router dnsmasq[126]: query[A] www.amazon.com from 192.168.0.3",
+		"May 24 12:00:06 router dnsmasq-dhcp[123]: DHCPACK(eth0) 192.168.0.4 00:11:22:33:44:66 host2",
+	}, "\n")+"\n"), 0644)
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	store.AuthoriseHost("www.auth0.com")
+	// the device has a newer lease than the one in the log
+	now := time.Now()
+	store.AddDeviceLease(&now, "renamed", "192.168.0.9", "00:11:22:33:44:55")
+	// and the IP address it had then has been given to another device since
+	store.AddDeviceLease(&now, "laptop", "192.168.0.2", "66:77:88:99:AA:BB")
+	config := &Config{Sources: []*syslog.SourceConfig{{Type: syslog.FileSource, Path: "/var/log/dnsmasq.log"}}}
+
+	var output bytes.Buffer
+	if err := importLogs([]string{path}, config, store, &output); err != nil {
+		t.Fatal(err)
+	}
+	summary := output.String()
+	if !strings.Contains(summary, "Imported 5 lines from 1 files") || !strings.Contains(summary, "Found 3 requests, 1 for authorized hosts, and 2 new devices") {
+		t.Errorf("Unexpected summary: %s", summary)
+	}
+	if device := store.GetDevice("00:11:22:33:44:55"); device == nil || len(*device.Requests) != 1 {
+		t.Errorf("Expected the request for www.google.com to be recorded: %v", device)
+	}
+	if device := store.GetDevice("66:77:88:99:AA:BB"); len(*device.Requests) != 0 {
+		t.Errorf("Expected the request to be recorded against the device that had the IP address then: %v", device)
+	}
+	if status := store.GetDeviceStatus("00:11:22:33:44:66"); status.Status != state.Approved {
+		t.Errorf("Expected a device that only joined in the logs not to be quarantined: %v", status)
+	}
+	if device := store.GetDevice("00:11:22:33:44:55"); device.IP != "192.168.0.9" || device.Hostname != "renamed" ||
+		store.FindDeviceByIP("192.168.0.9") == nil {
+		t.Errorf("Expected the older lease not to replace the device's lease: %v", device)
+	}
+
+	output.Reset()
+	importLogs([]string{path}, config, store, &output)
+	if !strings.Contains(output.String(), "Imported 0 lines from 1 files, skipped 5 lines") {
+		t.Errorf("Expected the lines to be skipped: %s", output.String())
+	}
+}
@@ -21,7 +21,7 @@ func TestProcess(t *testing.T) {
 	store, _ := state.NewStore("/tmp/processing")
 	defer store.Close()
 	defer os.Remove("/tmp/processing")
-	go process(devices, requests, store)
+	go process(devices, requests, nil, store, nil)
 
 	validate(t, store, deviceCount)
 }
@@ -36,7 +36,7 @@ func TestAuthorizedHost(t *testing.T) {
 	defer os.Remove("/tmp/authorized")
 	store.AuthoriseHost("www.auth0.com")
 	store.IgnoreDevice("AA:BB:CC:DD:EE:F3")
-	go process(devices, requests, store)
+	go process(devices, requests, nil, store, nil)
 
 	validate(t, store, deviceCount-1)
 }
@@ -56,10 +56,10 @@ func TestUnknownDevice(t *testing.T) {
 	store, _ := state.NewStore("/tmp/unknown")
 	defer store.Close()
 	defer os.Remove("/tmp/unknown")
-	go process(devices, requests, store)
+	go process(devices, requests, nil, store, nil)
 
 	time.Sleep(time.Second)
-	latest := store.GetLatestRequests(false)
+	latest := store.GetLatestRequests()
 	for device, hosts := range *latest {
 		if device.Mac != "127.0.0.1" || len(*hosts) != 1 {
 			log.Printf("Failing %v with %v\n\n", device, hosts)
@@ -69,9 +69,10 @@ func TestUnknownDevice(t *testing.T) {
 }
 
 func createEvents(prefix string, devices chan *syslog.Device, requests chan *syslog.Request) {
-	at := time.Now()
+	now := time.Now()
 	for i := 0; i < deviceCount; i++ {
-		at = at.Add(time.Second)
+		now = now.Add(time.Second)
+		at := now
 		devices <- &syslog.Device{
 			At:       &at,
 			Hostname: fmt.Sprintf("%s%d", prefix, i),
@@ -79,7 +80,8 @@ func createEvents(prefix string, devices chan *syslog.Device, requests chan *sys
 			Mac:      fmt.Sprintf("AA:BB:CC:DD:EE:F%d", i),
 		}
 		for j := 0; j < deviceCount; j++ {
-			at = at.Add(time.Second)
+			now = now.Add(time.Second)
+			at := now
 			requests <- &syslog.Request{
 				At:      &at,
 				Host:    fmt.Sprintf("www.%s%d.com", prefix, j),
@@ -93,7 +95,7 @@ func createEvents(prefix string, devices chan *syslog.Device, requests chan *sys
 func validate(t *testing.T, store *state.Store, count int) {
 	time.Sleep(time.Second)
 
-	latest := *store.GetLatestRequests(true)
+	latest := *store.GetLatestRequests()
 	if len(latest) != count {
 		log.Printf("Expected %d devices, found %d\n\n", count, len(latest))
 		t.Fail()
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tmullender/network-log-monitor/api"
+	"github.com/tmullender/network-log-monitor/state"
+	"github.com/tmullender/network-log-monitor/syslog"
+	"github.com/tmullender/network-log-monitor/ui"
+)
+
+// TestConcurrentAccess should be run with -race to detect unsafe access to the store
+func TestConcurrentAccess(t *testing.T) {
+	devices := make(chan *syslog.Device)
+	requests := make(chan *syslog.Request)
+	store, _ := state.NewStore("/tmp/concurrent")
+	defer store.Close()
+	defer os.Remove("/tmp/concurrent")
+	store.StartCompactor(state.DefaultRetention())
+	go process(devices, requests, nil, store, nil)
+
+	handlers := map[string]func(http.ResponseWriter, *http.Request){
+		"/latest":                                 ui.Latest(store, ""),
+		"/latest?type=A":                          ui.Latest(store, ""),
+		"/history?mac=AA:BB:CC:DD:EE:F1":          ui.History(store),
+		"/authorized-hosts":                       ui.GetAuthorizedHosts(store),
+		"/authorized-hosts/add":                   ui.AddAuthorizedHosts(store),
+		"/authorized-hosts/remove":                ui.RemoveAuthorizedHosts(store),
+		"/ignored-devices":                        ui.GetIgnoredDevices(store),
+		"/ignored-devices/add":                    ui.AddIgnoredDevice(store),
+		"/ignored-devices/remove":                 ui.RemoveIgnoredDevice(store),
+		"/devices":                                ui.GetDevices(store),
+		"/new-domains":                            ui.NewDomains(store),
+		"/api/v1/devices":                         api.Devices(store),
+		"/api/v1/new-domains":                     api.NewDomains(store),
+		"/api/v1/devices/AA:BB:CC:DD:EE:F1/hosts": api.Device(store),
+		"/api/v1/requests":                        api.Requests(store),
+		"/api/v1/latest?consumer=race":            api.Latest(store),
+	}
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		createEvents("race", devices, requests)
+	}()
+	for path, handler := range handlers {
+		wait.Add(1)
+		go func(path string, handler func(http.ResponseWriter, *http.Request)) {
+			defer wait.Done()
+			for i := 0; i < deviceCount; i++ {
+				url := fmt.Sprintf("%s?host=www.race%d.com&mac=AA:BB:CC:DD:EE:F%d", path, i, i)
+				if strings.Contains(path, "?") {
+					url = strings.Replace(url, "?host", "&host", 1)
+				}
+				method := "GET"
+				if strings.HasSuffix(path, "/add") || strings.HasSuffix(path, "/remove") {
+					method = "POST"
+				}
+				handler(httptest.NewRecorder(), httptest.NewRequest(method, url, nil))
+			}
+		}(path, handler)
+	}
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for i := 0; i < deviceCount; i++ {
+			store.GetLatestRequests()
+			if _, mark, err := store.GetRequestsSince("race"); err == nil && i%2 == 0 {
+				store.AdvanceWatermark("race", mark)
+			}
+			store.Compact()
+		}
+	}()
+	wait.Wait()
+}
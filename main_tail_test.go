@@ -27,7 +27,7 @@ func TestStartProcessing(t *testing.T) {
 	f.Write([]byte("May 24 12:00:08 something dnsmasq[131]: query[A] api.buffer.com from 192.168.0.2\n"))
 	f.Close()
 	time.Sleep(time.Second)
-	latest := store.GetLatestRequests(false)
+	latest := store.GetLatestRequests()
 	if len(*latest) != 3 {
 		fmt.Printf("Failed: device count=%d\n", len(*latest))
 		t.Fail()
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+const title = "Network Log Monitor"
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// hostSummary : the requests a device made for a host
+type hostSummary struct {
+	Host  string
+	Count int
+	Types map[string]int
+}
+
+// deviceSummary : the hosts requested by a device ordered by the number of requests
+type deviceSummary struct {
+	Device *state.Device
+	Group  string
+	Hosts  []*hostSummary
+}
+
+// summarise orders the devices by group and name, devices that have not made any requests are left out
+func summarise(content *Content) []*deviceSummary {
+	summaries := make([]*deviceSummary, 0)
+	if content.Devices == nil {
+		return summaries
+	}
+	for device, hosts := range *content.Devices {
+		if len(*hosts) == 0 {
+			continue
+		}
+		summary := &deviceSummary{device, content.groupOf(device), make([]*hostSummary, 0, len(*hosts))}
+		for name, host := range *hosts {
+			summary.Hosts = append(summary.Hosts, &hostSummary{name, len(host.Requests), host.TypeCounts()})
+		}
+		sort.Slice(summary.Hosts, func(i, j int) bool {
+			if summary.Hosts[i].Count == summary.Hosts[j].Count {
+				return summary.Hosts[i].Host < summary.Hosts[j].Host
+			}
+			return summary.Hosts[i].Count > summary.Hosts[j].Count
+		})
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Group != summaries[j].Group {
+			return summaries[i].Group < summaries[j].Group
+		}
+		if summaries[i].Device.Name() == summaries[j].Device.Name() {
+			return summaries[i].Device.Mac < summaries[j].Device.Mac
+		}
+		return summaries[i].Device.Name() < summaries[j].Device.Name()
+	})
+	return summaries
+}
+
+// typeCounts formats the counts as "A 2, AAAA 1"
+func typeCounts(counts map[string]int) string {
+	types := make([]string, 0, len(counts))
+	for qtype := range counts {
+		types = append(types, qtype)
+	}
+	sort.Strings(types)
+	for i, qtype := range types {
+		types[i] = fmt.Sprintf("%s %d", qtype, counts[qtype])
+	}
+	return strings.Join(types, ", ")
+}
+
+// message is the line that introduces the summaries
+func message(content *Content, summaries []*deviceSummary) string {
+	if len(content.Message) > 0 {
+		return content.Message + "\n"
+	}
+	if len(summaries) == 0 {
+		return "No new requests since the last report\n"
+	}
+	return ""
+}
+
+// startsGroup is whether the summary is the first of a group
+func startsGroup(summaries []*deviceSummary, i int) bool {
+	return len(summaries[i].Group) > 0 && (i == 0 || summaries[i-1].Group != summaries[i].Group)
+}
+
+// markdown formats the digest without action links, as they make the messages too long for most services
+func markdown(content *Content) string {
+	var text strings.Builder
+	summaries := summarise(content)
+	text.WriteString(message(content, summaries))
+	for i, summary := range summaries {
+		if startsGroup(summaries, i) {
+			fmt.Fprintf(&text, "\n__%s__\n", summary.Group)
+		}
+		fmt.Fprintf(&text, "**%s** %s\n", summary.Device.Name(), summary.Device.Mac)
+		for _, host := range summary.Hosts {
+			fmt.Fprintf(&text, "- %s (%d: %s)\n", host.Host, host.Count, typeCounts(host.Types))
+		}
+	}
+	if len(content.Root) > 0 {
+		fmt.Fprintf(&text, "\n%s/latest\n", content.Root)
+	}
+	return text.String()
+}
+
+// send makes the request and returns an error unless it succeeds
+func send(method string, url string, contentType string, body io.Reader, headers map[string]string) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Notification to %s failed with %s: %s", req.URL.Host, resp.Status, message)
+	}
+	return nil
+}
+
+// sendJSON posts the body as JSON
+func sendJSON(method string, url string, body interface{}, headers map[string]string) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return send(method, url, "application/json", bytes.NewReader(data), headers)
+}
@@ -0,0 +1,27 @@
+package notify
+
+import (
+	"net/http"
+	"strings"
+)
+
+// GotifyConfig : sends the digest to a Gotify server using an application token
+type GotifyConfig struct {
+	Server   string
+	Token    string
+	Priority int
+}
+
+// Send : Send the digest to Gotify as markdown
+func (config *GotifyConfig) Send(content *Content) error {
+	message := map[string]interface{}{
+		"title":    content.Heading(),
+		"message":  markdown(content),
+		"priority": config.Priority,
+		"extras": map[string]interface{}{
+			"client::display": map[string]string{"contentType": "text/markdown"},
+		},
+	}
+	url := strings.TrimSuffix(config.Server, "/") + "/message"
+	return sendJSON(http.MethodPost, url, message, map[string]string{"X-Gotify-Key": config.Token})
+}
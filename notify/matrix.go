@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var transactions uint64
+
+// MatrixConfig : sends the digest to a Matrix room as the user the access token belongs to
+type MatrixConfig struct {
+	Homeserver  string
+	AccessToken string
+	RoomID      string
+}
+
+// Send : Send the digest to the room as a notice, clients that display HTML
+// show the same content as the email including the action links
+func (config *MatrixConfig) Send(content *Content) error {
+	formatted, err := renderHTML(content)
+	if err != nil {
+		return err
+	}
+	message := map[string]string{
+		"msgtype":        "m.notice",
+		"body":           markdown(content),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	}
+	transaction := fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&transactions, 1))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(config.Homeserver, "/"), url.PathEscape(config.RoomID), transaction)
+	return sendJSON(http.MethodPut, endpoint, message, map[string]string{"Authorization": "Bearer " + config.AccessToken})
+}
@@ -3,13 +3,23 @@ package notify
 import (
 	"bytes"
 	"html/template"
+	"log"
+	"net/url"
 
+	"github.com/tmullender/network-log-monitor/auth"
+	"github.com/tmullender/network-log-monitor/state"
 	gomail "gopkg.in/gomail.v2"
 )
 
 var emailContentFile, _ = Asset("templates/email-content.template")
 var emailContentTemplate = template.Must(template.New("email-content").Parse(string(emailContentFile)))
 
+// Notifier : sends the digest of the requests in the content
+type Notifier interface {
+	Send(content *Content) error
+}
+
+// Config : the SMTP settings used to email the digest
 type Config struct {
 	From         string
 	To           string
@@ -20,16 +30,173 @@ type Config struct {
 	SMTPPassword string
 }
 
-// Content : the data to include in the email
+// Targets : the notifiers to send to, those that are not configured are left out
+type Targets struct {
+	MailConfig *Config
+	Webhook    *WebhookConfig
+	Slack      *SlackConfig
+	Discord    *DiscordConfig
+	Ntfy       *NtfyConfig
+	Gotify     *GotifyConfig
+	Matrix     *MatrixConfig
+}
+
+// TargetNames : the names of the notifiers that can be configured, in the order they are sent to
+var TargetNames = []string{"mail", "webhook", "slack", "discord", "ntfy", "gotify", "matrix"}
+
+// Named : the notifiers that are configured by their name in TargetNames
+func (targets *Targets) Named() map[string]Notifier {
+	named := make(map[string]Notifier, 0)
+	if targets.MailConfig != nil {
+		named["mail"] = targets.MailConfig
+	}
+	if targets.Webhook != nil {
+		named["webhook"] = targets.Webhook
+	}
+	if targets.Slack != nil {
+		named["slack"] = targets.Slack
+	}
+	if targets.Discord != nil {
+		named["discord"] = targets.Discord
+	}
+	if targets.Ntfy != nil {
+		named["ntfy"] = targets.Ntfy
+	}
+	if targets.Gotify != nil {
+		named["gotify"] = targets.Gotify
+	}
+	if targets.Matrix != nil {
+		named["matrix"] = targets.Matrix
+	}
+	return named
+}
+
+// Notifiers : the notifiers that are configured
+func (targets *Targets) Notifiers() []Notifier {
+	named := targets.Named()
+	notifiers := make([]Notifier, 0, len(named))
+	for _, name := range TargetNames {
+		if notifier, exists := named[name]; exists {
+			notifiers = append(notifiers, notifier)
+		}
+	}
+	return notifiers
+}
+
+// Send : Send the content with each of the notifiers, all of them are tried
+// and the first error is returned if any fail
+func (targets *Targets) Send(content *Content) error {
+	var first error
+	for _, notifier := range targets.Notifiers() {
+		if err := notifier.Send(content); err != nil {
+			log.Println(err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Others : the group of the devices that Group does not give one
+const Others = "Others"
+
+// Content : the data to include in the email, links are signed by Actions
+// so that they can be used without logging in. The title and message replace
+// those of the digest when they are set, and the devices are shown in the
+// groups that Group gives them when it is set
 type Content struct {
-	Devices interface{}
+	Devices *map[*state.Device]*map[string]*state.Host
 	Root    string
+	Actions *auth.Signer
+	Title   string
+	Message string
+	Group   func(device *state.Device) string
+}
+
+// Groups : the devices by group, all of them are in a single group with no name when Group is not set
+func (content Content) Groups() map[string]*map[*state.Device]*map[string]*state.Host {
+	groups := make(map[string]*map[*state.Device]*map[string]*state.Host, 0)
+	if content.Devices == nil {
+		return groups
+	}
+	for device, hosts := range *content.Devices {
+		name := content.groupOf(device)
+		group, exists := groups[name]
+		if !exists {
+			devices := make(map[*state.Device]*map[string]*state.Host, 0)
+			group = &devices
+			groups[name] = group
+		}
+		(*group)[device] = hosts
+	}
+	return groups
+}
+
+// groupOf is the group of the device, empty when the devices are not grouped
+func (content Content) groupOf(device *state.Device) string {
+	if content.Group == nil {
+		return ""
+	}
+	if name := content.Group(device); len(name) > 0 {
+		return name
+	}
+	return Others
+}
+
+// Heading : the title of the notification
+func (content Content) Heading() string {
+	if len(content.Title) > 0 {
+		return content.Title
+	}
+	return title
+}
+
+// IgnoreURL : the link used to ignore the device
+func (content Content) IgnoreURL(mac string) string {
+	return content.actionURL(auth.IgnoreAction, mac, "/ignored-devices/add?mac="+url.QueryEscape(mac))
 }
 
-//SendUpdate : Send an email
+// AllowURL : the link used to authorize the host, and only the host
+func (content Content) AllowURL(host string) string {
+	key, err := state.NewRule(state.ExactRule, host)
+	if err != nil {
+		key = host
+	}
+	return content.actionURL(auth.AllowAction, key, "/authorized-hosts/add?type=exact&host="+url.QueryEscape(host))
+}
+
+// AllowDomainURL : the link used to authorize the registrable domain of the host
+func (content Content) AllowDomainURL(host string) string {
+	key, err := state.NewRule(state.DomainRule, host)
+	if err != nil {
+		key = host
+	}
+	return content.actionURL(auth.AllowAction, key, "/authorized-hosts/add?type=domain&host="+url.QueryEscape(host))
+}
+
+// actionURL signs a link for the action, the page that asks for the change
+// to be confirmed after logging in is used when links cannot be signed
+func (content Content) actionURL(action string, value string, fallback string) string {
+	if content.Actions == nil {
+		return content.Root + fallback
+	}
+	token, err := content.Actions.Sign(action, value)
+	if err != nil {
+		log.Printf("Error signing link: %v\n", err)
+		return content.Root + fallback
+	}
+	return content.Root + "/action?token=" + url.QueryEscape(token)
+}
+
+// Send : Send the digest as an email
+func (config *Config) Send(content *Content) error {
+	return SendUpdate(config, content)
+}
+
+// SendUpdate : Send an email
 func SendUpdate(config *Config, input *Content) error {
-	var buffer bytes.Buffer
-	err := emailContentTemplate.Execute(&buffer, *input)
+	body, err := renderHTML(input)
 	if err != nil {
 		return err
 	}
@@ -37,10 +204,20 @@ func SendUpdate(config *Config, input *Content) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", config.From)
 	m.SetHeader("To", config.To)
-	m.SetHeader("Subject", config.Subject)
-	m.SetBody("text/html", buffer.String())
+	subject := config.Subject
+	if len(input.Title) > 0 {
+		subject = input.Title
+	}
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", body)
 
 	d := gomail.NewDialer(config.SMTPServer, config.SMTPPort, config.SMTPUser, config.SMTPPassword)
 	err = d.DialAndSend(m)
 	return err
 }
+
+func renderHTML(content *Content) (string, error) {
+	var buffer bytes.Buffer
+	err := emailContentTemplate.Execute(&buffer, *content)
+	return buffer.String(), err
+}
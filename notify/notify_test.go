@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tmullender/network-log-monitor/auth"
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+type received struct {
+	Method  string
+	Path    string
+	Query   string
+	Headers http.Header
+	Body    string
+}
+
+func createServer(status int) (*httptest.Server, *[]*received) {
+	requests := make([]*received, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests = append(requests, &received{req.Method, req.URL.Path, req.URL.RawQuery, req.Header, string(body)})
+		resp.WriteHeader(status)
+	}))
+	return server, &requests
+}
+
+func createContent() *Content {
+	store, _ := state.NewStore("memory://")
+	signer, _ := auth.NewSigner(store, time.Hour)
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	device := store.AddDevice(&at, "laptop", "192.168.0.2", "AA:BB:CC:DD:EE:01")
+	store.AddDevice(&at, "quiet", "192.168.0.3", "AA:BB:CC:DD:EE:02")
+	store.AddRequest(device, &at, "www.google.com", "A")
+	store.AddRequest(device, &at, "www.google.com", "AAAA")
+	store.AddRequest(device, &at, "<b>.example.com", "A")
+	devices, _, _ := store.GetRequestsSince(state.DefaultConsumer)
+	return &Content{Devices: devices, Root: "http://monitor", Actions: signer}
+}
+
+func TestWebhook(t *testing.T) {
+	server, requests := createServer(http.StatusOK)
+	defer server.Close()
+	config := &WebhookConfig{server.URL + "/hook", map[string]string{"Authorization": "Bearer secret"}}
+	if err := config.Send(createContent()); err != nil || len(*requests) != 1 {
+		t.Fatal(err)
+	}
+	request := (*requests)[0]
+	var payload WebhookPayload
+	json.Unmarshal([]byte(request.Body), &payload)
+	if request.Method != "POST" || request.Path != "/hook" || request.Headers.Get("Authorization") != "Bearer secret" ||
+		len(payload.Devices) != 1 || payload.Devices[0].Hosts[0].Host != "www.google.com" || payload.Devices[0].Hosts[0].Count != 2 ||
+		!strings.HasPrefix(payload.Devices[0].Hosts[0].AllowURL, "http://monitor/action?token=") {
+		t.Errorf("unexpected request %v", request)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	server, requests := createServer(http.StatusOK)
+	defer server.Close()
+	if err := (&SlackConfig{server.URL}).Send(createContent()); err != nil || len(*requests) != 1 {
+		t.Fatal(err)
+	}
+	var message map[string]string
+	json.Unmarshal([]byte((*requests)[0].Body), &message)
+	if !strings.Contains(message["text"], "*laptop* AA:BB:CC:DD:EE:01 <http://monitor/action?token=") ||
+		!strings.Contains(message["text"], "• www.google.com (2: A 1, AAAA 1) <") ||
+		!strings.Contains(message["text"], "&lt;b&gt;.example.com") || strings.Contains(message["text"], "quiet") {
+		t.Errorf("unexpected message %s", message["text"])
+	}
+}
+
+func TestDiscord(t *testing.T) {
+	server, requests := createServer(http.StatusNoContent)
+	defer server.Close()
+	content := createContent()
+	for device, hosts := range *content.Devices {
+		for i := 0; i < 100; i++ {
+			at := time.Now()
+			device.AddRequest(&at, fmt.Sprintf("%s%d.com", strings.Repeat("a", 40), i), "A")
+		}
+		content.Devices = &map[*state.Device]*map[string]*state.Host{device: hosts}
+		break
+	}
+	if err := (&DiscordConfig{server.URL}).Send(content); err != nil || len(*requests) < 2 {
+		t.Fatalf("expected several messages %v %d", err, len(*requests))
+	}
+	for _, request := range *requests {
+		var message map[string]string
+		json.Unmarshal([]byte(request.Body), &message)
+		if len(message["content"]) > discordLimit {
+			t.Errorf("message is too long %d", len(message["content"]))
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	messages := split("short\n"+strings.Repeat("é", 5)+"\n", 4)
+	if strings.Join(messages, "") != "short\n"+strings.Repeat("é", 5)+"\n" {
+		t.Fatalf("expected the text to be kept %q", messages)
+	}
+	for _, message := range messages {
+		if len(message) > 4 || !utf8.ValidString(message) {
+			t.Errorf("unexpected message %q", message)
+		}
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	server, requests := createServer(http.StatusOK)
+	defer server.Close()
+	if err := (&NtfyConfig{server.URL + "/", "network", "token", 4}).Send(createContent()); err != nil || len(*requests) != 1 {
+		t.Fatal(err)
+	}
+	request := (*requests)[0]
+	if request.Path != "/network" || request.Headers.Get("Authorization") != "Bearer token" || request.Headers.Get("Priority") != "4" ||
+		request.Headers.Get("Markdown") != "yes" || !strings.Contains(request.Body, "**laptop** AA:BB:CC:DD:EE:01\n- www.google.com (2: A 1, AAAA 1)") {
+		t.Errorf("unexpected request %v", request)
+	}
+}
+
+func TestGotify(t *testing.T) {
+	server, requests := createServer(http.StatusOK)
+	defer server.Close()
+	if err := (&GotifyConfig{server.URL, "app-token", 5}).Send(createContent()); err != nil || len(*requests) != 1 {
+		t.Fatal(err)
+	}
+	request := (*requests)[0]
+	var message map[string]interface{}
+	json.Unmarshal([]byte(request.Body), &message)
+	if request.Path != "/message" || request.Headers.Get("X-Gotify-Key") != "app-token" || message["priority"].(float64) != 5 ||
+		!strings.Contains(message["message"].(string), "www.google.com") {
+		t.Errorf("unexpected request %v", request)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	server, requests := createServer(http.StatusOK)
+	defer server.Close()
+	config := &MatrixConfig{server.URL, "access-token", "!room:example.org"}
+	config.Send(createContent())
+	if err := config.Send(createContent()); err != nil || len(*requests) != 2 {
+		t.Fatal(err)
+	}
+	first, second := (*requests)[0], (*requests)[1]
+	var message map[string]string
+	json.Unmarshal([]byte(first.Body), &message)
+	if first.Method != "PUT" || !strings.HasPrefix(first.Path, "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/") ||
+		first.Path == second.Path || first.Headers.Get("Authorization") != "Bearer access-token" ||
+		message["msgtype"] != "m.notice" || !strings.Contains(message["formatted_body"], "/action?token=") {
+		t.Errorf("unexpected request %v", first)
+	}
+}
+
+func TestGroupByOwner(t *testing.T) {
+	server, requests := createServer(http.StatusOK)
+	defer server.Close()
+	content := createContent()
+	hosts := make(map[string]*state.Host, 0)
+	at := time.Now()
+	owned := &state.Device{Hostname: "android-1234", Mac: "AA:BB:CC:DD:EE:03", Requests: &hosts,
+		Profile: &state.Profile{Name: "Tablet", Owner: "Sam"}}
+	owned.AddRequest(&at, "www.example.com", "A")
+	(*content.Devices)[owned] = owned.Requests
+	content.Group = (*state.Device).Owner
+	if err := (&NtfyConfig{Server: server.URL, Topic: "network"}).Send(content); err != nil || len(*requests) != 1 {
+		t.Fatal(err)
+	}
+	body := (*requests)[0].Body
+	others, sam := strings.Index(body, "__Others__\n**laptop**"), strings.Index(body, "__Sam__\n**Tablet** AA:BB:CC:DD:EE:03")
+	if others < 0 || sam < others || strings.Contains(body, "android") {
+		t.Errorf("unexpected body %s", body)
+	}
+	groups := content.Groups()
+	if len(groups) != 2 || len(*groups["Sam"]) != 1 {
+		t.Errorf("unexpected groups %v", groups)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	server, _ := createServer(http.StatusInternalServerError)
+	defer server.Close()
+	notifiers := []Notifier{&WebhookConfig{URL: server.URL}, &SlackConfig{server.URL}, &DiscordConfig{server.URL},
+		&NtfyConfig{Server: server.URL, Topic: "network"}, &GotifyConfig{Server: server.URL}, &MatrixConfig{server.URL, "", "!room"}}
+	for _, notifier := range notifiers {
+		if err := notifier.Send(createContent()); err == nil {
+			t.Errorf("%T: expected an error", notifier)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// NtfyConfig : publishes the digest to a ntfy topic, the token is only needed
+// for servers that require access tokens
+type NtfyConfig struct {
+	Server   string
+	Topic    string
+	Token    string
+	Priority int
+}
+
+// Send : Publish the digest to the topic as markdown
+func (config *NtfyConfig) Send(content *Content) error {
+	headers := map[string]string{"Title": content.Heading(), "Markdown": "yes"}
+	if len(config.Token) > 0 {
+		headers["Authorization"] = "Bearer " + config.Token
+	}
+	if config.Priority > 0 {
+		headers["Priority"] = strconv.Itoa(config.Priority)
+	}
+	if len(content.Root) > 0 {
+		headers["Click"] = content.Root + "/latest"
+	}
+	url := strings.TrimSuffix(config.Server, "/") + "/" + config.Topic
+	return send(http.MethodPost, url, "text/markdown", strings.NewReader(markdown(content)), headers)
+}
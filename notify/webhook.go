@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const discordLimit = 2000
+
+// WebhookConfig : posts the digest as JSON to the URL along with the headers
+type WebhookConfig struct {
+	URL     string
+	Headers map[string]string
+}
+
+// WebhookPayload : the JSON posted by the generic webhook
+type WebhookPayload struct {
+	Title   string           `json:"title"`
+	Message string           `json:"message,omitempty"`
+	Root    string           `json:"root"`
+	Devices []*WebhookDevice `json:"devices"`
+}
+
+// WebhookDevice : a device in the webhook payload
+type WebhookDevice struct {
+	Mac        string         `json:"mac"`
+	Hostname   string         `json:"hostname"`
+	Name       string         `json:"name"`
+	Owner      string         `json:"owner,omitempty"`
+	Group      string         `json:"group,omitempty"`
+	IP         string         `json:"ip"`
+	Vendor     string         `json:"vendor"`
+	Randomized bool           `json:"randomized"`
+	IgnoreURL  string         `json:"ignoreUrl"`
+	Hosts      []*WebhookHost `json:"hosts"`
+}
+
+// WebhookHost : a host requested by a device in the webhook payload
+type WebhookHost struct {
+	Host           string         `json:"host"`
+	Count          int            `json:"count"`
+	Types          map[string]int `json:"types"`
+	AllowURL       string         `json:"allowUrl"`
+	AllowDomainURL string         `json:"allowDomainUrl"`
+}
+
+// Send : Post the digest to the webhook
+func (config *WebhookConfig) Send(content *Content) error {
+	payload := &WebhookPayload{content.Heading(), content.Message, content.Root, make([]*WebhookDevice, 0)}
+	for _, summary := range summarise(content) {
+		device := &WebhookDevice{summary.Device.Mac, summary.Device.Hostname, summary.Device.Name(),
+			summary.Device.Owner(), summary.Group, summary.Device.IP,
+			summary.Device.Vendor(), summary.Device.Randomized(), content.IgnoreURL(summary.Device.Mac), make([]*WebhookHost, 0, len(summary.Hosts))}
+		for _, host := range summary.Hosts {
+			device.Hosts = append(device.Hosts, &WebhookHost{host.Host, host.Count, host.Types,
+				content.AllowURL(host.Host), content.AllowDomainURL(host.Host)})
+		}
+		payload.Devices = append(payload.Devices, device)
+	}
+	return sendJSON(http.MethodPost, config.URL, payload, config.Headers)
+}
+
+// SlackConfig : posts the digest to a Slack incoming webhook
+type SlackConfig struct {
+	WebhookURL string
+}
+
+// Send : Post the digest to Slack, formatted with Slack's markup and including the action links
+func (config *SlackConfig) Send(content *Content) error {
+	var text strings.Builder
+	text.WriteString("*" + slackEscape(content.Heading()) + "*\n")
+	summaries := summarise(content)
+	text.WriteString(slackEscape(message(content, summaries)))
+	for i, summary := range summaries {
+		if startsGroup(summaries, i) {
+			fmt.Fprintf(&text, "\n_%s_\n", slackEscape(summary.Group))
+		}
+		fmt.Fprintf(&text, "\n*%s* %s <%s|Ignore>\n", slackEscape(summary.Device.Name()), summary.Device.Mac, content.IgnoreURL(summary.Device.Mac))
+		for _, host := range summary.Hosts {
+			fmt.Fprintf(&text, "• %s (%d: %s) <%s|Allow> <%s|Allow domain>\n", slackEscape(host.Host), host.Count,
+				typeCounts(host.Types), content.AllowURL(host.Host), content.AllowDomainURL(host.Host))
+		}
+	}
+	return sendJSON(http.MethodPost, config.WebhookURL, map[string]string{"text": text.String()}, nil)
+}
+
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// DiscordConfig : posts the digest to a Discord webhook
+type DiscordConfig struct {
+	WebhookURL string
+}
+
+// Send : Post the digest to Discord, it is split into several messages when it is too long for one
+func (config *DiscordConfig) Send(content *Content) error {
+	for _, message := range split("**"+content.Heading()+"**\n"+markdown(content), discordLimit) {
+		if err := sendJSON(http.MethodPost, config.WebhookURL, map[string]string{"content": message}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// split breaks the text into messages of at most limit bytes, between lines where possible
+// and otherwise at the start of a character
+func split(text string, limit int) []string {
+	messages := make([]string, 0)
+	var message strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for len(line) > limit {
+			if message.Len() > 0 {
+				messages = append(messages, message.String())
+				message.Reset()
+			}
+			end := limit
+			for end > 0 && !utf8.RuneStart(line[end]) {
+				end--
+			}
+			if end == 0 {
+				end = limit
+			}
+			messages = append(messages, line[:end])
+			line = line[end:]
+		}
+		if message.Len()+len(line) > limit {
+			messages = append(messages, message.String())
+			message.Reset()
+		}
+		message.WriteString(line)
+	}
+	if message.Len() > 0 {
+		messages = append(messages, message.String())
+	}
+	return messages
+}
@@ -0,0 +1,102 @@
+package oui
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Unknown : the vendor of MAC addresses that are not in the registry
+const Unknown = "Unknown"
+
+var lock sync.RWMutex
+
+// registry : the vendors by the first three octets of their MAC address, loaded from the
+// copy of the IEEE registry built into the binary until Use replaces it
+var registry = load("oui/oui.csv")
+
+// load reads the registry built into the binary, exiting if it is missing or invalid
+func load(name string) map[string]string {
+	data, err := Asset(name)
+	if err != nil {
+		log.Fatalf("The OUI registry is not built in, run go-bindata: %v\n", err)
+	}
+	vendors, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		log.Fatalf("Error loading the OUI registry: %v\n", err)
+	}
+	return vendors
+}
+
+// Parse : reads the vendors from the IEEE registry CSV, https://standards-oui.ieee.org/oui/oui.csv,
+// an error is returned if a row does not have a valid assignment
+func Parse(input io.Reader) (map[string]string, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	vendors := make(map[string]string, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return vendors, nil
+		}
+		if err != nil {
+			return vendors, err
+		}
+		if line == 1 && len(record) > 1 && record[1] == "Assignment" {
+			continue
+		}
+		if len(record) < 3 || len(digits(record[1])) != 6 || !isHex(digits(record[1])) {
+			return vendors, fmt.Errorf("Invalid OUI on line %d: %v", line, record)
+		}
+		vendors[digits(record[1])] = strings.TrimSpace(record[2])
+	}
+}
+
+// Use : replaces the registry with the vendors, for example those of a newer registry
+func Use(vendors map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+	registry = vendors
+}
+
+// Lookup : the vendor of the MAC address, Unknown if it is not in the registry
+func Lookup(mac string) string {
+	lock.RLock()
+	defer lock.RUnlock()
+	if vendor, exists := registry[prefix(mac)]; exists {
+		return vendor
+	}
+	return Unknown
+}
+
+// Randomized : whether the MAC address is locally administered, as the randomized addresses
+// that phones use to avoid being tracked are, rather than one assigned to a vendor
+func Randomized(mac string) bool {
+	hex := digits(mac)
+	if len(hex) != 12 || !isHex(hex) {
+		return false
+	}
+	return strings.ContainsAny(hex[1:2], "2367ABEF")
+}
+
+// prefix : the first three octets of the MAC address as upper case hex without separators
+func prefix(mac string) string {
+	hex := digits(mac)
+	if len(hex) < 6 {
+		return hex
+	}
+	return hex[:6]
+}
+
+// digits : the MAC address as upper case hex without separators
+func digits(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+}
+
+func isHex(digits string) bool {
+	return strings.Trim(digits, "0123456789ABCDEF") == ""
+}
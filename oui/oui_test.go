@@ -0,0 +1,57 @@
+package oui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	builtIn := registry
+	defer Use(builtIn)
+	Use(map[string]string{"B827EB": "Raspberry Pi Foundation", "005056": "VMware, Inc."})
+	for mac, expected := range map[string]string{
+		"b8:27:eb:12:34:56": "Raspberry Pi Foundation",
+		"B8-27-EB-12-34-56": "Raspberry Pi Foundation",
+		"0050.5612.3456":    "VMware, Inc.",
+		"AA:BB:CC:DD:EE:FF": Unknown,
+		"192.168.0.2":       Unknown,
+		"":                  Unknown,
+	} {
+		if vendor := Lookup(mac); vendor != expected {
+			t.Errorf("%s: expected %s, found %s", mac, expected, vendor)
+		}
+	}
+}
+
+func TestRandomized(t *testing.T) {
+	for mac, expected := range map[string]bool{
+		"b8:27:eb:12:34:56": false,
+		"DA:A1:19:12:34:56": true,
+		"02:00:00:00:00:01": true,
+		"3e-22-fb-12-34-56": true,
+		"192.168.0.2":       false,
+		"":                  false,
+	} {
+		if Randomized(mac) != expected {
+			t.Errorf("%s: expected %v", mac, expected)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	vendors, err := Parse(strings.NewReader("Registry,Assignment,Organization Name,Organization Address\n" +
+		"MA-L,B827EB,Raspberry Pi Foundation,Mitchell Wood House Caldecote GB CB23 7NU \n" +
+		"MA-L,001A11,\"Google, Inc.\",\"1600 Amphitheater Parkway Mountain View CA US 94043 \"\n"))
+	if err != nil || len(vendors) != 2 || vendors["001A11"] != "Google, Inc." {
+		t.Fatalf("unexpected vendors %v %v", vendors, err)
+	}
+	if _, err := Parse(strings.NewReader("MA-L,B827,Short,\n")); err == nil {
+		t.Error("expected an invalid assignment to fail")
+	}
+	builtIn := registry
+	defer Use(builtIn)
+	Use(map[string]string{"AABBCC": "Example"})
+	if Lookup("aa:bb:cc:dd:ee:ff") != "Example" || Lookup("b8:27:eb:12:34:56") != Unknown {
+		t.Error("expected the new registry to be used")
+	}
+}
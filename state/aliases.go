@@ -0,0 +1,281 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const aliasesBucket = "device-aliases"
+const clientIDsBucket = "client-ids"
+
+// The number of hosts that two devices must have requested, and the share of them that they have
+// in common, before their requests suggest that they are the same device
+const (
+	minSharedHosts = 10
+	minSharedRatio = 0.5
+)
+
+// MergeSuggestion : a device that looks like it is another device using a different MAC address,
+// along with why it looks like it
+type MergeSuggestion struct {
+	Primary string
+	Alias   string
+	Reasons []string
+}
+
+// primary is the MAC address of the device that the device has been merged into, or the
+// MAC address itself if it has not been merged, the lock must be held
+func (store *Store) primary(mac string) string {
+	if primary, merged := store.aliases[mac]; merged {
+		return primary
+	}
+	return mac
+}
+
+// identity is the device that requests from the device are recorded against, the lock must be held
+func (store *Store) identity(mac string) *Device {
+	if device, exists := store.devicesByMAC[store.primary(mac)]; exists {
+		return device
+	}
+	return store.devicesByMAC[mac]
+}
+
+// aliasesOf are the MAC addresses that have been merged into the device in order, the lock must be held
+func (store *Store) aliasesOf(primary string) []string {
+	aliases := make([]string, 0)
+	for alias, mac := range store.aliases {
+		if mac == primary {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// macsOf is the MAC address of the device followed by those that have been merged into it,
+// the lock must be held
+func (store *Store) macsOf(mac string) []string {
+	primary := store.primary(mac)
+	return append([]string{primary}, store.aliasesOf(primary)...)
+}
+
+// isAlias is whether the device has been merged into another, the lock must be held
+func (store *Store) isAlias(mac string) bool {
+	_, merged := store.aliases[mac]
+	return merged
+}
+
+// loadAliases loads the merged devices and client IDs, the lock must be held
+func (store *Store) loadAliases() error {
+	err := store.backend.ForEach(aliasesBucket, func(alias string, primary []byte) error {
+		store.aliases[alias] = string(primary)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return store.backend.ForEach(clientIDsBucket, func(mac string, id []byte) error {
+		store.clientIDs[mac] = string(id)
+		return nil
+	})
+}
+
+// Primary : the MAC address of the device that the device has been merged into,
+// or the MAC address itself if it has not been merged
+func (store *Store) Primary(mac string) string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.primary(mac)
+}
+
+// MergeDevices : records that the alias is the same device as the primary, using a different MAC address.
+// The requests, hosts seen, status, profile and ignore flag of the primary are used for both from then on,
+// and devices that had been merged into the alias are merged into the primary too
+func (store *Store) MergeDevices(primary string, alias string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	primary = store.primary(primary)
+	for _, mac := range []string{primary, alias} {
+		if _, exists := store.devicesByMAC[mac]; !exists {
+			return fmt.Errorf("Unknown device: %s", mac)
+		}
+	}
+	if merged, exists := store.aliases[alias]; exists {
+		return fmt.Errorf("%s has already been merged into %s", alias, merged)
+	}
+	if alias == primary {
+		return fmt.Errorf("%s cannot be merged into itself", alias)
+	}
+	moved := append(store.aliasesOf(alias), alias)
+	for _, mac := range moved {
+		if err := store.backend.Put(aliasesBucket, mac, []byte(primary)); err != nil {
+			return err
+		}
+		store.aliases[mac] = primary
+	}
+	for _, mac := range moved {
+		store.foldHostsSeen(mac, primary)
+		if last, exists := store.lastSeen[mac]; exists {
+			store.seen(primary, &last)
+		}
+	}
+	return store.rebuild(primary)
+}
+
+// SplitDevice : reverses MergeDevices so that the alias is a device of its own again with the requests it made
+func (store *Store) SplitDevice(alias string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	primary, merged := store.aliases[alias]
+	if !merged {
+		return fmt.Errorf("%s has not been merged into another device", alias)
+	}
+	if err := store.backend.Delete(aliasesBucket, alias); err != nil {
+		return err
+	}
+	delete(store.aliases, alias)
+	err := store.backend.ForEach(hostsSeenBucket, func(key string, data []byte) error {
+		if !strings.HasPrefix(key, alias+" ") {
+			return nil
+		}
+		var seen HostSeen
+		if err := json.Unmarshal(data, &seen); err != nil {
+			return err
+		}
+		store.foldHostSeen(&seen, alias)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	store.seen(alias, store.devicesByMAC[alias].At)
+	if err := store.rebuild(primary); err != nil {
+		return err
+	}
+	return store.rebuild(alias)
+}
+
+// rebuild replaces the retained requests of the device with those saved for it and the devices merged
+// into it, along with the hosts that they requested. The lock must be held
+func (store *Store) rebuild(primary string) error {
+	device := store.devicesByMAC[primary]
+	hosts := make(map[string]*Host, 0)
+	device.Requests = &hosts
+	for _, mac := range store.macsOf(primary) {
+		if mac != primary {
+			empty := make(map[string]*Host, 0)
+			store.devicesByMAC[mac].Requests = &empty
+		}
+		err := store.backend.ForEachRequest(mac, func(request *Request) error {
+			store.seen(primary, request.At)
+			if seen, added := store.hostSeen(primary, request.Host, request.At); added {
+				store.unsaved[seen] = true
+			}
+			device.addRequest(request.At, request.Host, request.Type, request.Outcome, request.Origin)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foldHostsSeen moves the hosts seen by the alias to the primary, keeping the earliest
+// and latest times that either of them requested each host. The lock must be held
+func (store *Store) foldHostsSeen(alias string, primary string) {
+	for _, seen := range store.hostsSeen[alias] {
+		store.foldHostSeen(seen, primary)
+	}
+	delete(store.hostsSeen, alias)
+}
+
+// foldHostSeen records when the host was seen against the device, the lock must be held
+func (store *Store) foldHostSeen(seen *HostSeen, mac string) {
+	if folded, added := store.hostSeen(mac, seen.Host, seen.First); added {
+		store.unsaved[folded] = true
+	}
+	store.hostSeen(mac, seen.Host, seen.Last)
+}
+
+// SetClientID : records the DHCP client ID that the device identified itself with
+func (store *Store) SetClientID(mac string, id string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.clientIDs[mac] == id {
+		return
+	}
+	store.clientIDs[mac] = id
+	err := store.backend.Put(clientIDsBucket, mac, []byte(id))
+	logError("Error saving client ID: %v\n", err)
+}
+
+// GetClientID : the DHCP client ID that the device last identified itself with, empty if it never has
+func (store *Store) GetClientID(mac string) string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.clientIDs[mac]
+}
+
+// SuggestMerges : the devices that look like the same device using different MAC addresses, and why
+func (store *Store) SuggestMerges() []*MergeSuggestion {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	devices := make([]*Device, 0, len(store.devicesByMAC))
+	for mac, device := range store.devicesByMAC {
+		if !store.isAlias(mac) && device.Mac != device.IP {
+			devices = append(devices, device)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].At.Equal(*devices[j].At) {
+			return devices[i].Mac < devices[j].Mac
+		}
+		return devices[i].At.Before(*devices[j].At)
+	})
+	suggestions := make([]*MergeSuggestion, 0)
+	for i, first := range devices {
+		for _, second := range devices[i+1:] {
+			reasons := store.mergeReasons(first, second)
+			if len(reasons) == 0 {
+				continue
+			}
+			if first.Randomized() && !second.Randomized() {
+				suggestions = append(suggestions, &MergeSuggestion{second.Mac, first.Mac, reasons})
+			} else {
+				suggestions = append(suggestions, &MergeSuggestion{first.Mac, second.Mac, reasons})
+			}
+		}
+	}
+	return suggestions
+}
+
+// mergeReasons are why the devices look like the same device, the lock must be held
+func (store *Store) mergeReasons(first *Device, second *Device) []string {
+	reasons := make([]string, 0)
+	if id := store.clientIDs[first.Mac]; len(id) > 0 && id == store.clientIDs[second.Mac] {
+		reasons = append(reasons, "same DHCP client ID "+id)
+	}
+	if !first.Randomized() && !second.Randomized() {
+		return reasons
+	}
+	if len(first.Hostname) > 0 && first.Hostname != "Unknown" && strings.EqualFold(first.Hostname, second.Hostname) {
+		reasons = append(reasons, "same DHCP hostname "+first.Hostname)
+	}
+	firstHosts, secondHosts := store.hostsSeen[first.Mac], store.hostsSeen[second.Mac]
+	if len(firstHosts) < minSharedHosts || len(secondHosts) < minSharedHosts {
+		return reasons
+	}
+	shared := 0
+	for host := range firstHosts {
+		if _, exists := secondHosts[host]; exists {
+			shared++
+		}
+	}
+	if ratio := float64(shared) / float64(len(firstHosts)+len(secondHosts)-shared); ratio >= minSharedRatio {
+		reasons = append(reasons, fmt.Sprintf("%.0f%% of requested hosts in common", ratio*100))
+	}
+	return reasons
+}
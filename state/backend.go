@@ -0,0 +1,84 @@
+package state
+
+import (
+	"strings"
+	"time"
+)
+
+// The lists of keys that are managed by a Backend
+const (
+	IgnoredList    = "ignored"
+	AuthorizedList = "authorized"
+)
+
+const keyTimeFormat = "2006-01-02T15:04:05.000000000"
+
+// Request : A single request made by a device, as it is persisted, along with how it was
+// answered, the server it was forwarded to and where the answer came from when they are known
+type Request struct {
+	Seq      uint64
+	At       *time.Time
+	Host     string
+	Type     string
+	Outcome  string `json:",omitempty"`
+	Upstream string `json:",omitempty"`
+	Origin   string `json:",omitempty"`
+}
+
+// Backend : Where the state managed by a Store is persisted
+type Backend interface {
+	// LoadDevices : all the devices that have been saved
+	LoadDevices() ([]*Device, error)
+	// SaveDevice : adds or replaces the device with the same MAC
+	SaveDevice(device *Device) error
+
+	// LoadKeys : all the keys in the ignored or authorized list
+	LoadKeys(list string) (map[string]bool, error)
+	// SaveKey : adds a key to the ignored or authorized list
+	SaveKey(list string, key string) error
+	// RemoveKey : removes a key from the ignored or authorized list
+	RemoveKey(list string, key string) error
+
+	// SaveRequest : records the request, assigning its sequence, and adds it to the rollups
+	SaveRequest(mac string, request *Request) error
+	// ForEachRequest : calls the function for each of the device's requests in time order
+	ForEachRequest(mac string, fn func(request *Request) error) error
+	// FindRequests : adds the requests of the devices that are in the query's time range and sequences to the page
+	// in time order, when there is a filter only the requests that it accepts are added
+	FindRequests(macs []string, query *RequestQuery, filter func(request *Request) bool, page *requestPage) error
+	// PruneRequests : removes the requests made before the cutoff
+	PruneRequests(cutoff *time.Time) error
+
+	// LoadRollups : the hourly or daily counts for a device that start between the two times
+	LoadRollups(resolution string, mac string, from *time.Time, to *time.Time) ([]*Rollup, error)
+	// PruneRollups : removes the hourly or daily counts that start before the cutoff
+	PruneRollups(resolution string, cutoff *time.Time) error
+
+	// Get : the value of a key in a bucket of records, nil if it does not exist
+	Get(bucket string, key string) ([]byte, error)
+	// Put : sets the value of a key in a bucket of records
+	Put(bucket string, key string, value []byte) error
+	// Delete : removes a key from a bucket of records
+	Delete(bucket string, key string) error
+	// ForEach : calls the function for each key in a bucket of records in key order
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+
+	// Close : releases the resources held by the backend
+	Close() error
+}
+
+// OpenBackend : Opens the backend described by the url, sqlite://path and memory://
+// are supported alongside bolt://path, a url without a scheme is a bolt file
+func OpenBackend(url string) (Backend, error) {
+	switch {
+	case strings.HasPrefix(url, "sqlite://"):
+		return openSQLite(strings.TrimPrefix(url, "sqlite://"))
+	case strings.HasPrefix(url, "memory://"):
+		return newMemory(), nil
+	}
+	return openBolt(strings.TrimPrefix(url, "bolt://"))
+}
+
+func timeKey(at *time.Time) string {
+	return at.UTC().Format(keyTimeFormat)
+}
@@ -0,0 +1,132 @@
+package state
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+var backendURLs = map[string]string{
+	"bolt://":   "/tmp/backend.bolt",
+	"sqlite://": "/tmp/backend.sqlite",
+	"memory://": "",
+}
+
+func TestBackends(t *testing.T) {
+	for scheme, path := range backendURLs {
+		backend, err := OpenBackend(scheme + path)
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		testBackend(t, scheme, backend)
+		backend.Close()
+		os.Remove(path)
+		os.Remove(path + "-wal")
+		os.Remove(path + "-shm")
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store, err := NewStore("sqlite:///tmp/sqlite-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("/tmp/sqlite-store")
+	at := time.Now()
+	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	store.AddRequest(device, &at, "www.google.com", "A")
+	store.AuthoriseHost("www.another.com")
+	store.IgnoreDevice("AA:BB:CC:DD:EE:00")
+	store.Close()
+
+	store, _ = NewStore("sqlite:///tmp/sqlite-store")
+	defer store.Close()
+	if !store.IsAuthorised("www.another.com") || !store.IsIgnored("AA:BB:CC:DD:EE:00") ||
+		countRequests(store.GetLatestRequests()) != 1 {
+		t.Fail()
+	}
+}
+
+func testBackend(t *testing.T, scheme string, backend Backend) {
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.Local)
+	device := newDevice()
+	device.Mac = "AA:BB:CC:DD:EE:FF"
+	device.Hostname = "hostname"
+	backend.SaveDevice(device)
+	device.Hostname = "renamed"
+	backend.SaveDevice(device)
+	devices, err := backend.LoadDevices()
+	if err != nil || len(devices) != 1 || devices[0].Hostname != "renamed" {
+		t.Errorf("%s: unexpected devices %v %v", scheme, devices, err)
+	}
+
+	backend.SaveKey(AuthorizedList, "www.google.com")
+	backend.SaveKey(AuthorizedList, "www.removed.com")
+	backend.RemoveKey(AuthorizedList, "www.removed.com")
+	keys, err := backend.LoadKeys(AuthorizedList)
+	if ignored, _ := backend.LoadKeys(IgnoredList); err != nil || len(keys) != 1 || !keys["www.google.com"] || len(ignored) != 0 {
+		t.Errorf("%s: unexpected keys %v %v", scheme, keys, err)
+	}
+
+	later := at.Add(time.Hour)
+	first := &Request{0, &later, "www.google.com", "A", "", "", ""}
+	second := &Request{0, &at, "www.google.com", "AAAA", "", "", ""}
+	backend.SaveRequest(device.Mac, first)
+	backend.SaveRequest(device.Mac, second)
+	loaded := make([]*Request, 0)
+	backend.ForEachRequest(device.Mac, func(request *Request) error {
+		loaded = append(loaded, request)
+		return nil
+	})
+	if len(loaded) != 2 || loaded[0].Type != "AAAA" || loaded[1].Seq != first.Seq || second.Seq <= first.Seq {
+		t.Errorf("%s: unexpected requests %v", scheme, loaded)
+	}
+
+	from := at.AddDate(0, 0, -1)
+	to := at.AddDate(0, 0, 1)
+	other := &Request{0, &at, "www.other.com", "A", "", "", ""}
+	backend.SaveRequest("AA:BB:CC:DD:EE:00", other)
+	page := &requestPage{offset: 1, limit: 1}
+	backend.FindRequests([]string{device.Mac, "AA:BB:CC:DD:EE:00"}, &RequestQuery{From: &at, To: &to}, nil, page)
+	if page.total != 3 || len(page.found) != 1 || page.found[0].Seq != other.Seq {
+		t.Errorf("%s: unexpected page %d %v", scheme, page.total, page.found)
+	}
+	page = &requestPage{}
+	backend.FindRequests([]string{device.Mac, "AA:BB:CC:DD:EE:00"}, &RequestQuery{From: &at, To: &later},
+		func(request *Request) bool { return request.Host == "www.google.com" }, page)
+	if page.total != 1 || len(page.found) != 1 || page.found[0].Seq != second.Seq {
+		t.Errorf("%s: unexpected filtered page %d %v", scheme, page.total, page.found)
+	}
+
+	hourly, _ := backend.LoadRollups(Hourly, device.Mac, &from, &to)
+	daily, _ := backend.LoadRollups(Daily, device.Mac, &from, &to)
+	if len(hourly) != 2 || len(daily) != 1 || daily[0].Count != 2 {
+		t.Errorf("%s: unexpected rollups %v %v", scheme, hourly, daily)
+	}
+	backend.PruneRequests(&later)
+	backend.PruneRollups(Hourly, &later)
+	loaded = make([]*Request, 0)
+	backend.ForEachRequest(device.Mac, func(request *Request) error {
+		loaded = append(loaded, request)
+		return nil
+	})
+	hourly, _ = backend.LoadRollups(Hourly, device.Mac, &from, &to)
+	if len(loaded) != 1 || len(hourly) != 1 {
+		t.Errorf("%s: unexpected pruned requests %v %v", scheme, loaded, hourly)
+	}
+
+	backend.Put("settings", "b", []byte("2"))
+	backend.Put("settings", "a", []byte("1"))
+	backend.Put("settings", "c", []byte("3"))
+	backend.Delete("settings", "c")
+	value, _ := backend.Get("settings", "a")
+	missing, _ := backend.Get("settings", "c")
+	order := ""
+	backend.ForEach("settings", func(key string, value []byte) error {
+		order += key
+		return nil
+	})
+	if string(value) != "1" || missing != nil || order != "ab" {
+		t.Errorf("%s: unexpected records %s %s %s", scheme, value, missing, order)
+	}
+}
@@ -0,0 +1,309 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const devicesBucket = "devices"
+const requestsBucket = "requests"
+const hourlyBucket = "hourly"
+const dailyBucket = "daily"
+const recordsBucket = "records"
+
+// boltBackend : persists the state to a bolt file, requests and rollups
+// are kept in a bucket for each device keyed by time
+type boltBackend struct {
+	db *bolt.DB
+}
+
+func openBolt(path string) (Backend, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{devicesBucket, IgnoredList, AuthorizedList, requestsBucket, hourlyBucket, dailyBucket, recordsBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltBackend{db}, nil
+}
+
+func (backend *boltBackend) LoadDevices() ([]*Device, error) {
+	devices := make([]*Device, 0)
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(devicesBucket)).ForEach(func(k []byte, v []byte) error {
+			device := newDevice()
+			err := json.Unmarshal(v, device)
+			logError("Error loading device: %v\n", err)
+			devices = append(devices, device)
+			return nil
+		})
+	})
+	return devices, err
+}
+
+func (backend *boltBackend) SaveDevice(device *Device) error {
+	log.Printf("Persisting device: %v\n", *device)
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(devicesBucket))
+		return bucket.Put([]byte(device.Mac), device.marshall())
+	})
+}
+
+func (backend *boltBackend) LoadKeys(list string) (map[string]bool, error) {
+	result := make(map[string]bool, 0)
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(list)).ForEach(func(k []byte, v []byte) error {
+			result[string(k)] = (v[0] == 1)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (backend *boltBackend) SaveKey(list string, key string) error {
+	log.Printf("Persisting key: %v\n", key)
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(list))
+		return bucket.Put([]byte(key), []byte{1})
+	})
+}
+
+func (backend *boltBackend) RemoveKey(list string, key string) error {
+	log.Printf("Removing key: %v\n", key)
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(list))
+		return bucket.Delete([]byte(key))
+	})
+}
+
+func (backend *boltBackend) SaveRequest(mac string, request *Request) error {
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		requests := tx.Bucket([]byte(requestsBucket))
+		history, err := requests.CreateBucketIfNotExists([]byte(mac))
+		if err != nil {
+			return err
+		}
+		if request.Seq, err = requests.NextSequence(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		if err = history.Put(requestKey(request.At, request.Seq), data); err != nil {
+			return err
+		}
+		if err = boltRollup(tx, Hourly, mac, request); err != nil {
+			return err
+		}
+		return boltRollup(tx, Daily, mac, request)
+	})
+}
+
+func (backend *boltBackend) ForEachRequest(mac string, fn func(request *Request) error) error {
+	return backend.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket([]byte(requestsBucket)).Bucket([]byte(mac))
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(k []byte, v []byte) error {
+			var loaded Request
+			if err := json.Unmarshal(v, &loaded); err != nil {
+				logError("Error loading request: %v\n", err)
+				return nil
+			}
+			return fn(&loaded)
+		})
+	})
+}
+
+// FindRequests merges the devices' histories, which are each ordered by time, by moving on
+// the cursor with the earliest key until every cursor has passed the end of the time range
+func (backend *boltBackend) FindRequests(macs []string, query *RequestQuery, filter func(request *Request) bool, page *requestPage) error {
+	end := ""
+	if query.To != nil {
+		end = timeKey(query.To)
+	}
+	return backend.db.View(func(tx *bolt.Tx) error {
+		cursors := make(map[string]*bolt.Cursor, len(macs))
+		keys := make(map[string][]byte, len(macs))
+		values := make(map[string][]byte, len(macs))
+		for _, mac := range macs {
+			if history := tx.Bucket([]byte(requestsBucket)).Bucket([]byte(mac)); history != nil {
+				cursors[mac] = history.Cursor()
+				if query.From != nil {
+					keys[mac], values[mac] = cursors[mac].Seek([]byte(timeKey(query.From)))
+				} else {
+					keys[mac], values[mac] = cursors[mac].First()
+				}
+			}
+		}
+		for {
+			next := ""
+			for _, mac := range macs {
+				key := keys[mac]
+				if key != nil && (len(end) == 0 || string(key) < end) && (len(next) == 0 || string(key) < string(keys[next])) {
+					next = mac
+				}
+			}
+			if len(next) == 0 {
+				return nil
+			}
+			var loaded Request
+			if err := json.Unmarshal(values[next], &loaded); err != nil {
+				logError("Error loading request: %v\n", err)
+			} else if query.inRange(&loaded) && (filter == nil || filter(&loaded)) {
+				page.add(next, &loaded)
+			}
+			keys[next], values[next] = cursors[next].Next()
+		}
+	})
+}
+
+func (backend *boltBackend) PruneRequests(cutoff *time.Time) error {
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		return boltPrune(tx, requestsBucket, cutoff)
+	})
+}
+
+func (backend *boltBackend) LoadRollups(resolution string, mac string, from *time.Time, to *time.Time) ([]*Rollup, error) {
+	rollups := make([]*Rollup, 0)
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(resolution))
+		if root == nil {
+			return nil
+		}
+		bucket := root.Bucket([]byte(mac))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		end := timeKey(to)
+		for k, v := cursor.Seek([]byte(timeKey(from))); k != nil && string(k) < end; k, v = cursor.Next() {
+			var rollup Rollup
+			if err := json.Unmarshal(v, &rollup); err == nil {
+				rollups = append(rollups, &rollup)
+			}
+		}
+		return nil
+	})
+	return rollups, err
+}
+
+func (backend *boltBackend) PruneRollups(resolution string, cutoff *time.Time) error {
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		return boltPrune(tx, resolution, cutoff)
+	})
+}
+
+func (backend *boltBackend) Get(bucket string, key string) ([]byte, error) {
+	var value []byte
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		if records := tx.Bucket([]byte(recordsBucket)).Bucket([]byte(bucket)); records != nil {
+			if existing := records.Get([]byte(key)); existing != nil {
+				value = append(make([]byte, 0, len(existing)), existing...)
+			}
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (backend *boltBackend) Put(bucket string, key string, value []byte) error {
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		records, err := tx.Bucket([]byte(recordsBucket)).CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return records.Put([]byte(key), value)
+	})
+}
+
+func (backend *boltBackend) Delete(bucket string, key string) error {
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		if records := tx.Bucket([]byte(recordsBucket)).Bucket([]byte(bucket)); records != nil {
+			return records.Delete([]byte(key))
+		}
+		return nil
+	})
+}
+
+func (backend *boltBackend) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return backend.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket([]byte(recordsBucket)).Bucket([]byte(bucket))
+		if records == nil {
+			return nil
+		}
+		return records.ForEach(func(k []byte, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+func (backend *boltBackend) Close() error {
+	return backend.db.Close()
+}
+
+// requestKey orders requests by time, the sequence keeps requests at the same time unique
+func requestKey(at *time.Time, sequence uint64) []byte {
+	return []byte(fmt.Sprintf("%s/%016x", timeKey(at), sequence))
+}
+
+func boltRollup(tx *bolt.Tx, resolution string, mac string, request *Request) error {
+	bucket, err := tx.Bucket([]byte(resolution)).CreateBucketIfNotExists([]byte(mac))
+	if err != nil {
+		return err
+	}
+	start := periodStart(resolution, request.At)
+	key := []byte(timeKey(&start) + "/" + request.Host)
+	rollup := Rollup{&start, request.Host, 0}
+	if existing := bucket.Get(key); existing != nil {
+		json.Unmarshal(existing, &rollup)
+	}
+	rollup.Count++
+	data, err := json.Marshal(&rollup)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// boltPrune removes the keys from each device's bucket that sort before the cutoff
+func boltPrune(tx *bolt.Tx, name string, cutoff *time.Time) error {
+	root := tx.Bucket([]byte(name))
+	if root == nil {
+		return nil
+	}
+	end := timeKey(cutoff)
+	return root.ForEach(func(mac []byte, v []byte) error {
+		bucket := root.Bucket(mac)
+		if bucket == nil {
+			return nil
+		}
+		expired := make([][]byte, 0)
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil && string(k) < end; k, _ = cursor.Next() {
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
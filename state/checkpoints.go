@@ -0,0 +1,15 @@
+package state
+
+const checkpointsBucket = "checkpoints"
+
+// GetCheckpoint : the position that was saved for the log file at the path, nil if none has been
+func (store *Store) GetCheckpoint(path string) []byte {
+	data, err := store.backend.Get(checkpointsBucket, path)
+	logError("Error reading checkpoint: %v\n", err)
+	return data
+}
+
+// SaveCheckpoint : saves the position that the log file at the path has been processed up to
+func (store *Store) SaveCheckpoint(path string, checkpoint []byte) error {
+	return store.backend.Put(checkpointsBucket, path, checkpoint)
+}
@@ -0,0 +1,212 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const groupsBucket = "device-groups"
+const groupMembersBucket = "device-group-members"
+
+// Group : a named group of devices, the hosts authorized for the group are authorized for its devices
+// as well as those authorized for every device, and its devices are ignored when the group is
+type Group struct {
+	Name       string
+	Authorized map[string]bool
+	Ignored    bool
+	matcher    *HostMatcher
+}
+
+// Rules : the rules in the group's list of authorized hosts ordered by key
+func (group *Group) Rules() []*Rule {
+	return sortedRules(group.Authorized)
+}
+
+// snapshot : a copy of the group that is not shared with the store
+func (group *Group) snapshot() *Group {
+	return &Group{group.Name, *copyKeys(group.Authorized), group.Ignored, nil}
+}
+
+// loadGroups loads the groups and the devices in them, the lock must be held
+func (store *Store) loadGroups() error {
+	err := store.backend.ForEach(groupsBucket, func(name string, data []byte) error {
+		var group Group
+		if err := json.Unmarshal(data, &group); err != nil {
+			return err
+		}
+		if group.Authorized == nil {
+			group.Authorized = make(map[string]bool, 0)
+		}
+		group.matcher = NewHostMatcher(group.Authorized)
+		store.groups[name] = &group
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return store.backend.ForEach(groupMembersBucket, func(mac string, name []byte) error {
+		store.members[mac] = string(name)
+		return nil
+	})
+}
+
+// saveGroup saves the group after it has changed, the lock must be held
+func (store *Store) saveGroup(group *Group) error {
+	group.matcher = NewHostMatcher(group.Authorized)
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	return store.backend.Put(groupsBucket, group.Name, data)
+}
+
+// AddGroup : adds an empty group, an error is returned if the name is empty, already used or contains a /
+func (store *Store) AddGroup(name string) error {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || strings.Contains(name, "/") {
+		return fmt.Errorf("A group needs a name without a /")
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, exists := store.groups[name]; exists {
+		return fmt.Errorf("There is already a group called %s", name)
+	}
+	group := &Group{Name: name, Authorized: make(map[string]bool, 0)}
+	if err := store.saveGroup(group); err != nil {
+		return err
+	}
+	store.groups[name] = group
+	return nil
+}
+
+// RemoveGroup : removes the group, its devices are no longer in a group
+func (store *Store) RemoveGroup(name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, exists := store.groups[name]; !exists {
+		return fmt.Errorf("Unknown group: %s", name)
+	}
+	for mac, group := range store.members {
+		if group == name {
+			if err := store.backend.Delete(groupMembersBucket, mac); err != nil {
+				return err
+			}
+			delete(store.members, mac)
+		}
+	}
+	delete(store.groups, name)
+	return store.backend.Delete(groupsBucket, name)
+}
+
+// GetGroups : a snapshot of every group ordered by name
+func (store *Store) GetGroups() []*Group {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	groups := make([]*Group, 0, len(store.groups))
+	for _, group := range store.groups {
+		groups = append(groups, group.snapshot())
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// GetGroup : a snapshot of the group, nil if it is unknown
+func (store *Store) GetGroup(name string) *Group {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if group, exists := store.groups[name]; exists {
+		return group.snapshot()
+	}
+	return nil
+}
+
+// SetGroupIgnored : ignores or stops ignoring the devices in the group
+func (store *Store) SetGroupIgnored(name string, ignored bool) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	group, exists := store.groups[name]
+	if !exists {
+		return fmt.Errorf("Unknown group: %s", name)
+	}
+	group.Ignored = ignored
+	return store.saveGroup(group)
+}
+
+// AuthoriseGroupHost : adds the rule to the group's list of authorized hosts
+func (store *Store) AuthoriseGroupHost(name string, key string) error {
+	if _, err := ParseRule(key); err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	group, exists := store.groups[name]
+	if !exists {
+		return fmt.Errorf("Unknown group: %s", name)
+	}
+	group.Authorized[key] = true
+	return store.saveGroup(group)
+}
+
+// DeauthoriseGroupHost : removes the rule from the group's list of authorized hosts
+func (store *Store) DeauthoriseGroupHost(name string, key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	group, exists := store.groups[name]
+	if !exists {
+		return fmt.Errorf("Unknown group: %s", name)
+	}
+	delete(group.Authorized, key)
+	return store.saveGroup(group)
+}
+
+// SetDeviceGroup : moves the device (or the device it has been merged into) to the group,
+// an empty name removes it from its group. An error is returned if the device or group is unknown
+func (store *Store) SetDeviceGroup(mac string, name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, exists := store.devicesByMAC[mac]; !exists {
+		return fmt.Errorf("Unknown device: %s", mac)
+	}
+	mac = store.primary(mac)
+	if len(name) == 0 {
+		delete(store.members, mac)
+		return store.backend.Delete(groupMembersBucket, mac)
+	}
+	if _, exists := store.groups[name]; !exists {
+		return fmt.Errorf("Unknown group: %s", name)
+	}
+	store.members[mac] = name
+	return store.backend.Put(groupMembersBucket, mac, []byte(name))
+}
+
+// GetDeviceGroup : the name of the group the device is in, empty if it is not in one
+func (store *Store) GetDeviceGroup(mac string) string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.members[store.primary(mac)]
+}
+
+// IsAuthorisedFor : whether the host is matched by any of the authorized rules,
+// or by the rules of the group that the device is in
+func (store *Store) IsAuthorisedFor(mac string, host string) bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if store.matcher.Matches(host) {
+		return true
+	}
+	group, exists := store.groups[store.members[store.primary(mac)]]
+	return exists && group.matcher.Matches(host)
+}
+
+// isIgnored is whether the device, the device it has been merged into or its group
+// has been ignored, the lock must be held
+func (store *Store) isIgnored(mac string) bool {
+	primary := store.primary(mac)
+	if store.ignored[primary] {
+		return true
+	}
+	group, exists := store.groups[store.members[primary]]
+	return exists && group.Ignored
+}
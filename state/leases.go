@@ -0,0 +1,47 @@
+package state
+
+import (
+	"sort"
+	"time"
+)
+
+// lease is when a device was given an IP address
+type lease struct {
+	at  time.Time
+	mac string
+}
+
+// leased records that the device was given the IP address, the leases of each address are kept in time order
+// and a lease that renews the one before it is left out. The lock must be held
+func (store *Store) leased(ip string, mac string, at *time.Time) {
+	leases := store.leases[ip]
+	i := sort.Search(len(leases), func(i int) bool { return leases[i].at.After(*at) })
+	if i > 0 && leases[i-1].mac == mac {
+		return
+	}
+	if i < len(leases) && leases[i].mac == mac {
+		leases[i].at = *at
+		return
+	}
+	leases = append(leases, nil)
+	copy(leases[i+1:], leases[i:])
+	leases[i] = &lease{*at, mac}
+	store.leases[ip] = leases
+}
+
+// FindDeviceByIPAt : Find a copy of the device that had been given the IP address at the time without its requests,
+// the last device to use it when none had been given it by then
+func (store *Store) FindDeviceByIPAt(ip string, at *time.Time) *Device {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	leases := store.leases[ip]
+	if i := sort.Search(len(leases), func(i int) bool { return leases[i].at.After(*at) }); i > 0 {
+		if device, exists := store.devicesByMAC[leases[i-1].mac]; exists {
+			return store.summary(device)
+		}
+	}
+	if device, exists := store.devicesByIP[ip]; exists {
+		return store.summary(device)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// The types of rule that can be used to authorize hosts
+const (
+	ExactRule    = "exact"
+	WildcardRule = "wildcard"
+	DomainRule   = "domain"
+	RegexRule    = "regex"
+)
+
+const domainPrefix = "domain:"
+
+// the prefix of an exact rule for a host that would otherwise be read as another type of rule, such as *.x
+const hostPrefix = "host:"
+
+// Rule : a rule in the authorized list, the key is how the rule is stored:
+// www.google.com (or host:www.google.com), *.googlevideo.com, domain:google.com or /^r[0-9]+\.example\.com$/
+type Rule struct {
+	Key     string
+	Type    string
+	Pattern string
+	regex   *regexp.Regexp
+}
+
+// ParseRule : Parse a rule from the key it is stored as
+func ParseRule(key string) (*Rule, error) {
+	switch {
+	case len(key) > 2 && strings.HasPrefix(key, "/") && strings.HasSuffix(key, "/"):
+		pattern := key[1 : len(key)-1]
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &Rule{key, RegexRule, pattern, regex}, nil
+	case strings.HasPrefix(key, hostPrefix) && len(key) > len(hostPrefix):
+		return &Rule{key, ExactRule, normaliseHost(strings.TrimPrefix(key, hostPrefix)), nil}, nil
+	case strings.HasPrefix(key, domainPrefix):
+		return &Rule{key, DomainRule, normaliseHost(strings.TrimPrefix(key, domainPrefix)), nil}, nil
+	case strings.HasPrefix(key, "*."):
+		return &Rule{key, WildcardRule, normaliseHost(strings.TrimPrefix(key, "*.")), nil}, nil
+	case len(key) == 0:
+		return nil, fmt.Errorf("A host is required")
+	}
+	return &Rule{key, ExactRule, normaliseHost(key), nil}, nil
+}
+
+// NewRule : Create the key for a rule of the given type, validating the pattern,
+// the registrable domain of the pattern is used for domain rules
+func NewRule(ruleType string, pattern string) (string, error) {
+	key := pattern
+	switch ruleType {
+	case RegexRule:
+		key = "/" + pattern + "/"
+	case DomainRule:
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(normaliseHost(pattern)); err == nil {
+			pattern = domain
+		}
+		key = domainPrefix + pattern
+	case WildcardRule:
+		key = "*." + strings.TrimPrefix(pattern, "*.")
+	case ExactRule:
+		if rule, err := ParseRule(pattern); len(pattern) > 0 && (err != nil || rule.Type != ExactRule || rule.Pattern != normaliseHost(pattern)) {
+			key = hostPrefix + pattern
+		}
+	}
+	_, err := ParseRule(key)
+	return key, err
+}
+
+type byKey []*Rule
+
+func (rules byKey) Len() int           { return len(rules) }
+func (rules byKey) Swap(i, j int)      { rules[i], rules[j] = rules[j], rules[i] }
+func (rules byKey) Less(i, j int) bool { return rules[i].Key < rules[j].Key }
+
+// labelNode : a node in a trie of reversed host labels
+type labelNode struct {
+	children map[string]*labelNode
+	exact    bool
+	wildcard bool
+}
+
+// HostMatcher : matches hosts against a set of rules, exact and wildcard rules are held
+// in a trie of reversed labels, domain rules by their registrable domain
+type HostMatcher struct {
+	root    *labelNode
+	domains map[string]bool
+	regexes []*regexp.Regexp
+}
+
+// NewHostMatcher : Create a matcher for the rules stored as the given keys,
+// keys that cannot be parsed are ignored
+func NewHostMatcher(keys map[string]bool) *HostMatcher {
+	matcher := &HostMatcher{&labelNode{children: make(map[string]*labelNode, 0)}, make(map[string]bool, 0), nil}
+	for key := range keys {
+		rule, err := ParseRule(key)
+		if err != nil {
+			logError("Ignoring invalid rule: %v\n", err)
+			continue
+		}
+		matcher.add(rule)
+	}
+	return matcher
+}
+
+func (matcher *HostMatcher) add(rule *Rule) {
+	switch rule.Type {
+	case RegexRule:
+		matcher.regexes = append(matcher.regexes, rule.regex)
+	case DomainRule:
+		matcher.domains[rule.Pattern] = true
+	default:
+		node := matcher.root
+		labels := strings.Split(rule.Pattern, ".")
+		for i := len(labels) - 1; i >= 0; i-- {
+			child, exists := node.children[labels[i]]
+			if !exists {
+				child = &labelNode{children: make(map[string]*labelNode, 0)}
+				node.children[labels[i]] = child
+			}
+			node = child
+		}
+		if rule.Type == WildcardRule {
+			node.wildcard = true
+		} else {
+			node.exact = true
+		}
+	}
+}
+
+// Matches : whether the host is matched by any of the rules
+func (matcher *HostMatcher) Matches(host string) bool {
+	host = normaliseHost(host)
+	node := matcher.root
+	labels := strings.Split(host, ".")
+	for i := len(labels) - 1; i >= 0 && node != nil; i-- {
+		node = node.children[labels[i]]
+		if node != nil && node.wildcard && i > 0 {
+			return true
+		}
+	}
+	if node != nil && node.exact {
+		return true
+	}
+	if len(matcher.domains) > 0 {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil && matcher.domains[domain] {
+			return true
+		}
+	}
+	for _, regex := range matcher.regexes {
+		if regex.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func normaliseHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func sortedRules(keys map[string]bool) []*Rule {
+	rules := make([]*Rule, 0, len(keys))
+	for key := range keys {
+		if rule, err := ParseRule(key); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Sort(byKey(rules))
+	return rules
+}
@@ -0,0 +1,79 @@
+package state
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHostMatcher(t *testing.T) {
+	matcher := NewHostMatcher(map[string]bool{
+		"www.google.com":              true,
+		"*.googlevideo.com":           true,
+		"domain:bbc.co.uk":            true,
+		"/^r[0-9]+\\.example\\.com$/": true,
+		"/[/":                         true,
+	})
+	matches := map[string]bool{
+		"www.google.com":              true,
+		"WWW.Google.com.":             true,
+		"api.google.com":              false,
+		"r3---sn-abc.googlevideo.com": true,
+		"a.b.googlevideo.com":         true,
+		"googlevideo.com":             false,
+		"bbc.co.uk":                   true,
+		"www.bbc.co.uk":               true,
+		"co.uk":                       false,
+		"r12.example.com":             true,
+		"rx.example.com":              false,
+		"www.example.com":             false,
+		"www.google.com.evil.com":     false,
+	}
+	for host, expected := range matches {
+		if matcher.Matches(host) != expected {
+			t.Errorf("Expected %s to match: %v", host, expected)
+		}
+	}
+}
+
+func TestNewRule(t *testing.T) {
+	rules := map[string]string{
+		ExactRule:    "www.google.com",
+		WildcardRule: "*.googlevideo.com",
+		DomainRule:   "domain:google.com",
+		RegexRule:    "/^www\\.google\\.com$/",
+	}
+	patterns := map[string]string{
+		ExactRule:    "www.google.com",
+		WildcardRule: "googlevideo.com",
+		DomainRule:   "maps.google.com",
+		RegexRule:    "^www\\.google\\.com$",
+	}
+	for ruleType, expected := range rules {
+		if key, err := NewRule(ruleType, patterns[ruleType]); err != nil || key != expected {
+			t.Errorf("Expected %s, found %s %v", expected, key, err)
+		}
+	}
+	if _, err := NewRule(RegexRule, "["); err == nil {
+		t.Fail()
+	}
+	// a host that looks like another type of rule only matches itself
+	key, err := NewRule(ExactRule, "*.Example.com")
+	matcher := NewHostMatcher(map[string]bool{key: true})
+	if err != nil || key != "host:*.Example.com" || !matcher.Matches("*.example.com") || matcher.Matches("www.example.com") {
+		t.Errorf("Unexpected exact rule %s %v", key, err)
+	}
+}
+
+func TestAuthoriseRules(t *testing.T) {
+	store, _ := NewStore("/tmp/authorise-rules")
+	defer store.Close()
+	defer os.Remove("/tmp/authorise-rules")
+	store.AuthoriseHost("*.googlevideo.com")
+	if !store.IsAuthorised("r3---sn-abc.googlevideo.com") || store.IsAuthorised("www.google.com") {
+		t.Fail()
+	}
+	store.DeauthoriseHost("*.googlevideo.com")
+	if store.IsAuthorised("r3---sn-abc.googlevideo.com") || len(store.GetAuthorisedRules()) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,273 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errClosed = errors.New("backend is closed")
+
+// memoryBackend : keeps the state in memory, it is lost when the backend is closed
+type memoryBackend struct {
+	lock     sync.Mutex
+	closed   bool
+	sequence uint64
+	devices  map[string][]byte
+	lists    map[string]map[string]bool
+	requests map[string][]*Request
+	rollups  map[string]map[string]map[string]*Rollup
+	records  map[string]map[string][]byte
+}
+
+func newMemory() Backend {
+	return &memoryBackend{
+		devices:  make(map[string][]byte, 0),
+		lists:    map[string]map[string]bool{IgnoredList: {}, AuthorizedList: {}},
+		requests: make(map[string][]*Request, 0),
+		rollups:  map[string]map[string]map[string]*Rollup{Hourly: {}, Daily: {}},
+		records:  make(map[string]map[string][]byte, 0),
+	}
+}
+
+func (backend *memoryBackend) LoadDevices() ([]*Device, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return nil, errClosed
+	}
+	devices := make([]*Device, 0, len(backend.devices))
+	for _, data := range backend.devices {
+		device := newDevice()
+		if err := json.Unmarshal(data, device); err == nil {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+func (backend *memoryBackend) SaveDevice(device *Device) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	backend.devices[device.Mac] = device.marshall()
+	return nil
+}
+
+func (backend *memoryBackend) LoadKeys(list string) (map[string]bool, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return nil, errClosed
+	}
+	return *copyKeys(backend.lists[list]), nil
+}
+
+func (backend *memoryBackend) SaveKey(list string, key string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	backend.lists[list][key] = true
+	return nil
+}
+
+func (backend *memoryBackend) RemoveKey(list string, key string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	delete(backend.lists[list], key)
+	return nil
+}
+
+func (backend *memoryBackend) SaveRequest(mac string, request *Request) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	backend.sequence++
+	request.Seq = backend.sequence
+	saved := *request
+	history := append(backend.requests[mac], &saved)
+	sort.SliceStable(history, func(i, j int) bool { return history[i].At.Before(*history[j].At) })
+	backend.requests[mac] = history
+	for _, resolution := range []string{Hourly, Daily} {
+		if backend.rollups[resolution][mac] == nil {
+			backend.rollups[resolution][mac] = make(map[string]*Rollup, 0)
+		}
+		start := periodStart(resolution, request.At)
+		key := timeKey(&start) + "/" + request.Host
+		if rollup, exists := backend.rollups[resolution][mac][key]; exists {
+			rollup.Count++
+		} else {
+			backend.rollups[resolution][mac][key] = &Rollup{&start, request.Host, 1}
+		}
+	}
+	return nil
+}
+
+func (backend *memoryBackend) ForEachRequest(mac string, fn func(request *Request) error) error {
+	backend.lock.Lock()
+	if backend.closed {
+		backend.lock.Unlock()
+		return errClosed
+	}
+	history := append(make([]*Request, 0), backend.requests[mac]...)
+	backend.lock.Unlock()
+	for _, request := range history {
+		loaded := *request
+		if err := fn(&loaded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (backend *memoryBackend) FindRequests(macs []string, query *RequestQuery, filter func(request *Request) bool, page *requestPage) error {
+	backend.lock.Lock()
+	if backend.closed {
+		backend.lock.Unlock()
+		return errClosed
+	}
+	found := make([]*DeviceRequest, 0)
+	for _, mac := range macs {
+		for _, request := range backend.requests[mac] {
+			if query.inRange(request) && (filter == nil || filter(request)) {
+				found = append(found, &DeviceRequest{mac, *request})
+			}
+		}
+	}
+	backend.lock.Unlock()
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].At.Equal(*found[j].At) {
+			return found[i].Seq < found[j].Seq
+		}
+		return found[i].At.Before(*found[j].At)
+	})
+	for _, request := range found {
+		page.add(request.Mac, &request.Request)
+	}
+	return nil
+}
+
+func (backend *memoryBackend) PruneRequests(cutoff *time.Time) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	for mac, history := range backend.requests {
+		retained := make([]*Request, 0, len(history))
+		for _, request := range history {
+			if !request.At.Before(*cutoff) {
+				retained = append(retained, request)
+			}
+		}
+		backend.requests[mac] = retained
+	}
+	return nil
+}
+
+func (backend *memoryBackend) LoadRollups(resolution string, mac string, from *time.Time, to *time.Time) ([]*Rollup, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return nil, errClosed
+	}
+	rollups := make([]*Rollup, 0)
+	for _, rollup := range backend.rollups[resolution][mac] {
+		if !rollup.Start.Before(*from) && rollup.Start.Before(*to) {
+			copied := *rollup
+			rollups = append(rollups, &copied)
+		}
+	}
+	return rollups, nil
+}
+
+func (backend *memoryBackend) PruneRollups(resolution string, cutoff *time.Time) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	for _, rollups := range backend.rollups[resolution] {
+		for key, rollup := range rollups {
+			if rollup.Start.Before(*cutoff) {
+				delete(rollups, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (backend *memoryBackend) Get(bucket string, key string) ([]byte, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return nil, errClosed
+	}
+	if value, exists := backend.records[bucket][key]; exists {
+		return append(make([]byte, 0, len(value)), value...), nil
+	}
+	return nil, nil
+}
+
+func (backend *memoryBackend) Put(bucket string, key string, value []byte) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	if backend.records[bucket] == nil {
+		backend.records[bucket] = make(map[string][]byte, 0)
+	}
+	backend.records[bucket][key] = append(make([]byte, 0, len(value)), value...)
+	return nil
+}
+
+func (backend *memoryBackend) Delete(bucket string, key string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return errClosed
+	}
+	delete(backend.records[bucket], key)
+	return nil
+}
+
+func (backend *memoryBackend) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	backend.lock.Lock()
+	if backend.closed {
+		backend.lock.Unlock()
+		return errClosed
+	}
+	keys := make([]string, 0, len(backend.records[bucket]))
+	values := make(map[string][]byte, len(backend.records[bucket]))
+	for key, value := range backend.records[bucket] {
+		keys = append(keys, key)
+		values[key] = value
+	}
+	backend.lock.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (backend *memoryBackend) Close() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	backend.closed = true
+	return nil
+}
@@ -0,0 +1,14 @@
+package state
+
+// How a request was answered, the outcome of a request is the one of these that its answers are for
+// and its origin is whether the answer came from the cache (Cached) or an upstream server (Forwarded)
+// whatever the outcome, so that cached NXDOMAIN and NODATA answers are counted as cache hits
+const (
+	Blocked   = "blocked"
+	NXDomain  = "nxdomain"
+	NoData    = "nodata"
+	HostsFile = "hosts"
+	Local     = "local"
+	Cached    = "cached"
+	Forwarded = "forwarded"
+)
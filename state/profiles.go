@@ -0,0 +1,101 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const profilesBucket = "device-profiles"
+
+// Profile : the friendly name, owner and notes given to a device by its MAC address
+type Profile struct {
+	Name  string
+	Owner string
+	Notes string
+}
+
+// Name : the friendly name of the device, or its DHCP hostname if it has not been given one
+func (device *Device) Name() string {
+	if device.Profile != nil && len(device.Profile.Name) > 0 {
+		return device.Profile.Name
+	}
+	return device.Hostname
+}
+
+// Owner : the person the device belongs to, empty if it has not been given one
+func (device *Device) Owner() string {
+	if device.Profile != nil {
+		return device.Profile.Owner
+	}
+	return ""
+}
+
+// Notes : the notes about the device, empty if it has not been given any
+func (device *Device) Notes() string {
+	if device.Profile != nil {
+		return device.Profile.Notes
+	}
+	return ""
+}
+
+// loadProfiles loads the saved profiles, the lock must be held
+func (store *Store) loadProfiles() error {
+	return store.backend.ForEach(profilesBucket, func(mac string, data []byte) error {
+		var profile Profile
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return err
+		}
+		store.profiles[mac] = &profile
+		return nil
+	})
+}
+
+// GetProfile : the profile of the device, or of the device it has been merged into, nil if it has not been given one
+func (store *Store) GetProfile(mac string) *Profile {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if profile, exists := store.profiles[store.primary(mac)]; exists {
+		copied := *profile
+		return &copied
+	}
+	return nil
+}
+
+// GetOwners : the owners that have been given to devices in alphabetical order
+func (store *Store) GetOwners() []string {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	found := make(map[string]bool, 0)
+	owners := make([]string, 0)
+	for _, profile := range store.profiles {
+		if len(profile.Owner) > 0 && !found[profile.Owner] {
+			found[profile.Owner] = true
+			owners = append(owners, profile.Owner)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// SetProfile : gives the device (or the device it has been merged into) a friendly name, owner and notes,
+// an empty profile removes them. An error is returned if the device is unknown
+func (store *Store) SetProfile(mac string, profile *Profile) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, exists := store.devicesByMAC[mac]; !exists {
+		return fmt.Errorf("Unknown device: %s", mac)
+	}
+	mac = store.primary(mac)
+	if *profile == (Profile{}) {
+		delete(store.profiles, mac)
+		return store.backend.Delete(profilesBucket, mac)
+	}
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	copied := *profile
+	store.profiles[mac] = &copied
+	return store.backend.Put(profilesBucket, mac, data)
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// The resolutions that requests are rolled up into
+const (
+	Hourly = "hourly"
+	Daily  = "daily"
+)
+
+// The most that a request's time can be ahead of the clock, later requests do not move the retention's cutoffs
+const maxClockSkew = 24 * time.Hour
+
+// How often the hosts that have been requested again are saved
+const seenInterval = time.Minute
+
+// Retention : how long requests and their rollups are kept for
+type Retention struct {
+	RawHours        uint64
+	HourlyDays      uint64
+	DailyDays       uint64
+	IntervalMinutes uint64
+}
+
+// DefaultRetention : keeps a week of requests, a month of hourly and a year of daily counts
+func DefaultRetention() *Retention {
+	return &Retention{168, 30, 365, 60}
+}
+
+// Rollup : the number of requests a device made for a host in a period
+type Rollup struct {
+	Start *time.Time
+	Host  string
+	Count uint64
+}
+
+type byStart []*Rollup
+
+func (rollups byStart) Len() int      { return len(rollups) }
+func (rollups byStart) Swap(i, j int) { rollups[i], rollups[j] = rollups[j], rollups[i] }
+func (rollups byStart) Less(i, j int) bool {
+	if rollups[i].Start.Equal(*rollups[j].Start) {
+		return rollups[i].Host < rollups[j].Host
+	}
+	return rollups[i].Start.Before(*rollups[j].Start)
+}
+
+// StartCompactor : periodically prunes requests and rollups that are older than the retention allows,
+// along with expired sessions and nonces, and saves when hosts were last seen every minute
+func (store *Store) StartCompactor(retention *Retention) {
+	store.lock.Lock()
+	store.retention = retention
+	store.lock.Unlock()
+	interval := time.Duration(retention.IntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	log.Printf("Starting compactor every %v\n", interval)
+	go func() {
+		for range time.Tick(interval) {
+			store.Compact()
+			store.removeExpiredSessions(time.Now())
+			store.removeExpiredNonces(time.Now())
+		}
+	}()
+	go func() {
+		for range time.Tick(seenInterval) {
+			logError("Error saving hosts seen: %v\n", store.SaveHostsSeen())
+		}
+	}()
+}
+
+// Compact : removes requests, rollups and hosts seen that are older than the retention allows,
+// the age is measured from the most recent request that is not ahead of the clock so that historic logs are kept
+func (store *Store) Compact() {
+	store.lock.Lock()
+	if store.retention == nil || store.latest.IsZero() {
+		store.lock.Unlock()
+		return
+	}
+	raw := store.latest.Add(-time.Duration(store.retention.RawHours) * time.Hour)
+	hourly := store.latest.AddDate(0, 0, -int(store.retention.HourlyDays))
+	daily := store.latest.AddDate(0, 0, -int(store.retention.DailyDays))
+	log.Printf("Compacting requests before %v\n", raw)
+	for _, device := range store.devicesByMAC {
+		device.removeRequestsBefore(&raw)
+	}
+	store.removeHostsSeenBefore(&daily)
+	store.lock.Unlock()
+	err := store.pruneHostsSeen(&daily)
+	logError("Error compacting hosts seen: %v\n", err)
+	err = store.backend.PruneRequests(&raw)
+	logError("Error compacting requests: %v\n", err)
+	err = store.backend.PruneRollups(Hourly, &hourly)
+	logError("Error compacting hourly rollups: %v\n", err)
+	err = store.backend.PruneRollups(Daily, &daily)
+	logError("Error compacting daily rollups: %v\n", err)
+}
+
+// requested keeps the time of the most recent request that is not too far ahead of the clock. The lock must be held
+func (store *Store) requested(at *time.Time) {
+	if at.After(store.latest) && !at.After(time.Now().Add(maxClockSkew)) {
+		store.latest = *at
+	}
+}
+
+// GetRollups : Get the hourly or daily request counts for a device between two times,
+// the counts of the devices merged into it are added to its own
+func (store *Store) GetRollups(mac string, resolution string, from *time.Time, to *time.Time) []*Rollup {
+	store.lock.RLock()
+	macs := store.macsOf(mac)
+	store.lock.RUnlock()
+	rollups := make([]*Rollup, 0)
+	byPeriod := make(map[string]*Rollup, 0)
+	for _, device := range macs {
+		loaded, err := store.backend.LoadRollups(resolution, device, from, to)
+		logError("Error reading rollups: %v\n", err)
+		for _, rollup := range loaded {
+			key := timeKey(rollup.Start) + " " + rollup.Host
+			if existing, exists := byPeriod[key]; exists {
+				existing.Count += rollup.Count
+				continue
+			}
+			byPeriod[key] = rollup
+			rollups = append(rollups, rollup)
+		}
+	}
+	sort.Sort(byStart(rollups))
+	return rollups
+}
+
+func (host *Host) removeRequestsBefore(cutoff *time.Time) {
+	retained := make([]HostRequest, 0)
+	for _, request := range host.Requests {
+		if !request.At.Before(*cutoff) {
+			retained = append(retained, request)
+		}
+	}
+	host.Requests = retained
+}
+
+func (device *Device) removeRequestsBefore(cutoff *time.Time) {
+	for name, host := range *device.Requests {
+		host.removeRequestsBefore(cutoff)
+		if len(host.Requests) == 0 {
+			delete(*device.Requests, name)
+		}
+	}
+}
+
+func periodStart(resolution string, at *time.Time) time.Time {
+	if resolution == Daily {
+		year, month, day := at.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, at.Location())
+	}
+	return at.Truncate(time.Hour)
+}
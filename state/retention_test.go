@@ -0,0 +1,67 @@
+package state
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRollups(t *testing.T) {
+	store, _ := NewStore("/tmp/rollups")
+	defer store.Close()
+	defer os.Remove("/tmp/rollups")
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.Local)
+	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	for i := 0; i < 3; i++ {
+		next := at.Add(time.Duration(i*30) * time.Minute)
+		store.AddRequest(device, &next, "www.google.com", "A")
+	}
+	from := at.AddDate(0, 0, -1)
+	to := at.AddDate(0, 0, 1)
+	hourly := store.GetRollups(device.Mac, Hourly, &from, &to)
+	daily := store.GetRollups(device.Mac, Daily, &from, &to)
+	if len(hourly) != 2 || hourly[0].Count != 2 || hourly[1].Count != 1 ||
+		len(daily) != 1 || daily[0].Count != 3 || daily[0].Host != "www.google.com" {
+		t.Fail()
+	}
+}
+
+func TestCompact(t *testing.T) {
+	store, _ := NewStore("/tmp/compact")
+	defer os.Remove("/tmp/compact")
+	store.retention = &Retention{1, 1, 2, 60}
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.Local)
+	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	old := at.AddDate(0, 0, -3)
+	store.AddRequest(device, &old, "www.old.com", "A")
+	recent := at.Add(-30 * time.Minute)
+	store.AddRequest(device, &recent, "www.recent.com", "A")
+	store.AddRequest(device, &at, "www.latest.com", "A")
+	store.Compact()
+	from := at.AddDate(-1, 0, 0)
+	daily := store.GetRollups(device.Mac, Daily, &from, &at)
+	if len(*device.Requests) != 2 || len(daily) != 2 || daily[0].Count != 1 {
+		t.Fail()
+	}
+	if store.GetHostSeen(device.Mac, "www.old.com") != nil || store.GetHostSeen(device.Mac, "www.recent.com") == nil {
+		t.Errorf("expected only the hosts seen before the daily retention to be removed")
+	}
+
+	// a request from a clock that is years ahead does not remove the others
+	future := time.Now().AddDate(10, 0, 0)
+	store.AddRequest(device, &future, "www.future.com", "A")
+	store.Compact()
+	if len(*device.Requests) != 3 {
+		t.Errorf("Unexpected requests %v", *device.Requests)
+	}
+	store.Close()
+
+	store, _ = NewStore("/tmp/compact")
+	defer store.Close()
+	if len(*store.GetDevice("AA:BB:CC:DD:EE:FF").Requests) != 3 {
+		t.Fail()
+	}
+	if store.GetHostSeen("AA:BB:CC:DD:EE:FF", "www.old.com") != nil {
+		t.Errorf("expected the removed host seen not to be loaded")
+	}
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"sort"
+	"time"
+)
+
+// RequestQuery : restricts the requests found by SearchRequests, empty fields match every request,
+// the host is a pattern in the same form as an authorized host rule and only requests with a
+// sequence after Since and up to Until are found. Offset and Limit select a page, a Limit of 0 has no limit
+type RequestQuery struct {
+	Mac    string
+	Host   string
+	From   *time.Time
+	To     *time.Time
+	Since  uint64
+	Until  uint64
+	Offset int
+	Limit  int
+}
+
+// DeviceRequest : a request from the history along with the device that made it
+type DeviceRequest struct {
+	Mac string
+	Request
+}
+
+// requestPage collects the requests of a page as they are found in order, counting every request that was found
+type requestPage struct {
+	offset int
+	limit  int
+	total  int
+	found  []*DeviceRequest
+}
+
+// add counts the request and keeps it when it is in the page
+func (page *requestPage) add(mac string, request *Request) {
+	if page.total >= page.offset && (page.limit == 0 || len(page.found) < page.limit) {
+		page.found = append(page.found, &DeviceRequest{mac, *request})
+	}
+	page.total++
+}
+
+// inRange is whether the request was made in the query's time range and has one of its sequences
+func (query *RequestQuery) inRange(request *Request) bool {
+	return (query.From == nil || !request.At.Before(*query.From)) && (query.To == nil || request.At.Before(*query.To)) &&
+		request.Seq > query.Since && (query.Until == 0 || request.Seq <= query.Until)
+}
+
+// SearchRequests : Find a page of the requests in the history that match the query ordered by time, along with
+// the number that match. The requests of a device include those of the devices merged into it and the time range,
+// sequences and page are applied by the backend. An error is returned if the host pattern is invalid
+func (store *Store) SearchRequests(query *RequestQuery) ([]*DeviceRequest, int, error) {
+	var filter func(request *Request) bool
+	if len(query.Host) > 0 {
+		if _, err := ParseRule(query.Host); err != nil {
+			return nil, 0, err
+		}
+		matcher := NewHostMatcher(map[string]bool{query.Host: true})
+		filter = func(request *Request) bool { return matcher.Matches(request.Host) }
+	}
+	macs := make([]string, 0)
+	store.lock.RLock()
+	for mac := range store.devicesByMAC {
+		if len(query.Mac) == 0 || store.primary(mac) == store.primary(query.Mac) {
+			macs = append(macs, mac)
+		}
+	}
+	store.lock.RUnlock()
+	sort.Strings(macs)
+	page := &requestPage{offset: query.Offset, limit: query.Limit, found: make([]*DeviceRequest, 0)}
+	if err := store.backend.FindRequests(macs, query, filter, page); err != nil {
+		return nil, 0, err
+	}
+	return page.found, page.total, nil
+}
+
+// GetHosts : Get the hosts requested by the device between two times ordered by name,
+// unlike GetLatestRequests this includes every request that has been retained
+func (store *Store) GetHosts(mac string, from *time.Time, to *time.Time) ([]*Host, error) {
+	requests, _, err := store.SearchRequests(&RequestQuery{Mac: mac, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Host, 0)
+	hosts := make([]*Host, 0)
+	for _, request := range requests {
+		host, exists := byName[request.Host]
+		if !exists {
+			host = &Host{Host: request.Host}
+			byName[request.Host] = host
+			hosts = append(hosts, host)
+		}
+		host.AddRequest(request.At, request.Type)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts, nil
+}
@@ -0,0 +1,198 @@
+package state
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+const hostsSeenBucket = "hosts-seen"
+
+// HostSeen : when a device first and last requested a host, these are kept after
+// the requests themselves have been removed
+type HostSeen struct {
+	Mac   string
+	Host  string
+	First *time.Time
+	Last  *time.Time
+}
+
+type byFirstSeen []*HostSeen
+
+func (hosts byFirstSeen) Len() int      { return len(hosts) }
+func (hosts byFirstSeen) Swap(i, j int) { hosts[i], hosts[j] = hosts[j], hosts[i] }
+func (hosts byFirstSeen) Less(i, j int) bool {
+	if hosts[i].First.Equal(*hosts[j].First) {
+		return hosts[i].Mac+" "+hosts[i].Host < hosts[j].Mac+" "+hosts[j].Host
+	}
+	return hosts[i].First.After(*hosts[j].First)
+}
+
+// hostSeen records a request for the host by the device, the lock must be held. It reports
+// whether the device had not requested the host before so that it can be saved straight away,
+// changes to existing hosts are saved by SaveHostsSeen
+func (store *Store) hostSeen(mac string, host string, at *time.Time) (*HostSeen, bool) {
+	hosts, exists := store.hostsSeen[mac]
+	if !exists {
+		hosts = make(map[string]*HostSeen, 0)
+		store.hostsSeen[mac] = hosts
+	}
+	seen, exists := hosts[host]
+	if !exists {
+		first, last := *at, *at
+		seen = &HostSeen{mac, host, &first, &last}
+		hosts[host] = seen
+		return seen, true
+	}
+	if at.Before(*seen.First) {
+		first := *at
+		seen.First = &first
+		store.unsaved[seen] = true
+	}
+	if at.After(*seen.Last) {
+		last := *at
+		seen.Last = &last
+		store.unsaved[seen] = true
+	}
+	return seen, false
+}
+
+func (store *Store) saveHostSeen(seen *HostSeen) error {
+	data, err := json.Marshal(seen)
+	if err != nil {
+		return err
+	}
+	return store.backend.Put(hostsSeenBucket, seen.Mac+" "+seen.Host, data)
+}
+
+// SaveHostsSeen : saves the hosts that have been requested again since they were last saved
+func (store *Store) SaveHostsSeen() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for seen := range store.unsaved {
+		if err := store.saveHostSeen(seen); err != nil {
+			return err
+		}
+		delete(store.unsaved, seen)
+	}
+	return nil
+}
+
+// removeHostsSeenBefore forgets the hosts that were last requested before the cutoff, the lock must be held
+func (store *Store) removeHostsSeenBefore(cutoff *time.Time) {
+	for mac, hosts := range store.hostsSeen {
+		for host, seen := range hosts {
+			if seen.Last.Before(*cutoff) {
+				delete(hosts, host)
+				delete(store.unsaved, seen)
+			}
+		}
+		if len(hosts) == 0 {
+			delete(store.hostsSeen, mac)
+		}
+	}
+}
+
+// pruneHostsSeen removes the saved hosts that were last requested before the cutoff,
+// including those saved against devices that have since been merged
+func (store *Store) pruneHostsSeen(cutoff *time.Time) error {
+	expired := make([]string, 0)
+	err := store.backend.ForEach(hostsSeenBucket, func(key string, data []byte) error {
+		var seen HostSeen
+		if err := json.Unmarshal(data, &seen); err != nil {
+			return err
+		}
+		if seen.Last.Before(*cutoff) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range expired {
+		if err := store.backend.Delete(hostsSeenBucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadHostsSeen loads the saved hosts, those of merged devices are added to the
+// device they were merged into. The lock must be held
+func (store *Store) loadHostsSeen() error {
+	return store.backend.ForEach(hostsSeenBucket, func(key string, data []byte) error {
+		var seen HostSeen
+		if err := json.Unmarshal(data, &seen); err != nil {
+			return err
+		}
+		if primary := store.primary(seen.Mac); primary != seen.Mac {
+			store.foldHostSeen(&seen, primary)
+			return nil
+		}
+		if _, exists := store.hostsSeen[seen.Mac]; !exists {
+			store.hostsSeen[seen.Mac] = make(map[string]*HostSeen, 0)
+		}
+		store.hostsSeen[seen.Mac][seen.Host] = &seen
+		return nil
+	})
+}
+
+// GetHostSeen : when the device first and last requested the host, nil if it never has
+func (store *Store) GetHostSeen(mac string, host string) *HostSeen {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if seen, exists := store.hostsSeen[store.primary(mac)][host]; exists {
+		first, last := *seen.First, *seen.Last
+		return &HostSeen{seen.Mac, seen.Host, &first, &last}
+	}
+	return nil
+}
+
+// GetNewHosts : the hosts that were first requested between from and to, by the device (and those
+// merged into it) when the MAC address is given, ordered from the most recent. Ignored devices are left out
+func (store *Store) GetNewHosts(mac string, from *time.Time, to *time.Time) []*HostSeen {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	found := make([]*HostSeen, 0)
+	for device, hosts := range store.hostsSeen {
+		if (len(mac) > 0 && !strings.EqualFold(device, store.primary(mac))) || store.isIgnored(device) {
+			continue
+		}
+		for _, seen := range hosts {
+			if (from != nil && seen.First.Before(*from)) || (to != nil && !seen.First.Before(*to)) {
+				continue
+			}
+			first, last := *seen.First, *seen.Last
+			found = append(found, &HostSeen{seen.Mac, seen.Host, &first, &last})
+		}
+	}
+	sort.Sort(byFirstSeen(found))
+	return found
+}
+
+// FilterNewHosts : a copy of the requests containing only the hosts that each device requested
+// for the first time, that is the first request the device made for the host is included
+func (store *Store) FilterNewHosts(devices *map[*Device]*map[string]*Host) *map[*Device]*map[string]*Host {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	result := make(map[*Device]*map[string]*Host, 0)
+	for device, hosts := range *devices {
+		filtered := make(map[string]*Host, 0)
+		for name, host := range *hosts {
+			seen, exists := store.hostsSeen[device.Mac][name]
+			if !exists {
+				continue
+			}
+			for _, request := range host.Requests {
+				if !request.At.After(*seen.First) {
+					filtered[name] = host
+					break
+				}
+			}
+		}
+		result[device] = &filtered
+	}
+	return &result
+}
@@ -0,0 +1,317 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	// registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// times are stored using keyTimeFormat in UTC so that they sort correctly as text,
+// the json columns hold the complete record for anything that is not a column
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS devices (mac TEXT PRIMARY KEY, hostname TEXT, ip TEXT, at TEXT, json TEXT)`,
+	`CREATE TABLE IF NOT EXISTS lists (list TEXT, key TEXT, PRIMARY KEY (list, key))`,
+	`CREATE TABLE IF NOT EXISTS requests (seq INTEGER PRIMARY KEY AUTOINCREMENT, mac TEXT, at TEXT, host TEXT, type TEXT, json TEXT)`,
+	`CREATE INDEX IF NOT EXISTS requests_mac_at ON requests (mac, at)`,
+	`CREATE INDEX IF NOT EXISTS requests_at ON requests (at)`,
+	`CREATE TABLE IF NOT EXISTS rollups (resolution TEXT, mac TEXT, start TEXT, host TEXT, count INTEGER, PRIMARY KEY (resolution, mac, start, host))`,
+	`CREATE TABLE IF NOT EXISTS records (bucket TEXT, key TEXT, value BLOB, PRIMARY KEY (bucket, key))`,
+}
+
+// sqliteBackend : persists the state to a SQLite database, the write ahead log is
+// used so that the database can be queried while the monitor is running
+type sqliteBackend struct {
+	db *sql.DB
+}
+
+func openSQLite(path string) (Backend, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range sqliteSchema {
+		if _, err = db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &sqliteBackend{db}, nil
+}
+
+func (backend *sqliteBackend) LoadDevices() ([]*Device, error) {
+	rows, err := backend.db.Query(`SELECT json FROM devices`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	devices := make([]*Device, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		device := newDevice()
+		err := json.Unmarshal(data, device)
+		logError("Error loading device: %v\n", err)
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+func (backend *sqliteBackend) SaveDevice(device *Device) error {
+	log.Printf("Persisting device: %v\n", *device)
+	_, err := backend.db.Exec(`INSERT OR REPLACE INTO devices (mac, hostname, ip, at, json) VALUES (?, ?, ?, ?, ?)`,
+		device.Mac, device.Hostname, device.IP, timeKey(device.At), device.marshall())
+	return err
+}
+
+func (backend *sqliteBackend) LoadKeys(list string) (map[string]bool, error) {
+	rows, err := backend.db.Query(`SELECT key FROM lists WHERE list = ?`, list)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]bool, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		result[key] = true
+	}
+	return result, rows.Err()
+}
+
+func (backend *sqliteBackend) SaveKey(list string, key string) error {
+	log.Printf("Persisting key: %v\n", key)
+	_, err := backend.db.Exec(`INSERT OR REPLACE INTO lists (list, key) VALUES (?, ?)`, list, key)
+	return err
+}
+
+func (backend *sqliteBackend) RemoveKey(list string, key string) error {
+	log.Printf("Removing key: %v\n", key)
+	_, err := backend.db.Exec(`DELETE FROM lists WHERE list = ? AND key = ?`, list, key)
+	return err
+}
+
+func (backend *sqliteBackend) SaveRequest(mac string, request *Request) error {
+	tx, err := backend.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`INSERT INTO requests (mac, at, host, type) VALUES (?, ?, ?, ?)`,
+		mac, timeKey(request.At), request.Host, request.Type)
+	if err != nil {
+		return err
+	}
+	seq, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	request.Seq = uint64(seq)
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE requests SET json = ? WHERE seq = ?`, data, seq); err != nil {
+		return err
+	}
+	for _, resolution := range []string{Hourly, Daily} {
+		start := periodStart(resolution, request.At)
+		_, err = tx.Exec(`INSERT INTO rollups (resolution, mac, start, host, count) VALUES (?, ?, ?, ?, 1)
+			ON CONFLICT (resolution, mac, start, host) DO UPDATE SET count = count + 1`,
+			resolution, mac, timeKey(&start), request.Host)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (backend *sqliteBackend) ForEachRequest(mac string, fn func(request *Request) error) error {
+	rows, err := backend.db.Query(`SELECT json FROM requests WHERE mac = ? ORDER BY at, seq`, mac)
+	if err != nil {
+		return err
+	}
+	loaded := make([]*Request, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return err
+		}
+		var request Request
+		if err := json.Unmarshal(data, &request); err != nil {
+			logError("Error loading request: %v\n", err)
+			continue
+		}
+		loaded = append(loaded, &request)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, request := range loaded {
+		if err := fn(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindRequests counts and pages the requests in the database when there is no filter,
+// otherwise the requests in the time range are read and filtered as they are added
+func (backend *sqliteBackend) FindRequests(macs []string, query *RequestQuery, filter func(request *Request) bool, page *requestPage) error {
+	if len(macs) == 0 {
+		return nil
+	}
+	conditions := []string{"mac IN (?" + strings.Repeat(", ?", len(macs)-1) + ")"}
+	args := make([]interface{}, 0, len(macs)+4)
+	for _, mac := range macs {
+		args = append(args, mac)
+	}
+	if query.From != nil {
+		conditions = append(conditions, "at >= ?")
+		args = append(args, timeKey(query.From))
+	}
+	if query.To != nil {
+		conditions = append(conditions, "at < ?")
+		args = append(args, timeKey(query.To))
+	}
+	if query.Since > 0 {
+		conditions = append(conditions, "seq > ?")
+		args = append(args, query.Since)
+	}
+	if query.Until > 0 {
+		conditions = append(conditions, "seq <= ?")
+		args = append(args, query.Until)
+	}
+	where := strings.Join(conditions, " AND ")
+	statement := `SELECT mac, json FROM requests WHERE ` + where + ` ORDER BY at, seq`
+	if filter == nil {
+		if err := backend.db.QueryRow(`SELECT COUNT(*) FROM requests WHERE `+where, args...).Scan(&page.total); err != nil {
+			return err
+		}
+		limit := page.limit
+		if limit == 0 {
+			limit = -1
+		}
+		statement += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, page.offset)
+	}
+	rows, err := backend.db.Query(statement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mac string
+		var data []byte
+		if err := rows.Scan(&mac, &data); err != nil {
+			return err
+		}
+		var request Request
+		if err := json.Unmarshal(data, &request); err != nil {
+			logError("Error loading request: %v\n", err)
+			continue
+		}
+		if filter == nil {
+			page.found = append(page.found, &DeviceRequest{mac, request})
+		} else if filter(&request) {
+			page.add(mac, &request)
+		}
+	}
+	return rows.Err()
+}
+
+func (backend *sqliteBackend) PruneRequests(cutoff *time.Time) error {
+	_, err := backend.db.Exec(`DELETE FROM requests WHERE at < ?`, timeKey(cutoff))
+	return err
+}
+
+func (backend *sqliteBackend) LoadRollups(resolution string, mac string, from *time.Time, to *time.Time) ([]*Rollup, error) {
+	rows, err := backend.db.Query(`SELECT start, host, count FROM rollups
+		WHERE resolution = ? AND mac = ? AND start >= ? AND start < ? ORDER BY start, host`,
+		resolution, mac, timeKey(from), timeKey(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rollups := make([]*Rollup, 0)
+	for rows.Next() {
+		var start string
+		var rollup Rollup
+		if err := rows.Scan(&start, &rollup.Host, &rollup.Count); err != nil {
+			return nil, err
+		}
+		at, _ := time.ParseInLocation(keyTimeFormat, start, time.UTC)
+		at = at.Local()
+		rollup.Start = &at
+		rollups = append(rollups, &rollup)
+	}
+	return rollups, rows.Err()
+}
+
+func (backend *sqliteBackend) PruneRollups(resolution string, cutoff *time.Time) error {
+	_, err := backend.db.Exec(`DELETE FROM rollups WHERE resolution = ? AND start < ?`, resolution, timeKey(cutoff))
+	return err
+}
+
+func (backend *sqliteBackend) Get(bucket string, key string) ([]byte, error) {
+	var value []byte
+	err := backend.db.QueryRow(`SELECT value FROM records WHERE bucket = ? AND key = ?`, bucket, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (backend *sqliteBackend) Put(bucket string, key string, value []byte) error {
+	_, err := backend.db.Exec(`INSERT OR REPLACE INTO records (bucket, key, value) VALUES (?, ?, ?)`, bucket, key, value)
+	return err
+}
+
+func (backend *sqliteBackend) Delete(bucket string, key string) error {
+	_, err := backend.db.Exec(`DELETE FROM records WHERE bucket = ? AND key = ?`, bucket, key)
+	return err
+}
+
+func (backend *sqliteBackend) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	rows, err := backend.db.Query(`SELECT key, value FROM records WHERE bucket = ? ORDER BY key`, bucket)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0)
+	values := make([][]byte, 0)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (backend *sqliteBackend) Close() error {
+	return backend.db.Close()
+}
@@ -6,48 +6,168 @@ import (
 	"sort"
 	"sync"
 	"time"
-
-	"github.com/boltdb/bolt"
 )
 
-const devicesBucket = "devices"
-const ignoredBucket = "ignored"
-const authorizedBucket = "authorized"
+// HostRequest : a request for a host, the outcome is how it was answered and the origin is where
+// the answer came from, they are only kept for the hosts held by a store and are empty when they are not known
+type HostRequest struct {
+	At      *time.Time
+	Type    string
+	Outcome string
+	Origin  string
+}
 
-// Host : A host a device has requested
+// Host : A host a device has requested and the requests for it
 type Host struct {
-	Host  string
-	Times *[]*time.Time
+	Host     string
+	Requests []HostRequest
+}
+
+// snapshot : a copy of the host that is not shared with the store
+func (host *Host) snapshot() *Host {
+	return &Host{host.Host, append(make([]HostRequest, 0, len(host.Requests)), host.Requests...)}
+}
+
+// AddRequest : Add a request of the given query type for this host
+func (host *Host) AddRequest(at *time.Time, qtype string) {
+	host.addRequest(at, qtype, "", "")
+}
+
+// addRequest adds a request that was answered with the outcome from the origin
+func (host *Host) addRequest(at *time.Time, qtype string, outcome string, origin string) {
+	host.Requests = append(host.Requests, HostRequest{at, qtype, outcome, origin})
+}
+
+// Times : when each of the requests for this host was made
+func (host *Host) Times() []*time.Time {
+	times := make([]*time.Time, 0, len(host.Requests))
+	for _, request := range host.Requests {
+		times = append(times, request.At)
+	}
+	return times
+}
+
+// OutcomeCounts : the number of requests for this host by how they were answered,
+// requests whose outcome is not known are left out
+func (host *Host) OutcomeCounts() map[string]int {
+	counts := make(map[string]int, 0)
+	for _, request := range host.Requests {
+		if len(request.Outcome) > 0 {
+			counts[request.Outcome]++
+		}
+	}
+	return counts
+}
+
+// OriginCounts : the number of requests for this host by where their answers came from,
+// Cached or Forwarded, requests whose answers came from neither are left out
+func (host *Host) OriginCounts() map[string]int {
+	counts := make(map[string]int, 0)
+	for _, request := range host.Requests {
+		if origin := request.origin(); len(origin) > 0 {
+			counts[origin]++
+		}
+	}
+	return counts
+}
+
+// origin is where the answer to the request came from, requests recorded before origins were
+// are taken to have come from the cache or an upstream server when that was their outcome
+func (request *HostRequest) origin() string {
+	if len(request.Origin) > 0 {
+		return request.Origin
+	}
+	if request.Outcome == Cached || request.Outcome == Forwarded {
+		return request.Outcome
+	}
+	return ""
+}
+
+// TypeCounts : the number of requests for this host by query type
+func (host *Host) TypeCounts() map[string]int {
+	counts := make(map[string]int, 0)
+	for _, request := range host.Requests {
+		counts[request.Type]++
+	}
+	return counts
 }
 
-// AddRequest : Add a request for this host
-func (host *Host) AddRequest(at *time.Time) {
-	*host.Times = append(*host.Times, at)
+// FilterByType : a copy of this host containing only requests of the given type
+func (host *Host) FilterByType(qtype string) *Host {
+	filtered := &Host{Host: host.Host, Requests: make([]HostRequest, 0)}
+	for _, request := range host.Requests {
+		if request.Type == qtype {
+			filtered.Requests = append(filtered.Requests, request)
+		}
+	}
+	return filtered
 }
 
-// Device : A device that has connected
+// Device : A device that has connected, the profile, the MAC addresses of the
+// devices merged into it and its group are only included in snapshots
 type Device struct {
 	At       *time.Time
 	Hostname string
 	Mac      string
 	IP       string
 	Requests *map[string]*Host
+	Profile  *Profile `json:"-"`
+	Aliases  []string `json:"-"`
+	Group    string   `json:"-"`
+}
+
+// AddRequest : associates a request of the given query type with this device,
+// Store.AddRequest should be used for devices that are held by a store
+func (device *Device) AddRequest(at *time.Time, host string, qtype string) {
+	device.addRequest(at, host, qtype, "", "")
+}
+
+// addRequest associates a request that was answered with the outcome from the origin with this device
+func (device *Device) addRequest(at *time.Time, host string, qtype string, outcome string, origin string) {
+	log.Printf("Adding request: %s %s to %v\n", qtype, host, device)
+	existing, exists := (*device.Requests)[host]
+	if !exists {
+		existing = &Host{Host: host}
+		(*device.Requests)[host] = existing
+	}
+	existing.addRequest(at, qtype, outcome, origin)
+}
+
+// OutcomeCounts : the number of retained requests made by this device by how they were answered,
+// requests whose outcome is not known are left out
+func (device *Device) OutcomeCounts() map[string]int {
+	counts := make(map[string]int, 0)
+	for _, host := range *device.Requests {
+		for outcome, count := range host.OutcomeCounts() {
+			counts[outcome] += count
+		}
+	}
+	return counts
 }
 
-// Name : the name of the device
-func (device *Device) Name() string {
-	return device.Hostname
+// OriginCounts : the number of retained requests made by this device by where their answers came from
+func (device *Device) OriginCounts() map[string]int {
+	counts := make(map[string]int, 0)
+	for _, host := range *device.Requests {
+		for origin, count := range host.OriginCounts() {
+			counts[origin] += count
+		}
+	}
+	return counts
 }
 
-// AddRequest : associates a request with this device
-func (device *Device) AddRequest(at *time.Time, host string) {
-	log.Printf("Adding request: %s to %v\n", host, device)
-	if existing, exists := (*device.Requests)[host]; exists {
-		existing.AddRequest(at)
-	} else {
-		list := append(make([]*time.Time, 0), at)
-		(*device.Requests)[host] = &Host{host, &list}
+// snapshot : a copy of the device and its requests that is not shared with the store
+func (device *Device) snapshot(profile *Profile, aliases []string, group string) *Device {
+	hosts := make(map[string]*Host, len(*device.Requests))
+	for name, host := range *device.Requests {
+		hosts[name] = host.snapshot()
 	}
+	return &Device{device.At, device.Hostname, device.Mac, device.IP, &hosts, profile, aliases, group}
+}
+
+func newDevice() *Device {
+	hosts := make(map[string]*Host, 0)
+	return &Device{&time.Time{}, "Unknown", "00:00:00:00:00:00", "0.0.0.0", &hosts, nil, nil, ""}
 }
 
 func (device *Device) marshall() []byte {
@@ -61,163 +181,379 @@ func (devices byTime) Len() int           { return len(devices) }
 func (devices byTime) Swap(i, j int)      { devices[i], devices[j] = devices[j], devices[i] }
 func (devices byTime) Less(i, j int) bool { return devices[i].At.After(*devices[j].At) }
 
-// Store : all the state that is managed
+type byMac []*Device
+
+func (devices byMac) Len() int           { return len(devices) }
+func (devices byMac) Swap(i, j int)      { devices[i], devices[j] = devices[j], devices[i] }
+func (devices byMac) Less(i, j int) bool { return devices[i].Mac < devices[j].Mac }
+
+// Store : all the state that is managed, it is safe for concurrent use
+// and readers are given snapshots rather than the maps that it manages
 type Store struct {
-	db           *bolt.DB
+	lock         sync.RWMutex
+	tokenLock    sync.Mutex
+	statusLock   sync.Mutex
+	backend      Backend
 	ignored      map[string]bool
 	authorized   map[string]bool
+	matcher      *HostMatcher
 	devicesByIP  map[string]*Device
-	devicesByMAC *sync.Map
+	devicesByMAC map[string]*Device
+	sequence     uint64
+	lastSeen     map[string]time.Time
+	hostsSeen    map[string]map[string]*HostSeen
+	unsaved      map[*HostSeen]bool
+	profiles     map[string]*Profile
+	aliases      map[string]string
+	clientIDs    map[string]string
+	groups       map[string]*Group
+	members      map[string]string
+	retention    *Retention
+	latest       time.Time
+	leases       map[string][]*lease
 }
 
 // IgnoreDevice : adds the device to the list of ignored devices
 func (store *Store) IgnoreDevice(mac string) {
+	store.lock.Lock()
 	store.ignored[mac] = true
-	err := persistKey(store.db, ignoredBucket, mac)
+	store.lock.Unlock()
+	err := store.backend.SaveKey(IgnoredList, mac)
 	logError("Error ignoring device: %v\n", err)
 }
 
 // UnIgnoreDevice : adds the device to the list of ignored devices
 func (store *Store) UnIgnoreDevice(ip string) {
+	store.lock.Lock()
 	delete(store.ignored, ip)
-	err := removeKey(store.db, ignoredBucket, ip)
+	store.lock.Unlock()
+	err := store.backend.RemoveKey(IgnoredList, ip)
 	logError("Error unignoring device: %v\n", err)
 }
 
-// GetIgnoredDevices : gets the list of ignored devices
+// GetIgnoredDevices : gets a copy of the list of ignored devices
 func (store *Store) GetIgnoredDevices() *map[string]bool {
-	return &store.ignored
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return copyKeys(store.ignored)
+}
+
+// IsIgnored : whether the device, the device it has been merged into or its group has been ignored
+func (store *Store) IsIgnored(mac string) bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.isIgnored(mac)
 }
 
 // AuthoriseHost : adds the host to the list of authorized hosts
 func (store *Store) AuthoriseHost(host string) {
+	store.lock.Lock()
 	store.authorized[host] = true
-	err := persistKey(store.db, authorizedBucket, host)
+	store.matcher = NewHostMatcher(store.authorized)
+	store.lock.Unlock()
+	err := store.backend.SaveKey(AuthorizedList, host)
 	logError("Error authorising device: %v\n", err)
 }
 
 // DeauthoriseHost : adds the host to the list of authorized hosts
 func (store *Store) DeauthoriseHost(host string) {
+	store.lock.Lock()
 	delete(store.authorized, host)
-	err := removeKey(store.db, authorizedBucket, host)
+	store.matcher = NewHostMatcher(store.authorized)
+	store.lock.Unlock()
+	err := store.backend.RemoveKey(AuthorizedList, host)
 	logError("Error deauthorising device: %v\n", err)
 }
 
-// GetAuthorisedHosts :
+// GetAuthorisedHosts : gets a copy of the list of authorized hosts
 func (store *Store) GetAuthorisedHosts() *map[string]bool {
-	return &store.authorized
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return copyKeys(store.authorized)
+}
+
+// GetAuthorisedRules : gets the rules in the list of authorized hosts ordered by key
+func (store *Store) GetAuthorisedRules() []*Rule {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return sortedRules(store.authorized)
+}
+
+// IsAuthorised : whether the host is matched by any of the authorized rules
+func (store *Store) IsAuthorised(host string) bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.matcher.Matches(host)
 }
 
 // AddDevice : adds the device to the list of devices
 func (store *Store) AddDevice(at *time.Time, hostname string, ip string, mac string) *Device {
+	device, _ := store.addDevice(at, hostname, ip, mac)
+	return device
+}
+
+// addDevice adds the device, reporting whether its MAC address had not been seen before. A device that is older
+// than the one it would replace, such as one from a lease that was imported, does not replace it and
+// an IP address stays with a device that was given it later
+func (store *Store) addDevice(at *time.Time, hostname string, ip string, mac string) (*Device, bool) {
 	hosts := make(map[string]*Host, 0)
-	device := &Device{at, hostname, mac, ip, &hosts}
+	added := *at
+	device := &Device{&added, hostname, mac, ip, &hosts, nil, nil, ""}
 	log.Printf("Adding device: %v\n", device)
-	store.devicesByIP[ip] = device
-	store.devicesByMAC.Store(mac, device)
-	err := persistDevice(store.db, device)
+	store.lock.Lock()
+	existing, known := store.devicesByMAC[mac]
+	older := known && existing.At.After(added)
+	if !older {
+		store.devicesByMAC[mac] = device
+	}
+	held := store.devicesByMAC[mac]
+	for address, found := range store.devicesByIP {
+		if found.Mac == mac {
+			store.devicesByIP[address] = held
+		}
+	}
+	if current, exists := store.devicesByIP[ip]; !exists || current.Mac == mac || !current.At.After(added) {
+		store.devicesByIP[ip] = held
+	}
+	store.leased(ip, mac, &added)
+	store.seen(store.primary(mac), &added)
+	store.lock.Unlock()
+	if older {
+		return held, false
+	}
+	err := store.backend.SaveDevice(device)
 	logError("Error adding device: %v\n", err)
-	return device
+	return held, !known
+}
+
+// AddRequest : associates a request with the device and records it in the history
+func (store *Store) AddRequest(device *Device, at *time.Time, host string, qtype string) {
+	store.RecordRequest(device, &Request{At: at, Host: host, Type: qtype})
 }
 
-// FindDeviceByIP : Find the last device to use this IP
+// RecordRequest : associates a request with the device, which can be a copy, and records it in the history
+// along with how it was answered, the sequence of the request is assigned. The request is saved under the
+// device's own MAC address and added to the device it has been merged into. The lock is only held
+// while the request is added to the device so that readers do not wait for it to be written
+func (store *Store) RecordRequest(device *Device, request *Request) {
+	requested, host := *request.At, request.Host
+	saved := &Request{0, &requested, host, request.Type, request.Outcome, request.Upstream, request.Origin}
+	store.lock.Lock()
+	if held := store.identity(device.Mac); held != nil {
+		held.addRequest(&requested, host, request.Type, request.Outcome, request.Origin)
+	}
+	primary := store.primary(device.Mac)
+	store.requested(&requested)
+	store.seen(primary, &requested)
+	var first []byte
+	if seen, added := store.hostSeen(primary, host, &requested); added {
+		data, err := json.Marshal(seen)
+		logError("Error saving new host: %v\n", err)
+		first = data
+	}
+	store.lock.Unlock()
+	err := store.backend.SaveRequest(device.Mac, saved)
+	logError("Error adding request: %v\n", err)
+	if first != nil {
+		err = store.backend.Put(hostsSeenBucket, primary+" "+host, first)
+		logError("Error saving new host: %v\n", err)
+	}
+	request.Seq = saved.Seq
+	store.lock.Lock()
+	if saved.Seq > store.sequence {
+		store.sequence = saved.Seq
+	}
+	store.lock.Unlock()
+}
+
+// FindDeviceByIP : Find a copy of the last device to use this IP without its requests, GetDevice has them.
+// A device that has been merged keeps its own MAC address
 func (store *Store) FindDeviceByIP(ip string) *Device {
-	return store.devicesByIP[ip]
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if device, exists := store.devicesByIP[ip]; exists {
+		return store.summary(device)
+	}
+	return nil
+}
+
+// GetDevices : Get a snapshot of every device ordered by MAC address,
+// devices that have been merged into another are only included in its aliases
+func (store *Store) GetDevices() []*Device {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	devices := make([]*Device, 0, len(store.devicesByMAC))
+	for mac, device := range store.devicesByMAC {
+		if !store.isAlias(mac) {
+			devices = append(devices, store.snapshot(device))
+		}
+	}
+	sort.Sort(byMac(devices))
+	return devices
+}
+
+// GetDevice : Get a snapshot of the device with the MAC address, or of the device it has been
+// merged into, nil if it is unknown
+func (store *Store) GetDevice(mac string) *Device {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if device := store.identity(mac); device != nil {
+		return store.snapshot(device)
+	}
+	return nil
+}
+
+// snapshot is a copy of the device along with its profile, aliases and group, the lock must be held
+func (store *Store) snapshot(device *Device) *Device {
+	return device.snapshot(store.profiles[device.Mac], store.aliasesOf(device.Mac), store.members[device.Mac])
+}
+
+// summary is a copy of the device along with the profile, aliases and group of the device it has been merged into,
+// or its own, but without its requests. The lock must be held
+func (store *Store) summary(device *Device) *Device {
+	hosts := make(map[string]*Host, 0)
+	primary := store.primary(device.Mac)
+	return &Device{device.At, device.Hostname, device.Mac, device.IP, &hosts, store.profiles[primary],
+		store.aliasesOf(primary), store.members[primary]}
 }
 
-// GetLatestRequests : Get a map of all the devices
-func (store *Store) GetLatestRequests(reset bool) *map[*Device]*map[string]*Host {
+// LastSeen : the time of the latest request made by the device, or when it was added
+// if it has not made any, nil if the device is unknown
+func (store *Store) LastSeen(mac string) *time.Time {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if at, exists := store.lastSeen[store.primary(mac)]; exists {
+		return &at
+	}
+	return nil
+}
+
+// GetLatestRequests : Get a snapshot of the retained requests made by each device,
+// GetRequestsSince should be used for the requests that a consumer has not yet seen
+func (store *Store) GetLatestRequests() *map[*Device]*map[string]*Host {
 	requests := make(map[*Device]*map[string]*Host, 0)
-	store.devicesByMAC.Range(func(mac, device interface{}) bool {
-		if _, ignored := store.ignored[device.(*Device).Mac]; !ignored {
-			requests[device.(*Device)] = device.(*Device).Requests
-			if reset {
-				hosts := make(map[string]*Host, 0)
-				device.(*Device).Requests = &hosts
-			}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	for _, device := range store.devicesByMAC {
+		if !store.isIgnored(device.Mac) && !store.isAlias(device.Mac) {
+			snapshot := store.snapshot(device)
+			requests[snapshot] = snapshot.Requests
 		}
-		return true
-	})
+	}
 	return &requests
 }
 
+// FilterByType : restricts the requests to those of the given query type,
+// devices with no matching requests are still included
+func FilterByType(devices *map[*Device]*map[string]*Host, qtype string) *map[*Device]*map[string]*Host {
+	filtered := make(map[*Device]*map[string]*Host, 0)
+	for device, hosts := range *devices {
+		matching := make(map[string]*Host, 0)
+		for name, host := range *hosts {
+			if result := host.FilterByType(qtype); len(result.Requests) > 0 {
+				matching[name] = result
+			}
+		}
+		filtered[device] = &matching
+	}
+	return &filtered
+}
+
 // Close : should be called when the store is finished with
 func (store *Store) Close() error {
-	return store.db.Close()
+	err := store.SaveHostsSeen()
+	logError("Error saving hosts seen: %v\n", err)
+	return store.backend.Close()
 }
 
-// NewStore : Create a new empty Store
+// NewStore : Create a Store using the backend described by the url
 func NewStore(url string) (*Store, error) {
-	db, err := bolt.Open(url, 0600, nil)
+	backend, err := OpenBackend(url)
 	if err != nil {
 		return nil, err
 	}
-	ignored := make(map[string]bool, 0)
-	authorized := make(map[string]bool, 0)
-	devices := make([]*Device, 0)
-	db.Update(func(tx *bolt.Tx) error {
-		loadMap(tx, ignoredBucket, ignored)
-		loadMap(tx, authorizedBucket, authorized)
-		loadDevices(tx, &devices)
-		return nil
-	})
-	byIP := make(map[string]*Device, 0)
-	byMAC := &sync.Map{}
-	sort.Sort(byTime(devices))
-	for _, device := range devices {
-		log.Printf("Loading device: %v\n", device)
-		byIP[device.IP] = device
-		byMAC.Store(device.Mac, device)
-	}
-	log.Printf("Loaded ignored: %d authorized: %d devices: %d\n", len(ignored), len(authorized), len(byIP))
-	return &Store{db, ignored, authorized, byIP, byMAC}, nil
+	return NewStoreWithBackend(backend)
 }
 
-func loadMap(tx *bolt.Tx, bucket string, result map[string]bool) {
-	if bucket, err := tx.CreateBucketIfNotExists([]byte(bucket)); err == nil {
-		bucket.ForEach(func(k []byte, v []byte) error {
-			result[string(k)] = (v[0] == 1)
-			return nil
-		})
+// NewStoreWithBackend : Create a Store that loads its state from the backend
+func NewStoreWithBackend(backend Backend) (*Store, error) {
+	ignored, err := backend.LoadKeys(IgnoredList)
+	if err != nil {
+		return nil, err
 	}
-}
-
-func loadDevices(tx *bolt.Tx, devices *[]*Device) {
-	if bucket, err := tx.CreateBucketIfNotExists([]byte(devicesBucket)); err == nil {
-		bucket.ForEach(func(k []byte, v []byte) error {
-			hosts := make(map[string]*Host, 0)
-			device := Device{&time.Time{}, "Unknown", "00:00:00:00:00:00", "0.0.0.0", &hosts}
-			err := json.Unmarshal(v, &device)
-			logError("Error loading device", err)
-			device.Requests = &hosts
-			*devices = append(*devices, &device)
-			return nil
-		})
+	authorized, err := backend.LoadKeys(AuthorizedList)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := backend.LoadDevices()
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{backend: backend, ignored: ignored, authorized: authorized, matcher: NewHostMatcher(authorized),
+		devicesByIP: make(map[string]*Device, 0), devicesByMAC: make(map[string]*Device, 0),
+		lastSeen: make(map[string]time.Time, 0), hostsSeen: make(map[string]map[string]*HostSeen, 0),
+		unsaved: make(map[*HostSeen]bool, 0), profiles: make(map[string]*Profile, 0),
+		aliases: make(map[string]string, 0), clientIDs: make(map[string]string, 0),
+		groups: make(map[string]*Group, 0), members: make(map[string]string, 0), leases: make(map[string][]*lease, 0)}
+	if err := store.loadAliases(); err != nil {
+		return nil, err
+	}
+	if err := store.loadHostsSeen(); err != nil {
+		return nil, err
+	}
+	if err := store.loadProfiles(); err != nil {
+		return nil, err
 	}
+	if err := store.loadGroups(); err != nil {
+		return nil, err
+	}
+	sort.Sort(byTime(devices))
+	for _, device := range devices {
+		store.devicesByMAC[device.Mac] = device
+	}
+	for _, device := range devices {
+		log.Printf("Loading device: %v\n", device)
+		identity := store.identity(device.Mac)
+		store.devicesByIP[device.IP] = device
+		store.leased(device.IP, device.Mac, device.At)
+		store.seen(identity.Mac, device.At)
+		err := store.loadRequests(identity, device.Mac)
+		logError("Error loading requests: %v\n", err)
+	}
+	log.Printf("Loaded ignored: %d authorized: %d devices: %d\n", len(ignored), len(authorized), len(devices))
+	return store, nil
 }
 
-func persistDevice(db *bolt.DB, device *Device) error {
-	log.Printf("Persisting device: %v\n", *device)
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(devicesBucket))
-		return bucket.Put([]byte(device.Mac), device.marshall())
+// loadRequests rebuilds the retained requests made by the device with the MAC address,
+// adding them to the device it has been merged into when it has been
+func (store *Store) loadRequests(device *Device, mac string) error {
+	return store.backend.ForEachRequest(mac, func(request *Request) error {
+		store.requested(request.At)
+		store.seen(device.Mac, request.At)
+		if seen, added := store.hostSeen(device.Mac, request.Host, request.At); added {
+			store.unsaved[seen] = true
+		}
+		if request.Seq > store.sequence {
+			store.sequence = request.Seq
+		}
+		device.addRequest(request.At, request.Host, request.Type, request.Outcome, request.Origin)
+		return nil
 	})
 }
 
-func removeKey(db *bolt.DB, bucket string, key string) error {
-	log.Printf("Removing key: %v\n", key)
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucket))
-		return bucket.Delete([]byte(key))
-	})
+// seen records activity from the device, the lock must be held
+func (store *Store) seen(mac string, at *time.Time) {
+	if last, exists := store.lastSeen[mac]; !exists || at.After(last) {
+		store.lastSeen[mac] = *at
+	}
 }
 
-func persistKey(db *bolt.DB, bucket string, key string) error {
-	log.Printf("Persisting key: %v\n", key)
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucket))
-		return bucket.Put([]byte(key), []byte{1})
-	})
+func copyKeys(source map[string]bool) *map[string]bool {
+	result := make(map[string]bool, len(source))
+	for key, value := range source {
+		result[key] = value
+	}
+	return &result
 }
 
 func logError(msg string, err error) {
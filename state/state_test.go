@@ -1,9 +1,14 @@
 package state
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/tmullender/network-log-monitor/oui"
 )
 
 func TestAuthoriseHost(t *testing.T) {
@@ -61,23 +66,369 @@ func TestAddDeviceAndRequest(t *testing.T) {
 	at := time.Now()
 	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
 	at = at.Add(time.Second)
-	device.AddRequest(&at, "www.google.com")
+	device.AddRequest(&at, "www.google.com", "A")
 	at = at.Add(time.Second)
-	device.AddRequest(&at, "www.another.com")
+	device.AddRequest(&at, "www.another.com", "A")
 	at = at.Add(time.Second)
 	device = store.AddDevice(&at, "another", "127.0.0.2", "AA:BB:CC:DD:EE:GG")
 	at = at.Add(time.Second)
-	device.AddRequest(&at, "www.first.com")
+	device.AddRequest(&at, "www.first.com", "A")
 	at = at.Add(time.Second)
-	device.AddRequest(&at, "www.first.com")
-	store.GetLatestRequests(false)
-	if countRequests(store.GetLatestRequests(true)) != 3 ||
-		countRequests(store.GetLatestRequests(false)) != 0 ||
+	device.AddRequest(&at, "www.first.com", "AAAA")
+	if countRequests(store.GetLatestRequests()) != 3 ||
 		store.FindDeviceByIP("127.0.0.2").Name() != "another" {
 		t.Fail()
 	}
 }
 
+func TestRequestHistory(t *testing.T) {
+	store, _ := NewStore("/tmp/request-history")
+	defer os.Remove("/tmp/request-history")
+	at := time.Now()
+	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	store.AddRequest(device, &at, "www.google.com", "A")
+	store.AddRequest(device, &at, "www.google.com", "AAAA")
+	at = at.Add(time.Second)
+	store.AddRequest(device, &at, "www.another.com", "A")
+	store.Close()
+
+	store, _ = NewStore("/tmp/request-history")
+	hosts := *store.GetDevice("AA:BB:CC:DD:EE:FF").Requests
+	if len(hosts) != 2 || len(hosts["www.google.com"].Requests) != 2 || hosts["www.google.com"].Requests[1].Type != "AAAA" {
+		t.Fail()
+	}
+	_, mark, _ := store.GetRequestsSince(DefaultConsumer)
+	store.AdvanceWatermark(DefaultConsumer, mark)
+	at = at.Add(time.Second)
+	store.AddRequest(store.FindDeviceByIP("127.0.0.1"), &at, "www.first.com", "A")
+	store.Close()
+
+	store, _ = NewStore("/tmp/request-history")
+	defer store.Close()
+	latest, _, _ := store.GetRequestsSince(DefaultConsumer)
+	for _, hosts := range *latest {
+		if _, exists := (*hosts)["www.first.com"]; len(*hosts) != 1 || !exists {
+			t.Fail()
+		}
+	}
+	if len(*store.GetDevice("AA:BB:CC:DD:EE:FF").Requests) != 3 {
+		t.Fail()
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	store, _ := NewStore("memory://")
+	defer store.Close()
+	at := time.Now()
+	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	store.AddRequest(device, &at, "www.google.com", "A")
+	store.AddRequest(device, &at, "www.another.com", "A")
+	_, first, _ := store.GetRequestsSince("first")
+	store.AdvanceWatermark("first", first)
+	store.AddRequest(device, &at, "www.google.com", "AAAA")
+
+	firstLatest, mark, _ := store.GetRequestsSince("first")
+	secondLatest, _, _ := store.GetRequestsSince("second")
+	if countRequests(firstLatest) != 1 || countRequests(secondLatest) != 2 || mark <= first {
+		t.Errorf("unexpected requests %d %d", countRequests(firstLatest), countRequests(secondLatest))
+	}
+	store.AdvanceWatermark("first", first-1)
+	pending, _, _ := store.PendingRequests("first")
+	if store.GetWatermark("first") != first || len(pending) != 1 || pending[0].Type != "AAAA" {
+		t.Errorf("expected the watermark not to move backwards %d", store.GetWatermark("first"))
+	}
+}
+
+func TestDeviceStatus(t *testing.T) {
+	store, _ := NewStore("/tmp/device-status")
+	defer os.Remove("/tmp/device-status")
+	first := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	later := first.Add(time.Hour)
+	legacy := store.AddDevice(&first, "legacy", "127.0.0.2", "AA:BB:CC:DD:EE:00")
+	_, added := store.AddDeviceLease(&first, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	_, again := store.AddDeviceLease(&later, "hostname", "127.0.0.3", "AA:BB:CC:DD:EE:FF")
+	status := store.GetDeviceStatus("AA:BB:CC:DD:EE:FF")
+	if !added || again || status.Status != Quarantined || !status.FirstSeen.Equal(first) ||
+		store.GetDeviceStatus(legacy.Mac).Status != Approved {
+		t.Errorf("unexpected status %v %v %v", added, again, status)
+	}
+	store.Close()
+
+	store, _ = NewStore("/tmp/device-status")
+	defer store.Close()
+	if _, added = store.AddDeviceLease(&later, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF"); added {
+		t.Error("expected the device to be known after a restart")
+	}
+	err := store.SetDeviceStatus("AA:BB:CC:DD:EE:FF", Approved)
+	status = store.GetDeviceStatus("AA:BB:CC:DD:EE:FF")
+	if err != nil || status.Status != Approved || !status.FirstSeen.Equal(first) || len(store.GetDeviceStatuses()) != 1 {
+		t.Errorf("unexpected status %v %v", status, err)
+	}
+	if store.SetDeviceStatus("AA:BB:CC:DD:EE:FF", "trusted") == nil || store.SetDeviceStatus("AA:BB:CC:DD:EE:01", Approved) == nil {
+		t.Error("expected an unknown status and device to be rejected")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	store, _ := NewStore("/tmp/device-profiles")
+	defer os.Remove("/tmp/device-profiles")
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	device := store.AddDevice(&at, "android-1234", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	store.AddDevice(&at, "laptop", "127.0.0.2", "AA:BB:CC:DD:EE:01")
+	if device.Name() != "android-1234" || device.Owner() != "" || store.GetProfile(device.Mac) != nil {
+		t.Errorf("expected the hostname to be used %s", device.Name())
+	}
+	err := store.SetProfile(device.Mac, &Profile{"Tablet", "Sam", "Kitchen"})
+	store.SetProfile("AA:BB:CC:DD:EE:01", &Profile{Owner: "Alex"})
+	if err != nil || store.SetProfile("AA:BB:CC:DD:EE:02", &Profile{Name: "Phone"}) == nil {
+		t.Errorf("expected only known devices to be given a profile %v", err)
+	}
+	store.Close()
+
+	store, _ = NewStore("/tmp/device-profiles")
+	defer store.Close()
+	found := store.GetDevice("AA:BB:CC:DD:EE:FF")
+	owners := store.GetOwners()
+	if found.Name() != "Tablet" || found.Owner() != "Sam" || found.Notes() != "Kitchen" ||
+		store.GetDevice("AA:BB:CC:DD:EE:01").Name() != "laptop" || len(owners) != 2 || owners[0] != "Alex" {
+		t.Errorf("unexpected profile %v %v", found.Profile, owners)
+	}
+	store.SetProfile("AA:BB:CC:DD:EE:FF", &Profile{})
+	if store.GetProfile("AA:BB:CC:DD:EE:FF") != nil || store.GetDevice("AA:BB:CC:DD:EE:FF").Name() != "android-1234" {
+		t.Error("expected an empty profile to be removed")
+	}
+}
+
+func TestGroups(t *testing.T) {
+	store, _ := NewStore("/tmp/device-groups")
+	defer os.Remove("/tmp/device-groups")
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	tablet := store.AddDevice(&at, "tablet", "127.0.0.1", "AA:BB:CC:DD:EE:01")
+	store.AddDevice(&at, "laptop", "127.0.0.2", "AA:BB:CC:DD:EE:02")
+	store.AddDevice(&at, "camera", "127.0.0.3", "AA:BB:CC:DD:EE:03")
+	store.AuthoriseHost("www.google.com")
+	if store.AddGroup("kids") != nil || store.AddGroup("IoT") != nil || store.AddGroup(" kids ") == nil || store.AddGroup("") == nil {
+		t.Error("expected groups to need a unique name")
+	}
+	store.AuthoriseGroupHost("kids", "*.roblox.com")
+	store.SetGroupIgnored("IoT", true)
+	if err := store.SetDeviceGroup(tablet.Mac, "kids"); err != nil || store.SetDeviceGroup("AA:BB:CC:DD:EE:03", "IoT") != nil ||
+		store.SetDeviceGroup("AA:BB:CC:DD:EE:04", "kids") == nil || store.SetDeviceGroup(tablet.Mac, "work") == nil {
+		t.Errorf("expected only known devices and groups to be used %v", err)
+	}
+	store.Close()
+
+	store, _ = NewStore("/tmp/device-groups")
+	defer store.Close()
+	groups := store.GetGroups()
+	if len(groups) != 2 || groups[0].Name != "IoT" || !groups[0].Ignored || len(groups[1].Rules()) != 1 {
+		t.Errorf("unexpected groups %v", groups)
+	}
+	checks := map[string]bool{
+		"AA:BB:CC:DD:EE:01 www.google.com":  true,
+		"AA:BB:CC:DD:EE:01 game.roblox.com": true,
+		"AA:BB:CC:DD:EE:02 www.google.com":  true,
+		"AA:BB:CC:DD:EE:02 game.roblox.com": false,
+	}
+	for check, expected := range checks {
+		parts := strings.Split(check, " ")
+		if store.IsAuthorisedFor(parts[0], parts[1]) != expected {
+			t.Errorf("%s: expected %v", check, expected)
+		}
+	}
+	if !store.IsIgnored("AA:BB:CC:DD:EE:03") || store.IsIgnored(tablet.Mac) || len(*store.GetLatestRequests()) != 2 ||
+		store.GetDevice(tablet.Mac).Group != "kids" {
+		t.Error("expected the devices in an ignored group to be ignored")
+	}
+	store.RemoveGroup("kids")
+	if store.GetDeviceGroup(tablet.Mac) != "" || store.IsAuthorisedFor(tablet.Mac, "game.roblox.com") || store.GetGroup("kids") != nil {
+		t.Error("expected the group to be removed along with its devices")
+	}
+}
+
+func TestOutcomes(t *testing.T) {
+	store, _ := NewStore("/tmp/request-outcomes")
+	defer os.Remove("/tmp/request-outcomes")
+	at := time.Now()
+	device := store.AddDevice(&at, "laptop", "127.0.0.1", "AA:BB:CC:DD:EE:01")
+	store.AddRequest(device, &at, "www.example.com", "A")
+	for _, outcome := range []string{"cached", "cached", "forwarded", "blocked"} {
+		store.RecordRequest(device, &Request{At: &at, Host: "www.google.com", Type: "A", Outcome: outcome, Upstream: "1.1.1.1"})
+	}
+	store.RecordRequest(device, &Request{At: &at, Host: "missing.example", Type: "A", Outcome: NXDomain, Origin: Cached})
+	store.Close()
+
+	store, _ = NewStore("/tmp/request-outcomes")
+	defer store.Close()
+	counts := store.GetDevice(device.Mac).OutcomeCounts()
+	if len(counts) != 4 || counts["cached"] != 2 || counts["blocked"] != 1 || counts["nxdomain"] != 1 {
+		t.Errorf("unexpected outcomes %v", counts)
+	}
+	// the cached NXDOMAIN is a cache hit, requests saved without an origin take it from their outcome
+	if origins := store.GetDevice(device.Mac).OriginCounts(); len(origins) != 2 || origins[Cached] != 3 || origins[Forwarded] != 1 {
+		t.Errorf("unexpected origins %v", origins)
+	}
+	found, _, _ := store.SearchRequests(&RequestQuery{Mac: device.Mac, Host: "www.google.com"})
+	if len(found) != 4 || found[0].Upstream != "1.1.1.1" {
+		t.Errorf("expected the upstream to be saved %v", found)
+	}
+}
+
+func TestMergeDevices(t *testing.T) {
+	store, _ := NewStore("/tmp/device-aliases")
+	defer os.Remove("/tmp/device-aliases")
+	first := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	later := first.Add(time.Hour)
+	phone := store.AddDevice(&first, "phone", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	store.AddRequest(phone, &first, "www.google.com", "A")
+	_, added := store.AddDeviceLease(&later, "phone", "127.0.0.2", "02:11:22:33:44:55")
+	store.AddRequest(store.FindDeviceByIP("127.0.0.2"), &later, "www.example.com", "A")
+	store.SetProfile("AA:BB:CC:DD:EE:FF", &Profile{Name: "Sam's phone"})
+	err := store.MergeDevices("AA:BB:CC:DD:EE:FF", "02:11:22:33:44:55")
+	merged := store.GetDevice("02:11:22:33:44:55")
+	if !added || err != nil || merged.Mac != "AA:BB:CC:DD:EE:FF" || len(*merged.Requests) != 2 || merged.Name() != "Sam's phone" ||
+		len(merged.Aliases) != 1 || len(store.GetDevices()) != 1 || store.GetDeviceStatus("02:11:22:33:44:55").Status != Approved ||
+		store.Primary(store.FindDeviceByIP("127.0.0.2").Mac) != "AA:BB:CC:DD:EE:FF" || !store.LastSeen("AA:BB:CC:DD:EE:FF").Equal(later) {
+		t.Errorf("unexpected merged device %v %v", merged, err)
+	}
+	if store.MergeDevices("02:11:22:33:44:55", "AA:BB:CC:DD:EE:FF") == nil || store.MergeDevices("AA:BB:CC:DD:EE:FF", "02:11:22:33:44:55") == nil ||
+		store.MergeDevices("AA:BB:CC:DD:EE:FF", "02:11:22:33:44:66") == nil {
+		t.Error("expected merging a device into itself, twice or an unknown device to be rejected")
+	}
+	// requested while they are merged, which is saved as the alias's
+	store.AddRequest(store.FindDeviceByIP("127.0.0.2"), &later, "www.merged.com", "A")
+	store.IgnoreDevice("AA:BB:CC:DD:EE:FF")
+	store.Close()
+
+	store, _ = NewStore("/tmp/device-aliases")
+	defer store.Close()
+	end := later.AddDate(0, 0, 1)
+	requests, _, _ := store.SearchRequests(&RequestQuery{Mac: "AA:BB:CC:DD:EE:FF"})
+	if len(requests) != 3 || !store.IsIgnored("02:11:22:33:44:55") || store.GetHostSeen("AA:BB:CC:DD:EE:FF", "www.example.com") == nil ||
+		len(store.GetRollups("AA:BB:CC:DD:EE:FF", Hourly, &first, &end)) != 3 || len(*store.GetDevice("02:11:22:33:44:55").Requests) != 3 {
+		t.Errorf("expected the merged device to be loaded %v", requests)
+	}
+	if err := store.SplitDevice("02:11:22:33:44:55"); err != nil || store.SplitDevice("02:11:22:33:44:55") == nil {
+		t.Errorf("expected the device to be split once %v", err)
+	}
+	split := store.GetDevice("02:11:22:33:44:55")
+	if split.Mac != "02:11:22:33:44:55" || len(*split.Requests) != 2 || len(store.GetDevices()) != 2 || store.IsIgnored(split.Mac) ||
+		store.GetDeviceStatus(split.Mac).Status != Quarantined || len(*store.GetDevice("AA:BB:CC:DD:EE:FF").Requests) != 1 ||
+		store.FindDeviceByIP("127.0.0.2").Mac != split.Mac || store.GetHostSeen(split.Mac, "www.example.com") == nil {
+		t.Errorf("unexpected split device %v", split)
+	}
+	store.AddRequest(store.FindDeviceByIP("127.0.0.2"), &end, "www.split.com", "A")
+	if (*store.GetDevice(split.Mac).Requests)["www.split.com"] == nil || (*store.GetDevice(split.Mac).Requests)["www.merged.com"] == nil ||
+		(*store.GetDevice("AA:BB:CC:DD:EE:FF").Requests)["www.merged.com"] != nil || store.GetHostSeen(split.Mac, "www.merged.com") == nil {
+		t.Error("expected the requests made while merged to be the split device's")
+	}
+}
+
+func TestSuggestMerges(t *testing.T) {
+	store, _ := NewStore("memory://")
+	defer store.Close()
+	at := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	later := at.Add(time.Hour)
+	laptop := store.AddDevice(&at, "laptop", "127.0.0.1", "AA:BB:CC:DD:EE:01")
+	store.AddDevice(&at, "desktop", "127.0.0.2", "AA:BB:CC:DD:EE:02")
+	store.AddDevice(&later, "android-1234", "127.0.0.3", "02:00:00:00:00:01")
+	store.AddDevice(&at, "android-1234", "127.0.0.4", "02:00:00:00:00:02")
+	other := store.AddDevice(&later, "other", "127.0.0.5", "02:00:00:00:00:03")
+	store.AddDevice(&at, "router", "127.0.0.6", "AA:BB:CC:DD:EE:06")
+	store.SetClientID("AA:BB:CC:DD:EE:02", "ff:00:01")
+	store.SetClientID("AA:BB:CC:DD:EE:06", "ff:00:01")
+	for i := 0; i < minSharedHosts+2; i++ {
+		host := fmt.Sprintf("www.host%d.com", i)
+		store.AddRequest(laptop, &later, host, "A")
+		if i > 0 {
+			store.AddRequest(other, &later, host, "A")
+		}
+	}
+	suggestions := store.SuggestMerges()
+	if len(suggestions) != 3 {
+		t.Fatalf("expected three suggestions %v", suggestions)
+	}
+	found := make(map[string]*MergeSuggestion, 0)
+	for _, suggestion := range suggestions {
+		found[suggestion.Alias] = suggestion
+	}
+	if found["02:00:00:00:00:01"].Primary != "02:00:00:00:00:02" || found["AA:BB:CC:DD:EE:06"].Primary != "AA:BB:CC:DD:EE:02" ||
+		found["02:00:00:00:00:03"].Primary != "AA:BB:CC:DD:EE:01" || found["02:00:00:00:00:03"].Reasons[0] != "92% of requested hosts in common" {
+		t.Errorf("unexpected suggestions %v %v %v", found["02:00:00:00:00:01"], found["AA:BB:CC:DD:EE:06"], found["02:00:00:00:00:03"])
+	}
+	store.MergeDevices("02:00:00:00:00:02", "02:00:00:00:00:01")
+	if len(store.SuggestMerges()) != 2 {
+		t.Error("expected merged devices not to be suggested")
+	}
+}
+
+func TestHostsSeen(t *testing.T) {
+	store, _ := NewStore("/tmp/hosts-seen")
+	defer os.Remove("/tmp/hosts-seen")
+	first := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	later := first.Add(time.Hour)
+	device := store.AddDevice(&first, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	store.AddRequest(device, &first, "www.google.com", "A")
+	_, mark, _ := store.GetRequestsSince("new")
+	store.AdvanceWatermark("new", mark)
+	store.AddRequest(device, &later, "www.google.com", "AAAA")
+	store.AddRequest(device, &later, "www.example.com", "A")
+	latest, _, _ := store.GetRequestsSince("new")
+	for _, hosts := range *store.FilterNewHosts(latest) {
+		if _, exists := (*hosts)["www.example.com"]; len(*hosts) != 1 || !exists {
+			t.Errorf("expected only the new host %v", hosts)
+		}
+	}
+	store.SaveHostsSeen()
+	data, _ := store.backend.Get(hostsSeenBucket, "AA:BB:CC:DD:EE:FF www.google.com")
+	var saved HostSeen
+	json.Unmarshal(data, &saved)
+	if !saved.First.Equal(first) || !saved.Last.Equal(later) {
+		t.Errorf("expected the last time to be saved %v", saved)
+	}
+	store.Close()
+
+	store, _ = NewStore("/tmp/hosts-seen")
+	defer store.Close()
+	seen := store.GetHostSeen("AA:BB:CC:DD:EE:FF", "www.google.com")
+	found := store.GetNewHosts("aa:bb:cc:dd:ee:ff", &later, nil)
+	if !seen.First.Equal(first) || !seen.Last.Equal(later) || len(found) != 1 || found[0].Host != "www.example.com" ||
+		len(store.GetNewHosts("", nil, &later)) != 1 || store.GetHostSeen("AA:BB:CC:DD:EE:FF", "www.bing.com") != nil {
+		t.Errorf("unexpected hosts %v %v", seen, found)
+	}
+	store.IgnoreDevice("AA:BB:CC:DD:EE:FF")
+	if len(store.GetNewHosts("", nil, nil)) != 0 {
+		t.Error("expected the hosts of ignored devices to be left out")
+	}
+}
+
+func TestVendors(t *testing.T) {
+	oui.Use(map[string]string{"B827EB": "Raspberry Pi Foundation"})
+	device := &Device{Mac: "b8:27:eb:12:34:56"}
+	if device.Vendor() != "Raspberry Pi Foundation" || device.Randomized() || (&Device{Mac: "02:11:22:33:44:55"}).Vendor() != oui.Unknown {
+		t.Errorf("unexpected vendor %s", device.Vendor())
+	}
+}
+
+func TestFilterByType(t *testing.T) {
+	store, _ := NewStore("/tmp/filter-type")
+	defer store.Close()
+	defer os.Remove("/tmp/filter-type")
+	at := time.Now()
+	device := store.AddDevice(&at, "hostname", "127.0.0.1", "AA:BB:CC:DD:EE:FF")
+	device.AddRequest(&at, "www.google.com", "A")
+	device.AddRequest(&at, "www.google.com", "AAAA")
+	device.AddRequest(&at, "www.google.com", "AAAA")
+	device.AddRequest(&at, "_dns.resolver.arpa", "SVCB")
+	counts := (*device.Requests)["www.google.com"].TypeCounts()
+	if counts["A"] != 1 || counts["AAAA"] != 2 ||
+		countRequests(FilterByType(store.GetLatestRequests(), "AAAA")) != 1 ||
+		countRequests(FilterByType(store.GetLatestRequests(), "SVCB")) != 1 ||
+		countRequests(FilterByType(store.GetLatestRequests(), "MX")) != 0 {
+		t.Fail()
+	}
+}
+
 func TestInvalidUrl(t *testing.T) {
 	_, err := NewStore("/tmp/a path/that does/not exist")
 	if err == nil {
@@ -99,3 +450,19 @@ func countRequests(devices *map[*Device]*map[string]*Host) int {
 	}
 	return count
 }
+
+func TestFindDeviceByIPAt(t *testing.T) {
+	store, _ := NewStore("memory://")
+	defer store.Close()
+	first := time.Date(2019, 5, 24, 12, 0, 0, 0, time.UTC)
+	renewed, later := first.Add(time.Hour), first.Add(2*time.Hour)
+	store.AddDevice(&later, "laptop", "127.0.0.1", "AA:BB:CC:DD:EE:01")
+	store.AddDevice(&first, "phone", "127.0.0.1", "AA:BB:CC:DD:EE:02")
+	store.AddDevice(&renewed, "phone", "127.0.0.1", "AA:BB:CC:DD:EE:02")
+	before, between := first.Add(-time.Minute), renewed.Add(time.Minute)
+	if store.FindDeviceByIPAt("127.0.0.1", &between).Mac != "AA:BB:CC:DD:EE:02" ||
+		store.FindDeviceByIPAt("127.0.0.1", &later).Mac != "AA:BB:CC:DD:EE:01" ||
+		store.FindDeviceByIPAt("127.0.0.1", &before).Mac != "AA:BB:CC:DD:EE:01" || store.FindDeviceByIPAt("127.0.0.2", &later) != nil {
+		t.Errorf("expected the device that had the IP address at the time %v", store.leases["127.0.0.1"])
+	}
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const statusBucket = "device-status"
+
+// The statuses of a device, devices that were seen before statuses were recorded are approved
+const (
+	Quarantined = "quarantined"
+	Approved    = "approved"
+)
+
+// DeviceStatus : whether a device has been approved and when it first joined the network
+type DeviceStatus struct {
+	Status    string
+	FirstSeen *time.Time
+}
+
+// AddDeviceLease : adds the device from a DHCP lease, reporting whether its MAC address had not
+// been seen before, the device is a copy without its requests. New devices are quarantined until they are approved
+func (store *Store) AddDeviceLease(at *time.Time, hostname string, ip string, mac string) (*Device, bool) {
+	device, added := store.addDevice(at, hostname, ip, mac)
+	if added {
+		first := *at
+		store.statusLock.Lock()
+		err := store.saveStatus(mac, &DeviceStatus{Quarantined, &first})
+		store.statusLock.Unlock()
+		logError("Error saving device status: %v\n", err)
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.summary(device), added
+}
+
+// GetDeviceStatus : the status of the device, or of the device it has been merged into,
+// approved if none has been recorded
+func (store *Store) GetDeviceStatus(mac string) *DeviceStatus {
+	return store.getStatus(store.Primary(mac))
+}
+
+func (store *Store) getStatus(mac string) *DeviceStatus {
+	data, err := store.backend.Get(statusBucket, mac)
+	logError("Error reading device status: %v\n", err)
+	if data == nil {
+		return &DeviceStatus{Approved, nil}
+	}
+	var status DeviceStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		logError("Error loading device status: %v\n", err)
+		return &DeviceStatus{Approved, nil}
+	}
+	return &status
+}
+
+// GetDeviceStatuses : the statuses that have been recorded by MAC address
+func (store *Store) GetDeviceStatuses() map[string]*DeviceStatus {
+	statuses := make(map[string]*DeviceStatus, 0)
+	err := store.backend.ForEach(statusBucket, func(mac string, data []byte) error {
+		var status DeviceStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			return err
+		}
+		statuses[mac] = &status
+		return nil
+	})
+	logError("Error reading device statuses: %v\n", err)
+	return statuses
+}
+
+// SetDeviceStatus : approve or quarantine a device, or the device it has been merged into,
+// an error is returned if the status or device is unknown
+func (store *Store) SetDeviceStatus(mac string, status string) error {
+	if status != Quarantined && status != Approved {
+		return fmt.Errorf("Unknown status: %s", status)
+	}
+	device := store.GetDevice(mac)
+	if device == nil {
+		return fmt.Errorf("Unknown device: %s", mac)
+	}
+	store.statusLock.Lock()
+	defer store.statusLock.Unlock()
+	current := store.getStatus(device.Mac)
+	return store.saveStatus(device.Mac, &DeviceStatus{status, current.FirstSeen})
+}
+
+func (store *Store) saveStatus(mac string, status *DeviceStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return store.backend.Put(statusBucket, mac, data)
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"crypto/rand"
+	"strconv"
+	"time"
+)
+
+const secretsBucket = "secrets"
+const noncesBucket = "nonces"
+
+// Secret : Get the secret with the name, a random secret of the given size is created
+// the first time it is asked for so that it is the same after a restart
+func (store *Store) Secret(name string, size int) ([]byte, error) {
+	store.tokenLock.Lock()
+	defer store.tokenLock.Unlock()
+	secret, err := store.backend.Get(secretsBucket, name)
+	if err != nil || secret != nil {
+		return secret, err
+	}
+	secret = make([]byte, size)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, store.backend.Put(secretsBucket, name, secret)
+}
+
+// IsNonceUsed : whether the nonce has already been used
+func (store *Store) IsNonceUsed(nonce string) bool {
+	used, err := store.backend.Get(noncesBucket, nonce)
+	logError("Error reading nonce: %v\n", err)
+	return used != nil
+}
+
+// UseNonce : records that the nonce has been used, false is returned if it already had been,
+// it is remembered until it expires
+func (store *Store) UseNonce(nonce string, expires *time.Time) bool {
+	store.tokenLock.Lock()
+	defer store.tokenLock.Unlock()
+	if used, err := store.backend.Get(noncesBucket, nonce); err != nil || used != nil {
+		logError("Error reading nonce: %v\n", err)
+		return false
+	}
+	err := store.backend.Put(noncesBucket, nonce, []byte(strconv.FormatInt(expires.Unix(), 10)))
+	logError("Error using nonce: %v\n", err)
+	return err == nil
+}
+
+// removeExpiredNonces forgets nonces that have expired, they can no longer be used anyway
+func (store *Store) removeExpiredNonces(now time.Time) {
+	expired := make([]string, 0)
+	err := store.backend.ForEach(noncesBucket, func(key string, value []byte) error {
+		if expires, err := strconv.ParseInt(string(value), 10, 64); err != nil || expires < now.Unix() {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	logError("Error reading nonces: %v\n", err)
+	for _, nonce := range expired {
+		err := store.backend.Delete(noncesBucket, nonce)
+		logError("Error removing nonce: %v\n", err)
+	}
+}
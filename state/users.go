@@ -0,0 +1,110 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const usersBucket = "users"
+const sessionsBucket = "sessions"
+
+// User : a user that can log in, only a hash of the password is kept
+type User struct {
+	Name string
+	Hash []byte
+}
+
+// Session : a logged in user, the CSRF token must accompany any change made in the session
+type Session struct {
+	User    string
+	CSRF    string
+	Expires *time.Time
+}
+
+// SaveUser : adds the user or replaces an existing user with the same name
+func (store *Store) SaveUser(user *User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return store.backend.Put(usersBucket, user.Name, data)
+}
+
+// GetUser : Get the user with the name, nil if there is no such user
+func (store *Store) GetUser(name string) *User {
+	data, err := store.backend.Get(usersBucket, name)
+	logError("Error reading user: %v\n", err)
+	if data == nil {
+		return nil
+	}
+	var user User
+	if err := json.Unmarshal(data, &user); err != nil {
+		logError("Error loading user: %v\n", err)
+		return nil
+	}
+	return &user
+}
+
+// RemoveUser : removes the user, existing sessions are ended when they are next used
+func (store *Store) RemoveUser(name string) error {
+	return store.backend.Delete(usersBucket, name)
+}
+
+// HasUsers : whether any users have been added
+func (store *Store) HasUsers() bool {
+	found := false
+	err := store.backend.ForEach(usersBucket, func(key string, value []byte) error {
+		found = true
+		return nil
+	})
+	logError("Error reading users: %v\n", err)
+	return found
+}
+
+// SaveSession : stores the session under the id
+func (store *Store) SaveSession(id string, session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return store.backend.Put(sessionsBucket, id, data)
+}
+
+// GetSession : Get the session with the id, nil if it does not exist, has expired
+// or belongs to a user that has been removed
+func (store *Store) GetSession(id string) *Session {
+	data, err := store.backend.Get(sessionsBucket, id)
+	logError("Error reading session: %v\n", err)
+	if data == nil {
+		return nil
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil || session.Expires == nil ||
+		session.Expires.Before(time.Now()) || store.GetUser(session.User) == nil {
+		store.RemoveSession(id)
+		return nil
+	}
+	return &session
+}
+
+// RemoveSession : ends the session with the id
+func (store *Store) RemoveSession(id string) error {
+	return store.backend.Delete(sessionsBucket, id)
+}
+
+// removeExpiredSessions removes sessions that were never ended
+func (store *Store) removeExpiredSessions(now time.Time) {
+	expired := make([]string, 0)
+	err := store.backend.ForEach(sessionsBucket, func(key string, value []byte) error {
+		var session Session
+		if json.Unmarshal(value, &session) != nil || session.Expires == nil || session.Expires.Before(now) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	logError("Error reading sessions: %v\n", err)
+	for _, id := range expired {
+		err := store.RemoveSession(id)
+		logError("Error removing session: %v\n", err)
+	}
+}
@@ -0,0 +1,13 @@
+package state
+
+import "github.com/tmullender/network-log-monitor/oui"
+
+// Vendor : the vendor of the device from its MAC address, oui.Unknown if it is not registered
+func (device *Device) Vendor() string {
+	return oui.Lookup(device.Mac)
+}
+
+// Randomized : whether the device is using a randomized MAC address, which has no vendor
+func (device *Device) Randomized() bool {
+	return oui.Randomized(device.Mac)
+}
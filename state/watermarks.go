@@ -0,0 +1,102 @@
+package state
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+const watermarksBucket = "watermarks"
+
+// DefaultConsumer : the consumer that the latest page shows the requests of when no other is chosen,
+// it is the name of the email digest when only one is configured
+const DefaultConsumer = "email"
+
+type bySequence []*DeviceRequest
+
+func (requests bySequence) Len() int           { return len(requests) }
+func (requests bySequence) Swap(i, j int)      { requests[i], requests[j] = requests[j], requests[i] }
+func (requests bySequence) Less(i, j int) bool { return requests[i].Seq < requests[j].Seq }
+
+// GetWatermark : the sequence of the last request delivered to the consumer, 0 if nothing has been
+func (store *Store) GetWatermark(consumer string) uint64 {
+	value, err := store.backend.Get(watermarksBucket, consumer)
+	logError("Error reading watermark: %v\n", err)
+	if value == nil {
+		return 0
+	}
+	mark, _ := strconv.ParseUint(string(value), 10, 64)
+	return mark
+}
+
+// ErrUnknownMark : the mark that a watermark was advanced to is after the last request that was recorded
+var ErrUnknownMark = errors.New("The mark is after the last request")
+
+// AdvanceWatermark : records that the requests up to the mark have been delivered to the consumer,
+// the watermark never moves backwards or past the last request that was recorded
+func (store *Store) AdvanceWatermark(consumer string, mark uint64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if mark > store.sequence {
+		return ErrUnknownMark
+	}
+	if mark <= store.GetWatermark(consumer) {
+		return nil
+	}
+	return store.backend.Put(watermarksBucket, consumer, []byte(strconv.FormatUint(mark, 10)))
+}
+
+// PendingRequests : Get the requests that have not been delivered to the consumer ordered by sequence,
+// along with the mark that the watermark should be advanced to once they have been. Only requests that
+// have been completely saved are included so that none are skipped when the watermark is advanced
+func (store *Store) PendingRequests(consumer string) ([]*DeviceRequest, uint64, error) {
+	watermark := store.GetWatermark(consumer)
+	store.lock.RLock()
+	until := store.sequence
+	store.lock.RUnlock()
+	if until <= watermark {
+		return make([]*DeviceRequest, 0), watermark, nil
+	}
+	requests, _, err := store.SearchRequests(&RequestQuery{Since: watermark, Until: until})
+	if err != nil {
+		return nil, watermark, err
+	}
+	sort.Sort(bySequence(requests))
+	return requests, until, nil
+}
+
+// GetRequestsSince : Get a snapshot of the requests made by each device that have not been delivered
+// to the consumer, along with the mark that the watermark should be advanced to once they have been.
+// The requests of merged devices are included with the device they were merged into
+func (store *Store) GetRequestsSince(consumer string) (*map[*Device]*map[string]*Host, uint64, error) {
+	pending, mark, err := store.PendingRequests(consumer)
+	if err != nil {
+		return nil, mark, err
+	}
+	requests := make(map[*Device]*map[string]*Host, 0)
+	byMac := make(map[string]*Device, 0)
+	store.lock.RLock()
+	for _, device := range store.devicesByMAC {
+		if !store.isIgnored(device.Mac) && !store.isAlias(device.Mac) {
+			hosts := make(map[string]*Host, 0)
+			snapshot := &Device{device.At, device.Hostname, device.Mac, device.IP, &hosts,
+				store.profiles[device.Mac], store.aliasesOf(device.Mac), store.members[device.Mac]}
+			requests[snapshot] = snapshot.Requests
+			for _, mac := range store.macsOf(device.Mac) {
+				byMac[mac] = snapshot
+			}
+		}
+	}
+	store.lock.RUnlock()
+	for _, request := range pending {
+		if device, exists := byMac[request.Mac]; exists {
+			hosts := *device.Requests
+			if host, exists := hosts[request.Host]; exists {
+				host.AddRequest(request.At, request.Type)
+			} else {
+				hosts[request.Host] = &Host{request.Host, []HostRequest{{At: request.At, Type: request.Type}}}
+			}
+		}
+	}
+	return &requests, mark, nil
+}
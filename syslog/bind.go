@@ -0,0 +1,36 @@
+package syslog
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+const bindTimeFormat = "02-Jan-2006 15:04:05.000"
+
+// matches the querylog output either written to a file channel or through syslog,
+// with or without the category, severity and client object address
+var bindQuery = regexp.MustCompile("^(.+?) (?:[^ ]+ named\\[[0-9]+\\]: )?(?:queries: )?(?:info: )?client (?:@0x[0-9a-f]+ )?([0-9a-fA-F.:]+)#[0-9]+(?: \\([^)]*\\))?: (?:view [^:]+: )?query: ([^ ]+) IN ([^ ]+) ")
+
+// Bind : parses the queries logged by BIND when querylog is enabled
+type Bind struct {
+	clock *Clock
+}
+
+// Flush : does nothing as each request is sent as soon as it is parsed
+func (parser *Bind) Flush(devices chan *Device, requests chan *Request) {}
+
+// Waiting : none are waiting as each request is sent as soon as it is parsed
+func (parser *Bind) Waiting() int {
+	return 0
+}
+
+// Parse : parses a single line of a BIND query log
+func (parser *Bind) Parse(line string, devices chan *Device, requests chan *Request) {
+	if match := bindQuery.FindStringSubmatch(line); match != nil {
+		at := parser.clock.Time(match[1], bindTimeFormat, timeFormat)
+		request := &Request{&at, strings.TrimSuffix(match[3], "."), match[2], match[4], map[string]string{}, nil, "", "", ""}
+		log.Printf("Found request: %v\n", request)
+		requests <- request
+	}
+}
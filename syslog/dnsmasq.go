@@ -0,0 +1,222 @@
+package syslog
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+// matches the DNS lines logged by dnsmasq, with log-queries=extra they start with the serial of the query
+// and the address and port of the client, the message is matched by the expressions that follow
+var dnsmasqLine = regexp.MustCompile("^(.+) [^ ]+ dnsmasq(?:\\[[0-9]+\\])?: (?:([0-9]+) [^ ]+/[0-9]+ )?(.+)$")
+var query = regexp.MustCompile("^query\\[([^\\]]+)\\] ([^ ]+) from ([^ ]+)")
+var forwarded = regexp.MustCompile("^forwarded ([^ ]+) to ([^ ]+)")
+var answer = regexp.MustCompile("^(reply|cached(?:-stale)?|config|DHCP|/[^ ]+) ([^ ]+) is (.+)$")
+var ack = regexp.MustCompile("^(.+) [^ ]+ dnsmasq-dhcp.+: (?:[0-9]+ )?DHCPACK.+ ([^ ]+) ([^ ]+) ([^ ]+)")
+var transaction = regexp.MustCompile("dnsmasq-dhcp[^:]*: ([0-9]+) ")
+var clientID = regexp.MustCompile("dnsmasq-dhcp[^:]*: ([0-9]+) sent size: *[0-9]+ option: *61 client-id +([^ ]+)")
+
+// the value dnsmasq logs for a CNAME, the name it points to is in the next answer
+const cname = "<CNAME>"
+
+// The most requests that wait for their answers at once, the oldest is sent when there are more
+const maxPending = 100
+
+// Dnsmasq : parses the queries, forwards, answers and DHCP acknowledgements logged by dnsmasq, along
+// with the client IDs of the acknowledgements when dnsmasq logs DHCP options (log-dhcp). With log-queries=extra
+// the forwards and answers are matched to their query by its serial, otherwise they are for the latest query.
+// A request is sent once it has been answered and a line for another query arrives, or once it has waited
+// resolutionTimeout for its answers
+type Dnsmasq struct {
+	clock       *Clock
+	pending     []*resolution
+	lease       *Device
+	transaction string
+	lines       int
+}
+
+// resolution is a request that is waiting for its answers, the line is the number of the line of its query
+type resolution struct {
+	serial   string
+	request  *Request
+	received time.Time
+	answered bool
+	line     int
+}
+
+// Parse : parses a single line of a dnsmasq log
+func (parser *Dnsmasq) Parse(line string, devices chan *Device, requests chan *Request) {
+	parser.lines++
+	if match := dnsmasqLine.FindStringSubmatch(line); match != nil {
+		parser.parseDNS(requests, &match)
+	} else if match = ack.FindStringSubmatch(line); match != nil {
+		parser.lease = parseAck(devices, &match, parser.clock)
+		parser.transaction = ""
+		if id := transaction.FindStringSubmatch(line); id != nil {
+			parser.transaction = id[1]
+		}
+	} else if match = clientID.FindStringSubmatch(line); match != nil && parser.lease != nil && match[1] == parser.transaction {
+		parseClientID(devices, parser.lease, match[2])
+	}
+}
+
+// Flush : sends the requests that are waiting for their answers
+func (parser *Dnsmasq) Flush(devices chan *Device, requests chan *Request) {
+	for _, pending := range parser.pending {
+		sendRequest(requests, pending.request)
+	}
+	parser.pending = nil
+}
+
+// Waiting : the number of lines that have been parsed since the query of the oldest request
+// that is waiting for its answers, including the query
+func (parser *Dnsmasq) Waiting() int {
+	if len(parser.pending) == 0 {
+		return 0
+	}
+	return parser.lines - parser.pending[0].line + 1
+}
+
+// parseDNS adds a query to the requests that are waiting for their answers, or adds a forward or answer
+// to the request it is for, and then sends the requests that are complete
+func (parser *Dnsmasq) parseDNS(requests chan *Request, match *[]string) {
+	serial, message := (*match)[2], (*match)[3]
+	if found := query.FindStringSubmatch(message); found != nil {
+		if previous := parser.take(serial); previous != nil {
+			sendRequest(requests, previous.request)
+		}
+		at := parser.clock.Time((*match)[1], timeFormat)
+		request := &Request{&at, found[2], found[3], found[1], map[string]string{}, nil, "", "", ""}
+		parser.pending = append(parser.pending, &resolution{serial, request, parser.clock.Now(), false, parser.lines})
+	} else if pending := parser.find(serial); pending != nil {
+		if found := forwarded.FindStringSubmatch(message); found != nil {
+			pending.request.Upstream = found[2]
+			pending.request.Origin = state.Forwarded
+			pending.answeredBy(state.Forwarded)
+		} else if found := answer.FindStringSubmatch(message); found != nil {
+			pending.answer(found[2], found[3])
+			pending.answeredBy(outcome(found[1], found[3]))
+			if origin := origin(found[1]); len(origin) > 0 {
+				pending.request.Origin = origin
+			}
+		}
+	}
+	parser.sendCompleted(requests, serial)
+}
+
+// find is the request with the serial that is waiting for its answers, nil if there is not one
+func (parser *Dnsmasq) find(serial string) *resolution {
+	for _, pending := range parser.pending {
+		if pending.serial == serial {
+			return pending
+		}
+	}
+	return nil
+}
+
+// take removes the request with the serial from those waiting for their answers
+func (parser *Dnsmasq) take(serial string) *resolution {
+	for i, pending := range parser.pending {
+		if pending.serial == serial {
+			parser.pending = append(parser.pending[:i], parser.pending[i+1:]...)
+			return pending
+		}
+	}
+	return nil
+}
+
+// sendCompleted sends the requests for other queries that have been answered, as their answers are logged
+// together, along with those that have waited too long or are the oldest of too many
+func (parser *Dnsmasq) sendCompleted(requests chan *Request, serial string) {
+	now := parser.clock.Now()
+	waiting := make([]*resolution, 0, len(parser.pending))
+	for i, pending := range parser.pending {
+		overdue := now.Sub(pending.received) > resolutionTimeout || len(parser.pending)-i > maxPending
+		if pending.serial != serial && (pending.answered || overdue) {
+			sendRequest(requests, pending.request)
+		} else {
+			waiting = append(waiting, pending)
+		}
+	}
+	parser.pending = waiting
+}
+
+// answer adds an answer to the request, a CNAME is given the name that it points to
+func (pending *resolution) answer(name string, value string) {
+	request := pending.request
+	if last := len(request.Answers) - 1; last >= 0 && request.Answers[last].Value == cname {
+		request.Answers[last].Value = name
+	}
+	request.Answers = append(request.Answers, &Answer{name, value})
+	if value != cname {
+		request.Aliases[value] = name
+	}
+	pending.answered = true
+}
+
+// answeredBy records how the request was answered unless an outcome that is listed before it has been
+func (pending *resolution) answeredBy(outcome string) {
+	if outcomeRanks[outcome] > outcomeRanks[pending.request.Outcome] {
+		pending.request.Outcome = outcome
+	}
+}
+
+// outcome is how an answer was given, by dnsmasq's source for it and its value. Addresses configured,
+// or listed in a hosts file such as an addn-hosts blocklist, to be 0.0.0.0, :: or to not exist are taken to block the host
+func outcome(source string, value string) string {
+	configured, listed := source == "config", strings.HasPrefix(source, "/")
+	switch {
+	case (configured || listed) && (value == "0.0.0.0" || value == "::" || value == "NXDOMAIN" || strings.HasPrefix(value, "NODATA")):
+		return state.Blocked
+	case value == "NXDOMAIN":
+		return state.NXDomain
+	case strings.HasPrefix(value, "NODATA"):
+		return state.NoData
+	case listed:
+		return state.HostsFile
+	case configured || source == "DHCP":
+		return state.Local
+	case strings.HasPrefix(source, "cached"):
+		return state.Cached
+	}
+	return state.Forwarded
+}
+
+// origin is where an answer came from by dnsmasq's source for it, whatever the answer is,
+// empty when it is from the configuration, a hosts file or DHCP
+func origin(source string) string {
+	switch {
+	case strings.HasPrefix(source, "cached"):
+		return state.Cached
+	case source == "reply":
+		return state.Forwarded
+	}
+	return ""
+}
+
+func sendRequest(requests chan *Request, request *Request) {
+	log.Printf("Found request: %v\n", request)
+	requests <- request
+}
+
+func parseAck(devices chan *Device, match *[]string, clock *Clock) *Device {
+	at := clock.Time((*match)[1], timeFormat)
+	device := &Device{&at, (*match)[4], (*match)[3], (*match)[2], ""}
+	log.Printf("Found device: %v\n", device)
+	devices <- device
+	return device
+}
+
+// parseClientID sends a copy of the lease with its client ID, the lease has already been sent
+// so is not changed. Client IDs that are just the MAC address are left out as they add nothing
+func parseClientID(devices chan *Device, lease *Device, id string) {
+	if strings.EqualFold(id, "01:"+lease.Mac) {
+		return
+	}
+	device := &Device{lease.At, lease.Hostname, lease.Mac, lease.IP, id}
+	log.Printf("Found client ID: %v\n", device)
+	devices <- device
+}
@@ -0,0 +1,319 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How often a file that has been read to the end is checked for new lines, rotation and truncation
+const pollInterval = 200 * time.Millisecond
+
+// The number of bytes at the start of a file that are kept with its position to recognise it, so that
+// a file that is truncated and written past the position again (logrotate's copytruncate) is noticed
+const fingerprintSize = 256
+
+// The number of files that a followed file was rotated to whose positions are kept, so that
+// importing one of them skips the lines that were processed while it was followed
+const keptRotations = 10
+
+// The prefix of the keys of the positions of the files that a followed file was rotated to,
+// the rest of the key is the path of the followed file
+const rotatedPrefix = "rotated:"
+
+// Checkpoints : keeps the positions that log files have been processed up to by their paths
+type Checkpoints interface {
+	GetCheckpoint(path string) []byte
+	SaveCheckpoint(path string, checkpoint []byte) error
+}
+
+// Position : how far a file has been read, the offset is after the last complete line
+// and the fingerprint is the start of the file
+type Position struct {
+	Inode       uint64
+	Offset      int64
+	Fingerprint []byte
+}
+
+// line is a line read from a source, lines read from a file have the position after them
+type line struct {
+	text     string
+	position *Position
+}
+
+// follower reads the lines that are written to a file, moving to the new file when the path is
+// rotated and back to the start when the file is truncated
+type follower struct {
+	path        string
+	checkpoints Checkpoints
+	lines       chan *line
+}
+
+// openFile is a file that is being read, the offset is after the last complete line that was read
+type openFile struct {
+	file        *os.File
+	reader      *bufio.Reader
+	inode       uint64
+	offset      int64
+	partial     string
+	fingerprint []byte
+}
+
+// followFile reads the file at the path from the position that was saved for it, or from the start
+// when there is not one, the function saves the position of a line once it has been processed
+func followFile(path string, checkpoints Checkpoints) (chan *line, func(*Position)) {
+	follower := &follower{path, checkpoints, make(chan *line, 10)}
+	go follower.follow(follower.load())
+	return follower.lines, follower.save
+}
+
+// follow carries on from the saved position, which is in the file at the path when it has the same inode,
+// is at least as long and starts with the same bytes, otherwise it is in a file it was rotated or copied to
+func (follower *follower) follow(saved *Position) {
+	current := follower.open()
+	if saved != nil {
+		if current.continues(saved) {
+			log.Printf("Resuming %s from offset %d\n", follower.path, saved.Offset)
+			current.seek(saved.Offset)
+		} else {
+			log.Printf("%s has been rotated or truncated since it was last read\n", follower.path)
+			follower.readRotated(saved)
+		}
+	}
+	for {
+		follower.readLines(current)
+		time.Sleep(pollInterval)
+		if next := follower.replacement(current); next != nil {
+			// the writer carries on writing to the rotated file until it opens the new one
+			for follower.readLines(current) > 0 {
+				time.Sleep(pollInterval)
+			}
+			log.Printf("%s has been rotated\n", follower.path)
+			current.file.Close()
+			current = next
+		} else if current.truncated() {
+			log.Printf("%s has been truncated\n", follower.path)
+			follower.readRotated(current.position())
+			current.seek(0)
+		}
+	}
+}
+
+// open opens the file at the path, waiting for it to be created
+func (follower *follower) open() *openFile {
+	for waited := false; ; waited = true {
+		current, err := openPath(follower.path)
+		if err == nil {
+			return current
+		}
+		if !waited {
+			log.Printf("Waiting for %s: %v\n", follower.path, err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// replacement is the file at the path when it is not the current file, nil when it is or there is no file
+func (follower *follower) replacement(current *openFile) *openFile {
+	info, err := os.Stat(follower.path)
+	if err != nil || inode(info) == current.inode {
+		return nil
+	}
+	next, err := openPath(follower.path)
+	if err != nil {
+		return nil
+	}
+	return next
+}
+
+// readRotated sends the lines after the position from the file that it was rotated or copied to, found by
+// its fingerprint, as they can be written after the last time it was read and before it was rotated
+func (follower *follower) readRotated(position *Position) {
+	if position.Offset == 0 {
+		return
+	}
+	paths, _ := filepath.Glob(follower.path + "?*")
+	for _, path := range paths {
+		rotated, err := openPath(path)
+		if err != nil {
+			continue
+		}
+		if rotated.continues(&Position{rotated.inode, position.Offset, position.Fingerprint}) {
+			log.Printf("Reading the rest of %s from %s\n", follower.path, path)
+			rotated.seek(position.Offset)
+			follower.readLines(rotated)
+			rotated.file.Close()
+			return
+		}
+		rotated.file.Close()
+	}
+}
+
+// readLines sends the complete lines up to the end of the file, returning how many were sent
+func (follower *follower) readLines(current *openFile) int {
+	count := 0
+	for {
+		text, err := current.reader.ReadString('\n')
+		current.partial += text
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Failed to read %s: %v\n", follower.path, err)
+			}
+			return count
+		}
+		current.offset += int64(len(current.partial))
+		follower.lines <- &line{strings.TrimRight(current.partial, "\r\n"), current.position()}
+		current.partial = ""
+		count++
+	}
+}
+
+// load is the position that was saved for the path, nil if there is not one
+func (follower *follower) load() *Position {
+	return loadPosition(follower.checkpoints, follower.path)
+}
+
+// save saves the position of the last line that has been processed, when the position that was saved
+// before is in another file it is kept with those of the files that the path was rotated to
+func (follower *follower) save(position *Position) {
+	if previous := follower.load(); previous != nil && !sameFile(previous, position) {
+		rotated := append(loadRotated(follower.checkpoints, follower.path), previous)
+		if len(rotated) > keptRotations {
+			rotated = rotated[len(rotated)-keptRotations:]
+		}
+		saveRotated(follower.checkpoints, follower.path, rotated)
+	}
+	savePosition(follower.checkpoints, follower.path, position)
+}
+
+// loadPosition is the position that was saved with the key, nil if there is not one
+func loadPosition(checkpoints Checkpoints, key string) *Position {
+	if checkpoints == nil {
+		return nil
+	}
+	data := checkpoints.GetCheckpoint(key)
+	if data == nil {
+		return nil
+	}
+	var position Position
+	if err := json.Unmarshal(data, &position); err != nil {
+		log.Printf("Failed to load the position of %s: %v\n", key, err)
+		return nil
+	}
+	return &position
+}
+
+// loadRotated are the last positions that were saved in the files that the path was rotated to, oldest first
+func loadRotated(checkpoints Checkpoints, path string) []*Position {
+	rotated := make([]*Position, 0)
+	if checkpoints == nil {
+		return rotated
+	}
+	if data := checkpoints.GetCheckpoint(rotatedPrefix + path); data != nil {
+		if err := json.Unmarshal(data, &rotated); err != nil {
+			log.Printf("Failed to load the rotated positions of %s: %v\n", path, err)
+		}
+	}
+	return rotated
+}
+
+func saveRotated(checkpoints Checkpoints, path string, rotated []*Position) {
+	data, err := json.Marshal(rotated)
+	if err == nil {
+		err = checkpoints.SaveCheckpoint(rotatedPrefix+path, data)
+	}
+	if err != nil {
+		log.Printf("Failed to save the rotated positions of %s: %v\n", path, err)
+	}
+}
+
+func savePosition(checkpoints Checkpoints, key string, position *Position) {
+	if checkpoints == nil {
+		return
+	}
+	data, err := json.Marshal(position)
+	if err == nil {
+		err = checkpoints.SaveCheckpoint(key, data)
+	}
+	if err != nil {
+		log.Printf("Failed to save the position of %s: %v\n", key, err)
+	}
+}
+
+func openPath(path string) (*openFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &openFile{file: file, reader: bufio.NewReader(file), inode: inode(info), fingerprint: fingerprint(file)}, nil
+}
+
+// continues is whether the position is in this file, the file is the same inode,
+// is at least as long as the offset and starts with the position's fingerprint
+func (current *openFile) continues(position *Position) bool {
+	info, err := current.file.Stat()
+	return err == nil && current.inode == position.Inode && info.Size() >= position.Offset &&
+		bytes.HasPrefix(current.fingerprint, position.Fingerprint)
+}
+
+// sameFile is whether the later position is further on in the same file as the earlier one, rather than in
+// a file that it was rotated to or that has been truncated
+func sameFile(earlier *Position, later *Position) bool {
+	return earlier.Inode == later.Inode && later.Offset >= earlier.Offset && bytes.HasPrefix(later.Fingerprint, earlier.Fingerprint)
+}
+
+// truncated is whether the file is shorter than has been read or no longer starts with its fingerprint,
+// the fingerprint is extended while the file is shorter than fingerprintSize
+func (current *openFile) truncated() bool {
+	info, err := current.file.Stat()
+	if err != nil {
+		return false
+	}
+	start := fingerprint(current.file)
+	if info.Size() < current.offset+int64(len(current.partial)) || !bytes.HasPrefix(start, current.fingerprint) {
+		return true
+	}
+	current.fingerprint = start
+	return false
+}
+
+// seek moves to the offset, which is the start of a line, and takes the fingerprint again
+func (current *openFile) seek(offset int64) {
+	if _, err := current.file.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("Failed to seek %s: %v\n", current.file.Name(), err)
+	}
+	current.reader.Reset(current.file)
+	current.offset = offset
+	current.partial = ""
+	current.fingerprint = fingerprint(current.file)
+}
+
+// position is the position after the last complete line that was read
+func (current *openFile) position() *Position {
+	return &Position{current.inode, current.offset, current.fingerprint}
+}
+
+func fingerprint(file *os.File) []byte {
+	start := make([]byte, fingerprintSize)
+	count, _ := file.ReadAt(start, 0)
+	return start[:count]
+}
+
+func inode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
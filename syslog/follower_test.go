@@ -0,0 +1,85 @@
+package syslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type checkpoints map[string][]byte
+
+func (saved checkpoints) GetCheckpoint(path string) []byte {
+	return saved[path]
+}
+
+func (saved checkpoints) SaveCheckpoint(path string, checkpoint []byte) error {
+	saved[path] = checkpoint
+	return nil
+}
+
+func TestFollowFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "follow")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnsmasq.log")
+	saved := checkpoints{}
+	appendLines(t, path, "line 1", "line 2", "line 3")
+	lines, checkpoint := followFile(path, saved)
+	expectLines(t, lines, "line 1")
+	second := expectLines(t, lines, "line 2")
+	checkpoint(second)
+
+	// a restart carries on after the last line that was processed
+	lines, checkpoint = followFile(path, saved)
+	expectLines(t, lines, "line 3")
+
+	// copytruncate, the lines in the copy that have not been read are read from it
+	appendLines(t, path, "line 4")
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path+".1", data, 0644)
+	os.Truncate(path, 0)
+	appendLines(t, path, "line 5 is longer than the lines before it were together", "line 6")
+	expectLines(t, lines, "line 4", "line 5 is longer than the lines before it were together", "line 6")
+
+	// renamed, lines written to the rotated file before the new file are read first
+	os.Rename(path, path+".2")
+	appendLines(t, path+".2", "line 7")
+	appendLines(t, path, "line 8")
+	last := expectLines(t, lines, "line 7", "line 8")
+	checkpoint(last)
+
+	// rotated while stopped, the rest of the rotated file is read before the new file
+	appendLines(t, path, "line 9")
+	os.Rename(path, path+".3")
+	appendLines(t, path, "line 10")
+	lines, _ = followFile(path, saved)
+	expectLines(t, lines, "line 9", "line 10")
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, line := range lines {
+		file.WriteString(line + "\n")
+	}
+}
+
+// expectLines checks the next lines are the expected ones, returning the position after the last
+func expectLines(t *testing.T, lines chan *line, expected ...string) *Position {
+	var position *Position
+	for _, text := range expected {
+		select {
+		case line := <-lines:
+			if line.text != text {
+				t.Fatalf("Expected %q, found %q", text, line.text)
+			}
+			position = line.position
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %q, found nothing", text)
+		}
+	}
+	return position
+}
@@ -0,0 +1,248 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The prefix of the keys of the positions that files have been imported up to, the rest of the key
+// is a hash of the start of the file so that a file is recognised after it is renamed or compressed
+const importPrefix = "import:"
+
+// The most lines at the start of a file that are read to find the time of its first request or lease
+const firstTimeLines = 1000
+
+// matches the number that logrotate adds to a rotated file, before any compression extension
+var rotationNumber = regexp.MustCompile("\\.([0-9]+)(?:\\.[a-z0-9]+)?$")
+
+// Imported : the lines that were read from a file, those that had already been processed were skipped
+type Imported struct {
+	Path    string
+	Lines   int
+	Skipped int
+}
+
+// Import : sends the devices, requests and checkpoints of the lines of the files that have not been processed, oldest first
+func Import(paths []string, sources []*SourceConfig, checkpoints Checkpoints, devices chan *Device, requests chan *Request,
+	processed chan *Checkpoint) ([]*Imported, error) {
+	source := &SourceConfig{Type: FileSource}
+	followed := make([]string, 0)
+	for _, configured := range sources {
+		if configured.Type == FileSource || configured.Type == "" {
+			if len(followed) == 0 {
+				source = configured
+			}
+			followed = append(followed, configured.Path)
+		}
+	}
+	clock, err := LoadClock(source.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := NewParser(source.Parser, clock)
+	if err != nil {
+		return nil, err
+	}
+	paths, err = byFirstTime(paths, source)
+	if err != nil {
+		return nil, err
+	}
+	imported := make([]*Imported, 0, len(paths))
+	for _, path := range paths {
+		result, err := importFile(path, parser, followed, checkpoints, devices, requests, processed)
+		if err != nil {
+			return imported, fmt.Errorf("Failed to import %s: %v", path, err)
+		}
+		imported = append(imported, result)
+	}
+	return imported, nil
+}
+
+// importFile parses the lines of the file after those that have been processed before
+// and sends the checkpoint of the position after the last line
+func importFile(path string, parser Parser, followed []string, checkpoints Checkpoints, devices chan *Device, requests chan *Request,
+	processed chan *Checkpoint) (*Imported, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decompressed, compressed, err := decompress(file)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+	reader := bufio.NewReader(decompressed)
+	start, _ := reader.Peek(fingerprintSize)
+	fingerprint := append([]byte(nil), start...)
+	upTo, source := processedUpTo(fingerprint, followed, checkpoints)
+	imported := &Imported{Path: path}
+	var offset int64
+	for {
+		text, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		offset += int64(len(text))
+		if offset <= upTo {
+			imported.Skipped++
+			continue
+		}
+		imported.Lines++
+		parser.Parse(strings.TrimRight(text, "\r\n"), devices, requests)
+	}
+	parser.Flush(devices, requests)
+	if len(fingerprint) == 0 {
+		return imported, nil
+	}
+	positions := map[string]*Position{importKey(fingerprint): {Offset: offset, Fingerprint: fingerprint}}
+	if info, err := file.Stat(); err == nil && len(source) > 0 && !compressed {
+		positions[source] = &Position{inode(info), offset, fingerprint}
+	}
+	processed <- &Checkpoint{func() {
+		for key, position := range positions {
+			savePosition(checkpoints, key, position)
+		}
+	}}
+	return imported, nil
+}
+
+// processedUpTo is the offset that the file with the fingerprint has been processed up to, either by an import
+// or while following one of the followed paths, before or after it was rotated. The followed path is given
+// when the file is the one that it is following and that was furthest
+func processedUpTo(fingerprint []byte, followed []string, checkpoints Checkpoints) (int64, string) {
+	var processed int64
+	if position := loadPosition(checkpoints, importKey(fingerprint)); position != nil {
+		processed = position.Offset
+	}
+	furthest := ""
+	for _, path := range followed {
+		for _, position := range loadRotated(checkpoints, path) {
+			if continues(fingerprint, position) && position.Offset > processed {
+				processed, furthest = position.Offset, ""
+			}
+		}
+		if position := loadPosition(checkpoints, path); position != nil && continues(fingerprint, position) &&
+			position.Offset >= processed {
+			processed, furthest = position.Offset, path
+		}
+	}
+	return processed, furthest
+}
+
+// continues is whether the position is in the file that starts with the fingerprint
+func continues(fingerprint []byte, position *Position) bool {
+	return len(position.Fingerprint) > 0 && bytes.HasPrefix(fingerprint, position.Fingerprint)
+}
+
+func importKey(fingerprint []byte) string {
+	return fmt.Sprintf("%s%x", importPrefix, sha256.Sum256(fingerprint))
+}
+
+// decompress reads the file through the decompressor that its first bytes are for, if any
+func decompress(file *os.File) (io.ReadCloser, bool, error) {
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		reader, err := gzip.NewReader(buffered)
+		return reader, true, err
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return io.NopCloser(bzip2.NewReader(buffered)), true, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, true, err
+		}
+		return decoder.IOReadCloser(), true, nil
+	}
+	return io.NopCloser(buffered), false, nil
+}
+
+// byFirstTime orders the paths by the time of the first request or lease in each file, oldest first, files
+// without one or with the same time are ordered by their rotation number (the 2 of dnsmasq.log.2.gz), highest first
+func byFirstTime(paths []string, source *SourceConfig) ([]string, error) {
+	times := make(map[string]*time.Time, len(paths))
+	for _, path := range paths {
+		first, err := firstTime(path, source)
+		if err != nil {
+			return nil, err
+		}
+		times[path] = first
+	}
+	sorted := append([]string(nil), paths...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		first, second := times[sorted[i]], times[sorted[j]]
+		if first != nil && second != nil && !first.Equal(*second) {
+			return first.Before(*second)
+		}
+		return rotation(sorted[i]) > rotation(sorted[j])
+	})
+	return sorted, nil
+}
+
+// firstTime is the time of the first request or lease in the file, nil when there is not one in its first lines
+func firstTime(path string, source *SourceConfig) (*time.Time, error) {
+	clock, err := LoadClock(source.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := NewParser(source.Parser, clock)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decompressed, _, err := decompress(file)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+	reader := bufio.NewReader(decompressed)
+	devices := make(chan *Device, 10)
+	requests := make(chan *Request, 10)
+	for count := 0; count < firstTimeLines; count++ {
+		text, err := reader.ReadString('\n')
+		parser.Parse(strings.TrimRight(text, "\r\n"), devices, requests)
+		parser.Flush(devices, requests)
+		select {
+		case device := <-devices:
+			return device.At, nil
+		case request := <-requests:
+			return request.At, nil
+		default:
+		}
+		if err != nil {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// rotation is the number that the file was given when it was rotated, 0 when it has not been
+func rotation(path string) int {
+	if match := rotationNumber.FindStringSubmatch(filepath.Base(path)); match != nil {
+		number, _ := strconv.Atoi(match[1])
+		return number
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package syslog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestImport(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "import")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnsmasq.log")
+	writeLog(t, path+".2.zst", "www.oldest.com", "api.oldest.com")
+	writeLog(t, path+".1.gz", "www.older.com")
+	writeLog(t, path, "www.latest.com", "api.latest.com")
+	// the lines have the same times so the rotation numbers order the files, not when they were modified
+	now := time.Now()
+	os.Chtimes(path, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	os.Chtimes(path+".1.gz", now.Add(-time.Hour), now.Add(-time.Hour))
+
+	// the followed file has been processed up to the end of its first line
+	saved := checkpoints{}
+	data, _ := os.ReadFile(path)
+	first := int64(strings.Index(string(data), "\n") + 1)
+	savePosition(saved, path, &Position{1, first, data})
+	sources := []*SourceConfig{{Type: UDPSource}, {Type: FileSource, Path: path}}
+	requests, processed := make(chan *Request, 10), make(chan *Checkpoint, 10)
+	imported, err := Import([]string{path, path + ".1.gz", path + ".2.zst"}, sources, saved, make(chan *Device, 10), requests, processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position := loadPosition(saved, path); position.Offset != first {
+		t.Errorf("The followed file should not move on until the requests have been stored: %v", position)
+	}
+	found := stored(requests, processed)
+	for i, host := range []string{"www.oldest.com", "api.oldest.com", "www.older.com", "api.latest.com"} {
+		if len(found) != 4 || found[i].Host != host {
+			t.Errorf("Expected %s, found %v", host, found)
+		}
+	}
+	if len(imported) != 3 || imported[0].Lines != 2 || imported[2].Lines != 1 || imported[2].Skipped != 1 {
+		t.Errorf("Unexpected summary: %v %v %v", imported[0], imported[1], imported[2])
+	}
+	if position := loadPosition(saved, path); position.Offset != int64(len(data)) || position.Inode == 1 {
+		t.Errorf("The followed file should carry on after the import: %v", position)
+	}
+
+	// importing again skips every line, including those of the renamed copies
+	os.Rename(path+".1.gz", path+".3.gz")
+	imported, _ = Import([]string{path + ".3.gz", path + ".2.zst"}, sources, saved, make(chan *Device, 10), requests, processed)
+	if found := stored(requests, processed); len(found) != 0 || imported[0].Skipped != 1 || imported[1].Skipped != 2 {
+		t.Errorf("Expected every line to be skipped: %v %v, %d requests", imported[0], imported[1], len(found))
+	}
+}
+
+// stored takes the requests that have been sent and then saves the checkpoints, as the store would
+func stored(requests chan *Request, processed chan *Checkpoint) []*Request {
+	found := make([]*Request, 0)
+	for len(requests) > 0 {
+		found = append(found, <-requests)
+	}
+	for len(processed) > 0 {
+		(<-processed).Save()
+	}
+	return found
+}
+
+func TestImportRotated(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "import")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnsmasq.log")
+	first := "May 24 12:00:00 router dnsmasq[126]: query[A] www.first.com from 192.168.0.2"
+	second := "May 24 12:00:01 router dnsmasq[126]: query[A] www.second.com from 192.168.0.2"
+	third := "May 24 12:00:02 router dnsmasq[126]: query[A] www.third.com from 192.168.0.2"
+	saved := checkpoints{}
+	appendLines(t, path, first, second)
+	lines, checkpoint := followFile(path, saved)
+	checkpoint(expectLines(t, lines, first, second))
+
+	// the file is rotated and the follower carries on with the new one
+	os.Rename(path, path+".1")
+	appendLines(t, path, third)
+	checkpoint(expectLines(t, lines, third))
+
+	sources := []*SourceConfig{{Type: FileSource, Path: path}}
+	requests, processed := make(chan *Request, 10), make(chan *Checkpoint, 10)
+	imported, err := Import([]string{path + ".1"}, sources, saved, make(chan *Device, 10), requests, processed)
+	if found := stored(requests, processed); err != nil || len(found) != 0 || imported[0].Skipped != 2 || imported[0].Lines != 0 {
+		t.Errorf("Expected the lines processed while following to be skipped: %v %v, %d requests", imported, err, len(found))
+	}
+	if position := loadPosition(saved, path); position.Offset != int64(len(third)+1) {
+		t.Errorf("The followed file should not change: %v", position)
+	}
+}
+
+func TestByFirstTime(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "import")
+	defer os.RemoveAll(dir)
+	later := filepath.Join(dir, "dnsmasq.log")
+	earlier := filepath.Join(dir, "dnsmasq.log.1")
+	unknown := filepath.Join(dir, "dnsmasq.log.5.gz")
+	os.WriteFile(later, []byte("May 25 12:00:00 router dnsmasq[126]: query[A] www.later.com from 192.168.0.2\n"), 0644)
+	os.WriteFile(earlier, []byte("May 24 12:00:00 router kernel: started\n"+
+		"May 24 12:00:01 router dnsmasq-dhcp[123]: DHCPACK(eth0) 192.168.0.2 00:11:22:33:44:55 host1\n"), 0644)
+	writeLog(t, unknown)
+	sorted, err := byFirstTime([]string{later, earlier, unknown}, &SourceConfig{Type: FileSource})
+	if err != nil || len(sorted) != 3 || sorted[0] != unknown || sorted[1] != earlier || sorted[2] != later {
+		t.Errorf("Unexpected order %v %v", sorted, err)
+	}
+	if _, err := byFirstTime([]string{filepath.Join(dir, "missing.log")}, &SourceConfig{}); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+}
+
+func writeLog(t *testing.T, path string, hosts ...string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var writer io.WriteCloser = file
+	if strings.HasSuffix(path, ".gz") {
+		writer = gzip.NewWriter(file)
+	} else if strings.HasSuffix(path, ".zst") {
+		writer, _ = zstd.NewWriter(file)
+	}
+	for i, host := range hosts {
+		fmt.Fprintf(writer, "May 24 12:00:0%d router dnsmasq[126]: query[A] %s from 192.168.0.2\n", i, host)
+	}
+	if writer != file {
+		writer.Close()
+	}
+}
@@ -0,0 +1,218 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types of source that log lines can be read from
+const (
+	FileSource = "file"
+	UDPSource  = "udp"
+	TCPSource  = "tcp"
+	TLSSource  = "tls"
+)
+
+const maxMessageSize = 64 * 1024
+
+// How long a connection can be idle before it is closed, senders reconnect when they have more messages
+const readTimeout = 5 * time.Minute
+
+var errMessageSize = fmt.Errorf("Message is longer than %d bytes", maxMessageSize)
+
+var priority = regexp.MustCompile("^<([0-9]{1,3})>")
+var rfc5424 = regexp.MustCompile("^([0-9]{1,2}) ([^ ]+) ([^ ]+) ([^ ]+) ([^ ]+) ([^ ]+) ?(.*)$")
+
+func listenUDP(address string) (chan *line, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Listening for syslog messages on udp %s\n", conn.LocalAddr())
+	lines := make(chan *line, 10)
+	go func() {
+		buffer := make([]byte, maxMessageSize)
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				log.Printf("Error reading syslog message: %v\n", err)
+				close(lines)
+				return
+			}
+			for _, message := range strings.Split(string(buffer[:n]), "\n") {
+				sendMessage(lines, message)
+			}
+		}
+	}()
+	return lines, nil
+}
+
+// listenTCP accepts connections and calls connected with the lines of each one, which are closed with it
+func listenTCP(address string, config *tls.Config, connected func(chan *line)) error {
+	var listener net.Listener
+	var err error
+	if config != nil {
+		listener, err = tls.Listen("tcp", address, config)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for syslog messages on tcp %s\n", listener.Addr())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("Error accepting syslog connection: %v\n", err)
+				return
+			}
+			lines := make(chan *line, 10)
+			connected(lines)
+			go readConnection(conn, lines)
+		}
+	}()
+	return nil
+}
+
+func loadTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+}
+
+// readConnection reads messages framed either by octet counting or by a trailing newline as described
+// in RFC 6587, the connection is closed when a message is longer than maxMessageSize or it is idle
+func readConnection(conn net.Conn, lines chan *line) {
+	defer close(lines)
+	defer conn.Close()
+	reader := bufio.NewReaderSize(conn, maxMessageSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		first, err := reader.Peek(1)
+		if err != nil {
+			logConnectionError(err)
+			return
+		}
+		var message string
+		if first[0] >= '0' && first[0] <= '9' {
+			message, err = readOctetCounted(reader)
+		} else {
+			message, err = readLine(reader)
+		}
+		sendMessage(lines, message)
+		if err != nil {
+			logConnectionError(err)
+			return
+		}
+	}
+}
+
+// readLine reads up to the next newline, which must be within the reader's buffer
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errMessageSize
+	}
+	return string(line), err
+}
+
+func readOctetCounted(reader *bufio.Reader) (string, error) {
+	prefix, err := reader.ReadSlice(' ')
+	if err == bufio.ErrBufferFull {
+		return "", errMessageSize
+	} else if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(string(prefix)))
+	if err != nil || length > maxMessageSize {
+		return "", io.ErrUnexpectedEOF
+	}
+	message := make([]byte, length)
+	_, err = io.ReadFull(reader, message)
+	return string(message), err
+}
+
+func logConnectionError(err error) {
+	if err != io.EOF {
+		log.Printf("Error reading syslog connection: %v\n", err)
+	}
+}
+
+func sendMessage(lines chan *line, message string) {
+	if text := normalise(message); len(text) > 0 {
+		lines <- &line{text, nil}
+	}
+}
+
+// normalise converts an RFC 3164 or RFC 5424 message into the format that would have been
+// written to the log file, keeping the RFC 3339 timestamp of an RFC 5424 message as it has a year and zone
+func normalise(message string) string {
+	message = strings.TrimRight(message, "\r\n\x00")
+	match := priority.FindStringSubmatch(message)
+	if match == nil {
+		return message
+	}
+	message = message[len(match[0]):]
+	if header := rfc5424.FindStringSubmatch(message); header != nil {
+		if at, err := time.Parse(time.RFC3339Nano, header[2]); err == nil {
+			return formatRFC5424(at, header)
+		}
+	}
+	return message
+}
+
+func formatRFC5424(at time.Time, header []string) string {
+	tag := nilValue(header[4], "-")
+	if procID := nilValue(header[5], ""); len(procID) > 0 {
+		tag += "[" + procID + "]"
+	}
+	message := strings.TrimPrefix(skipStructuredData(header[7]), "\ufeff")
+	return at.Format(time.RFC3339Nano) + " " + nilValue(header[3], "-") + " " + tag + ": " + message
+}
+
+func nilValue(value string, replacement string) string {
+	if value == "-" {
+		return replacement
+	}
+	return value
+}
+
+// skipStructuredData removes the structured data elements from
+// the start of an RFC 5424 message, honouring quoted parameter values
+func skipStructuredData(data string) string {
+	if strings.HasPrefix(data, "-") {
+		return strings.TrimPrefix(data[1:], " ")
+	}
+	depth := 0
+	quoted := false
+	escaped := false
+	for i, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"' && depth > 0:
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			return strings.TrimPrefix(data[i:], " ")
+		}
+	}
+	return ""
+}
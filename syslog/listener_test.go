@@ -0,0 +1,106 @@
+package syslog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalise(t *testing.T) {
+	expected := "May 24 12:00:03 router dnsmasq[126]: query[A] www.google.com from 192.168.0.2"
+	messages := []string{
+		"May 24 12:00:03 router dnsmasq[126]: query[A] www.google.com from 192.168.0.2",
+		"<30>May 24 12:00:03 router dnsmasq[126]: query[A] www.google.com from 192.168.0.2\n",
+		"<30>1 2019-05-24T12:00:03Z router dnsmasq 126 - - query[A] www.google.com from 192.168.0.2",
+		"<30>1 2019-05-24T12:00:03.123Z router dnsmasq 126 - [meta x=\"[\\]\"][origin] query[A] www.google.com from 192.168.0.2",
+	}
+	for i, message := range messages {
+		if i == 2 {
+			expected = "2019-05-24T12:00:03Z router dnsmasq[126]: query[A] www.google.com from 192.168.0.2"
+		} else if i == 3 {
+			expected = "2019-05-24T12:00:03.123Z router dnsmasq[126]: query[A] www.google.com from 192.168.0.2"
+		}
+		if actual := normalise(message); actual != expected {
+			t.Errorf("Expected %q, found %q", expected, actual)
+		}
+	}
+}
+
+func TestListenUDP(t *testing.T) {
+	devices, requests, _, err := Open([]*SourceConfig{{Type: UDPSource, Address: "127.0.0.1:10514"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := net.Dial("udp", "127.0.0.1:10514")
+	defer conn.Close()
+	conn.Write([]byte("<30>May 24 12:00:00 router dnsmasq-dhcp[123]: DHCPACK(eth0) 192.168.0.2 00:11:22:33:44:55 host1"))
+	conn.Write([]byte("<30>May 24 12:00:03 router dnsmasq[126]: query[AAAA] www.google.com from 192.168.0.2"))
+	validateEvents(t, devices, requests)
+}
+
+func TestListenTCP(t *testing.T) {
+	devices, requests, _, err := Open([]*SourceConfig{{Type: TCPSource, Address: "127.0.0.1:10515"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := net.Dial("tcp", "127.0.0.1:10515")
+	defer conn.Close()
+	conn.Write([]byte("<30>May 24 12:00:00 router dnsmasq-dhcp[123]: DHCPACK(eth0) 192.168.0.2 00:11:22:33:44:55 host1\n"))
+	message := "<30>1 2019-05-24T12:00:03Z router dnsmasq 126 - - query[AAAA] www.google.com from 192.168.0.2"
+	conn.Write([]byte(fmt.Sprintf("%d %s", len(message), message)))
+	validateEvents(t, devices, requests)
+}
+
+func validateEvents(t *testing.T, devices chan *Device, requests chan *Request) {
+	select {
+	case device := <-devices:
+		if device.Mac != "00:11:22:33:44:55" || device.Hostname != "host1" {
+			t.Errorf("Unexpected device: %v", device)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No device received")
+	}
+	select {
+	case request := <-requests:
+		if request.Host != "www.google.com" || request.Type != "AAAA" || request.Source != "192.168.0.2" {
+			t.Errorf("Unexpected request: %v", request)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No request received")
+	}
+}
+
+func TestListenTCPConnections(t *testing.T) {
+	_, requests, _, err := Open([]*SourceConfig{{Type: TCPSource, Address: "127.0.0.1:10516"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a reply on another connection is not an answer to the query
+	first, _ := net.Dial("tcp", "127.0.0.1:10516")
+	defer first.Close()
+	second, _ := net.Dial("tcp", "127.0.0.1:10516")
+	defer second.Close()
+	first.Write([]byte("<30>May 24 12:00:03 router dnsmasq[126]: query[A] www.google.com from 192.168.0.2\n"))
+	time.Sleep(50 * time.Millisecond)
+	second.Write([]byte("<30>May 24 12:00:03 router dnsmasq[126]: reply www.google.com is 1.2.3.4\n"))
+	select {
+	case request := <-requests:
+		if request.Host != "www.google.com" || len(request.Answers) != 0 {
+			t.Errorf("Unexpected request: %v", request)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No request received")
+	}
+
+	// a message without a newline is not read past maxMessageSize
+	long, _ := net.Dial("tcp", "127.0.0.1:10516")
+	defer long.Close()
+	long.Write([]byte(strings.Repeat("a", maxMessageSize+1)))
+	long.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := long.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Errorf("Expected the connection to be closed, found %v", err)
+	}
+}
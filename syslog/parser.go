@@ -0,0 +1,110 @@
+package syslog
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// The log formats that can be parsed
+const (
+	DnsmasqParser = "dnsmasq"
+	UnboundParser = "unbound"
+	BindParser    = "bind"
+)
+
+// Parser : converts a line from a log into devices / requests,
+// sending them to the appropriate channel. Flush sends any that are waiting for more lines
+// and Waiting is the number of lines that have been parsed since the first line of the oldest
+// request that is waiting for more, including that line, 0 when none are waiting
+type Parser interface {
+	Parse(line string, devices chan *Device, requests chan *Request)
+	Flush(devices chan *Device, requests chan *Request)
+	Waiting() int
+}
+
+// The most that a source's clock can be ahead of the time a line is received, a timestamp without a year
+// that would be later than this is from the previous year
+const maxClockSkew = 24 * time.Hour
+
+// How long a request waits for its answers before it is sent without them
+const resolutionTimeout = 500 * time.Millisecond
+
+// NewParser : Create a parser for the named log format that reads timestamps with the clock,
+// a new parser should be used for each source
+func NewParser(name string, clock *Clock) (Parser, error) {
+	switch name {
+	case DnsmasqParser, "":
+		return &Dnsmasq{clock: clock}, nil
+	case UnboundParser:
+		return &Unbound{clock}, nil
+	case BindParser:
+		return &Bind{clock}, nil
+	}
+	return nil, fmt.Errorf("Unknown parser: %s", name)
+}
+
+// Clock : converts the timestamps of a source into times, timestamps without a zone are in the
+// source's location and those without a year are given one from the time the line was received
+type Clock struct {
+	Location *time.Location
+	Now      func() time.Time
+	failures uint64
+}
+
+// NewClock : Create a clock for a source in the location, lines are received as they are read
+func NewClock(location *time.Location) *Clock {
+	return &Clock{Location: location, Now: time.Now}
+}
+
+// LoadClock : Create a clock for a source in the named timezone, the local timezone when it is empty
+func LoadClock(timezone string) (*Clock, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	if len(timezone) == 0 {
+		location = time.Local
+	}
+	return NewClock(location), nil
+}
+
+// Time : the time of an RFC 3339 (or RFC 5424) timestamp, or of one in any of the layouts.
+// Timestamps that cannot be parsed are counted and the time the line was received is used instead
+func (clock *Clock) Time(value string, layouts ...string) time.Time {
+	received := clock.Now().In(clock.Location)
+	if at, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return at
+	}
+	for _, layout := range layouts {
+		if at, err := time.ParseInLocation(layout, value, clock.Location); err == nil {
+			if at.Year() == 0 {
+				at = inferYear(at, received)
+			}
+			return at
+		}
+	}
+	atomic.AddUint64(&clock.failures, 1)
+	log.Printf("Unable to parse timestamp %q, using the time it was received\n", value)
+	return received
+}
+
+// Failures : the number of timestamps that could not be parsed
+func (clock *Clock) Failures() uint64 {
+	return atomic.LoadUint64(&clock.failures)
+}
+
+// inferYear gives the time the year it was received in, January times received in December are
+// from the next year, unless that is too far ahead, as are any others that would be in the future
+func inferYear(at time.Time, received time.Time) time.Time {
+	year := received.Year()
+	if at.Month() == time.January && received.Month() == time.December {
+		year++
+	}
+	dated := time.Date(year, at.Month(), at.Day(), at.Hour(), at.Minute(), at.Second(), at.Nanosecond(), at.Location())
+	if dated.After(received.Add(maxClockSkew)) {
+		dated = dated.AddDate(-1, 0, 0)
+	}
+	return dated
+}
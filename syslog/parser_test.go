@@ -0,0 +1,207 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+func TestDnsmasqParser(t *testing.T) {
+	validateParser(t, DnsmasqParser, []string{
+		"May 24 12:00:00 router dnsmasq-dhcp[123]: DHCPACK(eth0) 192.168.0.2 00:11:22:33:44:55 host1",
+		"May 24 12:00:03 router dnsmasq[126]: query[AAAA] www.google.com from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: reply www.google.com is 2a00:1450:4009:81f::2004",
+	}, 1, "www.google.com", "AAAA", "192.168.0.2")
+}
+
+func TestDnsmasqClientID(t *testing.T) {
+	parser, _ := NewParser(DnsmasqParser, NewClock(time.UTC))
+	devices := make(chan *Device, 10)
+	requests := make(chan *Request, 10)
+	for _, line := range []string{
+		"May 24 12:00:00 router dnsmasq-dhcp[123]: 2915937090 DHCPACK(eth0) 192.168.0.2 00:11:22:33:44:55 host1",
+		"May 24 12:00:00 router dnsmasq-dhcp[123]: 2915937090 sent size:  4 option: 54 server-identifier  192.168.0.1",
+		"May 24 12:00:00 router dnsmasq-dhcp[123]: 2915937090 sent size: 19 option: 61 client-id  ff:00:00:00:01:00:01:2b:3c",
+		"May 24 12:01:00 router dnsmasq-dhcp[123]: 1234567890 DHCPACK(eth0) 192.168.0.3 00:11:22:33:44:66 host2",
+		"May 24 12:01:00 router dnsmasq-dhcp[123]: 1234567890 sent size:  7 option: 61 client-id  01:00:11:22:33:44:66",
+		"May 24 12:02:00 router dnsmasq-dhcp[123]: 1111111111 sent size:  7 option: 61 client-id  01:00:11:22:33:44:77",
+	} {
+		parser.Parse(line, devices, requests)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("expected two leases and a client ID, found %d", len(devices))
+	}
+	lease, identified := <-devices, <-devices
+	if lease.ClientID != "" || identified.Mac != "00:11:22:33:44:55" || identified.ClientID != "ff:00:00:00:01:00:01:2b:3c" {
+		t.Errorf("unexpected devices %v %v", lease, identified)
+	}
+}
+
+func TestDnsmasqExtra(t *testing.T) {
+	parser, _ := NewParser(DnsmasqParser, NewClock(time.UTC))
+	devices := make(chan *Device, 10)
+	requests := make(chan *Request, 10)
+	for _, line := range []string{
+		"May 24 12:00:03 router dnsmasq[126]: 11 192.168.0.2/53211 query[A] www.google.com from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 11 192.168.0.2/53211 forwarded www.google.com to 1.1.1.1",
+		"May 24 12:00:03 router dnsmasq[126]: 12 192.168.0.3/41000 query[AAAA] www.example.com from 192.168.0.3",
+		"May 24 12:00:03 router dnsmasq[126]: 12 192.168.0.3/41000 cached www.example.com is 2606:2800:220:1::1",
+		"May 24 12:00:03 router dnsmasq[126]: 11 192.168.0.2/53211 reply www.google.com is <CNAME>",
+		"May 24 12:00:03 router dnsmasq[126]: 11 192.168.0.2/53211 reply www.l.google.com is 142.250.1.1",
+		"May 24 12:00:03 router dnsmasq[126]: 11 192.168.0.2/53211 reply www.l.google.com is 142.250.1.2",
+		"May 24 12:00:04 router dnsmasq[126]: 13 192.168.0.2/53300 query[A] mail.google.com from 192.168.0.2",
+	} {
+		parser.Parse(line, devices, requests)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected the two answered requests, found %d", len(requests))
+	}
+	cached, google := <-requests, <-requests
+	if cached.Host != "www.example.com" || len(cached.Answers) != 1 || cached.Aliases["2606:2800:220:1::1"] != "www.example.com" {
+		t.Errorf("unexpected request %v %v", cached, cached.Answers)
+	}
+	if google.Host != "www.google.com" || google.Upstream != "1.1.1.1" || len(google.Answers) != 3 ||
+		google.Answers[0].Value != "www.l.google.com" || google.Aliases["142.250.1.2"] != "www.l.google.com" {
+		t.Errorf("unexpected request %v %v", google, google.Answers)
+	}
+	parser.Flush(devices, requests)
+	if len(requests) != 1 || (<-requests).Host != "mail.google.com" {
+		t.Error("expected the unanswered request to be flushed")
+	}
+}
+
+func TestDnsmasqOutcomes(t *testing.T) {
+	parser, _ := NewParser(DnsmasqParser, NewClock(time.UTC))
+	devices := make(chan *Device, 10)
+	requests := make(chan *Request, 10)
+	expected := map[string]string{
+		"ads.example.com":  state.Blocked,
+		"tracker.example":  state.Blocked,
+		"missing.example":  state.NXDomain,
+		"v4only.example":   state.NoData,
+		"router":           state.HostsFile,
+		"www.example.com":  state.Cached,
+		"www.google.com":   state.Forwarded,
+		"cached.example":   state.NXDomain,
+		"unanswered.local": "",
+	}
+	origins := map[string]string{
+		"missing.example": state.Forwarded,
+		"v4only.example":  state.Forwarded,
+		"www.example.com": state.Cached,
+		"www.google.com":  state.Forwarded,
+		"cached.example":  state.Cached,
+	}
+	for _, line := range []string{
+		"May 24 12:00:03 router dnsmasq[126]: 1 192.168.0.2/1001 query[A] ads.example.com from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 1 192.168.0.2/1001 config ads.example.com is 0.0.0.0",
+		"May 24 12:00:03 router dnsmasq[126]: 2 192.168.0.2/1002 query[A] missing.example from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 2 192.168.0.2/1002 forwarded missing.example to 9.9.9.9",
+		"May 24 12:00:03 router dnsmasq[126]: 2 192.168.0.2/1002 reply missing.example is NXDOMAIN",
+		"May 24 12:00:03 router dnsmasq[126]: 3 192.168.0.2/1003 query[AAAA] v4only.example from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 3 192.168.0.2/1003 reply v4only.example is NODATA-IPv6",
+		"May 24 12:00:03 router dnsmasq[126]: 4 192.168.0.2/1004 query[A] router from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 4 192.168.0.2/1004 /etc/hosts router is 192.168.0.1",
+		"May 24 12:00:03 router dnsmasq[126]: 5 192.168.0.2/1005 query[A] www.example.com from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 5 192.168.0.2/1005 cached www.example.com is 93.184.216.34",
+		"May 24 12:00:03 router dnsmasq[126]: 6 192.168.0.2/1006 query[A] www.google.com from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 6 192.168.0.2/1006 forwarded www.google.com to 1.1.1.1",
+		"May 24 12:00:03 router dnsmasq[126]: 6 192.168.0.2/1006 reply www.google.com is 142.250.1.1",
+		"May 24 12:00:03 router dnsmasq[126]: 7 192.168.0.2/1007 query[A] cached.example from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 7 192.168.0.2/1007 cached cached.example is NXDOMAIN",
+		"May 24 12:00:03 router dnsmasq[126]: 8 192.168.0.2/1008 query[A] unanswered.local from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 9 192.168.0.2/1009 query[A] tracker.example from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 9 192.168.0.2/1009 /etc/blocklist.hosts tracker.example is 0.0.0.0",
+	} {
+		parser.Parse(line, devices, requests)
+	}
+	parser.Flush(devices, requests)
+	if len(requests) != len(expected) {
+		t.Fatalf("expected %d requests, found %d", len(expected), len(requests))
+	}
+	for len(requests) > 0 {
+		request := <-requests
+		if request.Outcome != expected[request.Host] {
+			t.Errorf("%s: expected %q, found %q", request.Host, expected[request.Host], request.Outcome)
+		}
+		if request.Origin != origins[request.Host] {
+			t.Errorf("%s: expected the origin %q, found %q", request.Host, origins[request.Host], request.Origin)
+		}
+		if request.Host == "missing.example" && request.Upstream != "9.9.9.9" {
+			t.Errorf("expected the upstream to be recorded %v", request)
+		}
+	}
+}
+
+func TestUnboundParser(t *testing.T) {
+	validateParser(t, UnboundParser, []string{
+		"[1578650400] unbound[1234:0] info: 192.168.0.2 www.google.com. AAAA IN",
+		"[1578650400] unbound[1234:0] info: reply: 192.168.0.2 www.google.com. AAAA IN NOERROR 0.000000 1 45",
+	}, 0, "www.google.com", "AAAA", "192.168.0.2")
+	validateParser(t, UnboundParser, []string{
+		"Jan 10 10:00:00 resolver unbound: [1234:0] info: 192.168.0.2 www.google.com. HTTPS IN",
+	}, 0, "www.google.com", "HTTPS", "192.168.0.2")
+}
+
+func TestBindParser(t *testing.T) {
+	validateParser(t, BindParser, []string{
+		"10-Jan-2020 10:00:00.123 client @0x7f1c2c0a8f70 192.168.0.2#53211 (www.google.com): query: www.google.com IN MX +E(0)K (192.168.0.1)",
+	}, 0, "www.google.com", "MX", "192.168.0.2")
+	validateParser(t, BindParser, []string{
+		"Jan 10 10:00:00 resolver named[123]: client 192.168.0.2#53211 (www.google.com): view internal: query: www.google.com IN A + (192.168.0.1)",
+	}, 0, "www.google.com", "A", "192.168.0.2")
+}
+
+func TestClock(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	clock := NewClock(london)
+	received := time.Date(2020, 1, 1, 0, 0, 30, 0, london)
+	clock.Now = func() time.Time { return received }
+	checks := map[string]time.Time{
+		"Dec 31 23:59:50":             time.Date(2019, 12, 31, 23, 59, 50, 0, london),
+		"Jan  1 00:00:10":             time.Date(2020, 1, 1, 0, 0, 10, 0, london),
+		"Jul 10 10:00:00":             time.Date(2019, 7, 10, 10, 0, 0, 0, london),
+		"2020-01-01T00:00:10.5+01:00": time.Date(2019, 12, 31, 23, 0, 10, 500000000, time.UTC),
+		"10-Jan-2020 10:00:00.123":    time.Date(2020, 1, 10, 10, 0, 0, 123000000, london),
+		"not a timestamp":             received,
+	}
+	for value, expected := range checks {
+		if at := clock.Time(value, bindTimeFormat, timeFormat); !at.Equal(expected) {
+			t.Errorf("%s: expected %v, found %v", value, expected, at)
+		}
+	}
+	received = time.Date(2019, 12, 31, 23, 59, 50, 0, london)
+	if at := clock.Time("Jan  1 00:00:01", timeFormat); at.Year() != 2020 {
+		t.Errorf("expected a source that is ahead to be in the next year %v", at)
+	}
+	if clock.Failures() != 1 {
+		t.Errorf("expected one timestamp to fail, found %d", clock.Failures())
+	}
+	if _, err := LoadClock("Nowhere/Unknown"); err == nil {
+		t.Error("expected an unknown timezone to be rejected")
+	}
+}
+
+func TestUnknownParser(t *testing.T) {
+	if _, err := NewParser("unknown", NewClock(time.UTC)); err == nil {
+		t.Fail()
+	}
+}
+
+func validateParser(t *testing.T, name string, lines []string, deviceCount int, host string, qtype string, source string) {
+	parser, _ := NewParser(name, NewClock(time.UTC))
+	devices := make(chan *Device, 10)
+	requests := make(chan *Request, 10)
+	for _, line := range lines {
+		parser.Parse(line, devices, requests)
+	}
+	parser.Flush(devices, requests)
+	if len(devices) != deviceCount || len(requests) != 1 {
+		t.Fatalf("%s: expected %d devices and 1 request, found %d and %d", name, deviceCount, len(devices), len(requests))
+	}
+	request := <-requests
+	if request.Host != host || request.Type != qtype || request.Source != source || request.At.IsZero() {
+		t.Errorf("%s: unexpected request %v", name, request)
+	}
+}
@@ -1,93 +1,202 @@
 package syslog
 
 import (
+	"crypto/tls"
+	"fmt"
 	"log"
-	"os"
-	"os/signal"
-	"regexp"
-	"syscall"
 	"time"
 
-	"github.com/hpcloud/tail"
+	"github.com/tmullender/network-log-monitor/state"
 )
 
 const timeFormat = "Jan 2 15:04:05"
 
-var reply = regexp.MustCompile("^(.+) [a-z]+ dnsmasq.+: reply ([^ ]+) is ([^ ]+)")
-var query = regexp.MustCompile("^(.+) [a-z]+ dnsmasq.+: query.A. ([^ ]+) from ([^ ]+)")
-var ack = regexp.MustCompile("^(.+) [a-z]+ dnsmasq-dhcp.+: DHCPACK.+ ([^ ]+) ([^ ]+) ([^ ]+)")
+// The number of lines read from a file between the checkpoints of its position while it is busy
+const checkpointLines = 1000
 
-// Device : A representation of a DHCP request
+// Device : A representation of a DHCP request, the client ID is only set on the
+// devices that are sent for the client ID of a lease after the lease itself
 type Device struct {
 	At       *time.Time
 	Hostname string
 	Mac      string
 	IP       string
+	ClientID string
 }
 
-// Request : A representation of a DNS request
+// Request : A representation of a DNS request along with its answers, outcome, origin and upstream when they are known
 type Request struct {
-	At      *time.Time
-	Host    string
-	Source  string
-	Aliases map[string]string
+	At       *time.Time
+	Host     string
+	Source   string
+	Type     string
+	Aliases  map[string]string
+	Answers  []*Answer
+	Upstream string
+	Outcome  string
+	Origin   string
+}
+
+// Checkpoint : the position that a file has been processed up to, sent after the devices and requests before it
+type Checkpoint struct {
+	save func()
+}
+
+// Save : saves the position, once the devices and requests that were sent before the checkpoint have been stored
+func (checkpoint *Checkpoint) Save() {
+	checkpoint.save()
+}
+
+// When there are several answers the outcome of the one that is listed first is used
+var outcomeRanks = map[string]int{state.Blocked: 7, state.NXDomain: 6, state.NoData: 5, state.HostsFile: 4, state.Local: 3,
+	state.Cached: 2, state.Forwarded: 1}
+
+// Answer : an answer to a DNS request, the value of a CNAME is the name that it points to
+type Answer struct {
+	Name  string
+	Value string
+}
+
+// SourceConfig : where log lines should be read from, timestamps without a zone are in the
+// timezone (an IANA name such as Europe/London) which is the local timezone when it is not set
+type SourceConfig struct {
+	Type     string
+	Path     string
+	Address  string
+	CertFile string
+	KeyFile  string
+	Parser   string
+	Timezone string
 }
 
 // Tail will tail the log and send a device / request
 // to the appropriate channel when it is found
 func Tail(path string) (chan *Device, chan *Request, error) {
+	devices, requests, _, err := Open([]*SourceConfig{{Type: FileSource, Path: path}}, nil)
+	return devices, requests, err
+}
+
+// Open will read from each of the sources and send a device / request to the appropriate channel
+// when it is found, files are read from the positions kept by the checkpoints when they are not nil
+// and the positions that they have been processed up to are sent as they are reached
+func Open(sources []*SourceConfig, checkpoints Checkpoints) (chan *Device, chan *Request, chan *Checkpoint, error) {
 	devices := make(chan *Device, 3)
 	requests := make(chan *Request, 10)
-	t, err := tail.TailFile(path, tail.Config{ReOpen: true, Follow: true})
-	if err != nil {
-		return nil, nil, err
+	processed := make(chan *Checkpoint)
+	for _, source := range sources {
+		clock, err := LoadClock(source.Timezone)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		parser, err := NewParser(source.Parser, clock)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if source.Type == TCPSource || source.Type == TLSSource {
+			err = listenConnections(source, clock, devices, requests)
+		} else {
+			var lines chan *line
+			var checkpoint func(*Position)
+			lines, checkpoint, err = openSource(source, checkpoints)
+			if err == nil {
+				go processLines(lines, parser, clock, devices, requests, processed, checkpoint)
+			}
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
 	}
-	go processFile(t, devices, requests)
-	return devices, requests, nil
-}
-
-func setupExitListener(t *tail.Tail) {
-	signals := make(chan os.Signal)
-	signal.Notify(signals, syscall.SIGINT)
-	go func() {
-		<-signals
-		t.Stop()
-	}()
+	return devices, requests, processed, nil
 }
 
-func parseQuery(requests chan *Request, match *[]string) *Request {
-	at, _ := time.Parse(timeFormat, (*match)[1])
-	latest := &Request{&at, (*match)[2], (*match)[3], map[string]string{}}
-	log.Printf("Found request: %v\n", latest)
-	requests <- latest
-	return latest
-}
-
-func parseReply(latest *Request, match *[]string) {
-	latest.Aliases[(*match)[3]] = (*match)[2]
+// openSource starts reading the lines from the source, the function that saves the position
+// of the lines that have been processed is nil for sources that are not files and without checkpoints
+func openSource(source *SourceConfig, checkpoints Checkpoints) (chan *line, func(*Position), error) {
+	if source.Type == FileSource || source.Type == "" {
+		lines, checkpoint := followFile(source.Path, checkpoints)
+		if checkpoints == nil {
+			checkpoint = nil
+		}
+		return lines, checkpoint, nil
+	}
+	if source.Type == UDPSource {
+		lines, err := listenUDP(source.Address)
+		return lines, nil, err
+	}
+	return nil, nil, fmt.Errorf("Unknown source type: %s", source.Type)
 }
 
-func parseAck(devices chan *Device, match *[]string) {
-	at, _ := time.Parse(timeFormat, (*match)[1])
-	device := &Device{&at, (*match)[4], (*match)[3], (*match)[2]}
-	log.Printf("Found device: %v\n", device)
-	devices <- device
+// listenConnections parses the lines of each connection to a TCP or TLS source with its own parser,
+// so that the lines of one connection are not taken as the answers of another's requests
+func listenConnections(source *SourceConfig, clock *Clock, devices chan *Device, requests chan *Request) error {
+	var config *tls.Config
+	if source.Type == TLSSource {
+		var err error
+		if config, err = loadTLSConfig(source.CertFile, source.KeyFile); err != nil {
+			return err
+		}
+	}
+	return listenTCP(source.Address, config, func(lines chan *line) {
+		parser, _ := NewParser(source.Parser, clock)
+		go processLines(lines, parser, clock, devices, requests, nil, nil)
+	})
 }
 
-func processFile(t *tail.Tail, devices chan *Device, requests chan *Request) {
-	var current *Request
+// processLines parses the lines and sends a checkpoint of their position after flushes and every checkpointLines lines
+func processLines(lines chan *line, parser Parser, clock *Clock, devices chan *Device, requests chan *Request,
+	processed chan *Checkpoint, checkpoint func(*Position)) {
 	count := 0
-	for line := range t.Lines {
-		if count%100 == 0 {
-			log.Printf("%d lines read\n", count)
+	// the positions after the lines since the last checkpoint
+	positions := make([]*Position, 0)
+	var previous *Position
+	checkpointBefore := func(waiting int) {
+		last := len(positions) - 1 - waiting
+		if last < 0 {
+			return
 		}
-		count++
-		if match := query.FindStringSubmatch(line.Text); match != nil {
-			current = parseQuery(requests, &match)
-		} else if match = reply.FindStringSubmatch(line.Text); match != nil {
-			parseReply(current, &match)
-		} else if match = ack.FindStringSubmatch(line.Text); match != nil {
-			parseAck(devices, &match)
+		position := positions[last]
+		processed <- &Checkpoint{func() { checkpoint(position) }}
+		positions = append(positions[:0], positions[last+1:]...)
+	}
+	flush := func() {
+		parser.Flush(devices, requests)
+		checkpointBefore(0)
+	}
+	// a single timer is reset by each line rather than one being created for every line
+	idle := time.NewTimer(resolutionTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case line, open := <-lines:
+			if !open {
+				flush()
+				return
+			}
+			if count%100 == 0 {
+				log.Printf("%d lines read, %d timestamps could not be parsed\n", count, clock.Failures())
+			}
+			count++
+			// a checkpoint at the end of a file that has been rotated records how far it was processed
+			if previous != nil && line.position != nil && !sameFile(previous, line.position) {
+				checkpointBefore(parser.Waiting())
+			}
+			parser.Parse(line.text, devices, requests)
+			if checkpoint != nil && line.position != nil {
+				positions = append(positions, line.position)
+				previous = line.position
+			}
+			if count%checkpointLines == 0 {
+				checkpointBefore(parser.Waiting())
+			}
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(resolutionTimeout)
+		case <-idle.C:
+			flush()
 		}
 	}
 }
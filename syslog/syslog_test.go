@@ -0,0 +1,52 @@
+package syslog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProcessLinesCheckpoints(t *testing.T) {
+	lines := make(chan *line, checkpointLines+10)
+	requests := make(chan *Request, 10)
+	saved := make([]int64, 0)
+	checkpoint := func(position *Position) { saved = append(saved, position.Offset) }
+	texts := []string{
+		"May 24 12:00:03 router dnsmasq[126]: 1 192.168.0.2/1001 query[A] www.google.com from 192.168.0.2",
+		"May 24 12:00:03 router dnsmasq[126]: 1 192.168.0.2/1001 reply www.google.com is 142.250.1.1",
+		"May 24 12:00:03 router dnsmasq[126]: 2 192.168.0.2/1002 query[A] slow.example from 192.168.0.2",
+	}
+	for len(texts) < checkpointLines {
+		texts = append(texts, fmt.Sprintf("May 24 12:00:03 router kernel: line %d", len(texts)))
+	}
+	texts = append(texts, "May 24 12:00:04 router dnsmasq[126]: 2 192.168.0.2/1002 reply slow.example is 192.0.2.1")
+	for i, text := range texts {
+		lines <- &line{text, &Position{Offset: int64(i + 1)}}
+	}
+	close(lines)
+	parser, _ := NewParser(DnsmasqParser, NewClock(time.UTC))
+	processed := make(chan *Checkpoint)
+	go processLines(lines, parser, NewClock(time.UTC), make(chan *Device, 10), requests, processed, checkpoint)
+
+	// the busy checkpoint is sent after the requests before it and before the query that is still waiting
+	busy := <-processed
+	if len(requests) == 0 || len(saved) != 0 {
+		t.Fatalf("The position should only be saved once the requests before it have been stored %v", saved)
+	}
+	expectRequest(t, requests, "www.google.com")
+	busy.Save()
+	last := <-processed
+	slow := expectRequest(t, requests, "slow.example")
+	last.Save()
+	if len(slow.Answers) != 1 || len(saved) != 2 || saved[0] != 2 || saved[1] != int64(len(texts)) {
+		t.Errorf("Unexpected answers %v or checkpoints %v", slow.Answers, saved)
+	}
+}
+
+func expectRequest(t *testing.T, requests chan *Request, host string) *Request {
+	request := <-requests
+	if request.Host != host {
+		t.Fatalf("Expected %s, found %v", host, request)
+	}
+	return request
+}
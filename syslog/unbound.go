@@ -0,0 +1,41 @@
+package syslog
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matches the log-queries output either written directly to a file,
+// prefixed by the epoch time, or written through syslog
+var unboundQuery = regexp.MustCompile("^(?:\\[([0-9]+)\\]|(.+) [^ ]+) unbound(?:\\[[0-9:]+\\])?:? (?:\\[[0-9:]+\\] )?info: ([0-9a-fA-F.:]+) ([^ ]+) ([^ ]+) IN$")
+
+// Unbound : parses the queries logged by unbound when log-queries is enabled
+type Unbound struct {
+	clock *Clock
+}
+
+// Flush : does nothing as each request is sent as soon as it is parsed
+func (parser *Unbound) Flush(devices chan *Device, requests chan *Request) {}
+
+// Waiting : none are waiting as each request is sent as soon as it is parsed
+func (parser *Unbound) Waiting() int {
+	return 0
+}
+
+// Parse : parses a single line of an unbound log
+func (parser *Unbound) Parse(line string, devices chan *Device, requests chan *Request) {
+	if match := unboundQuery.FindStringSubmatch(line); match != nil {
+		var at time.Time
+		if epoch, err := strconv.ParseInt(match[1], 10, 64); err == nil {
+			at = time.Unix(epoch, 0)
+		} else {
+			at = parser.clock.Time(match[2], timeFormat)
+		}
+		request := &Request{&at, strings.TrimSuffix(match[4], "."), match[3], match[5], map[string]string{}, nil, "", "", ""}
+		log.Printf("Found request: %v\n", request)
+		requests <- request
+	}
+}
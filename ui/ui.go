@@ -1,9 +1,17 @@
 package ui
 
 import (
+	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/tmullender/network-log-monitor/auth"
+	"github.com/tmullender/network-log-monitor/oui"
 	"github.com/tmullender/network-log-monitor/state"
 )
 
@@ -13,12 +21,166 @@ type LatestContent struct {
 	Root    string
 }
 
+// Message : the latest page has no message of its own, it shares its template with the digest
+func (content *LatestContent) Message() string {
+	return ""
+}
+
+// IgnoreURL : the link to the page for ignoring the device
+func (content *LatestContent) IgnoreURL(mac string) string {
+	return content.Root + "/ignored-devices/add?mac=" + url.QueryEscape(mac)
+}
+
+// Groups : the devices in a single group with no name, the page is not grouped
+func (content *LatestContent) Groups() map[string]interface{} {
+	return map[string]interface{}{"": content.Devices}
+}
+
+// AllowURL : the link to the page for authorizing the host
+func (content *LatestContent) AllowURL(host string) string {
+	return content.Root + "/authorized-hosts/add?host=" + url.QueryEscape(host)
+}
+
+// AllowDomainURL : the link to the page for authorizing the registrable domain of the host
+func (content *LatestContent) AllowDomainURL(host string) string {
+	return content.Root + "/authorized-hosts/add?type=domain&host=" + url.QueryEscape(host)
+}
+
 var authorizedHostsFile, _ = Asset("templates/authorized-hosts.template")
 var authorizedHosts = template.Must(template.New("authorized-hosts").Parse(string(authorizedHostsFile)))
 var ignoredDevicesFile, _ = Asset("templates/ignored-devices.template")
 var ignoredDevices = template.Must(template.New("ignored-devices").Parse(string(ignoredDevicesFile)))
 var latestFile, _ = Asset("templates/email-content.template")
 var latest = template.Must(template.New("latest").Parse(string(latestFile)))
+var historyFile, _ = Asset("templates/history.template")
+var history = template.Must(template.New("history").Parse(string(historyFile)))
+var actionFile, _ = Asset("templates/action.template")
+var action = template.Must(template.New("action").Parse(string(actionFile)))
+var loginFile, _ = Asset("templates/login.template")
+var login = template.Must(template.New("login").Parse(string(loginFile)))
+var devicesFile, _ = Asset("templates/devices.template")
+var devices = template.Must(template.New("devices").Parse(string(devicesFile)))
+var newDomainsFile, _ = Asset("templates/new-domains.template")
+var newDomains = template.Must(template.New("new-domains").Parse(string(newDomainsFile)))
+var groupsFile, _ = Asset("templates/groups.template")
+var groups = template.Must(template.New("groups").Parse(string(groupsFile)))
+
+const dateFormat = "2006-01-02"
+
+const defaultNewDomainDays = 7
+
+// AuthorizedContent : the data to include in the authorized hosts page,
+// the type and host are used to fill in the form
+type AuthorizedContent struct {
+	Rules []*state.Rule
+	Error string
+	Type  string
+	Host  string
+	CSRF  string
+}
+
+// IgnoredContent : the data to include in the ignored devices page, the names of the devices by MAC address,
+// the MAC address is used to fill in the form
+type IgnoredContent struct {
+	Devices *map[string]bool
+	Names   map[string]string
+	Mac     string
+	CSRF    string
+}
+
+// Vendor : the vendor of the MAC address
+func (content *IgnoredContent) Vendor(mac string) string {
+	return oui.Lookup(mac)
+}
+
+// Randomized : whether the MAC address is randomized
+func (content *IgnoredContent) Randomized(mac string) bool {
+	return oui.Randomized(mac)
+}
+
+// DevicesContent : the data to include in the devices page, optionally only those with the status,
+// along with the devices that could be merged when they have been asked for and the names of the groups
+// they can be moved to
+type DevicesContent struct {
+	Devices     []*DeviceContent
+	Suggest     bool
+	Suggestions []*state.MergeSuggestion
+	Groups      []string
+	Status      string
+	Error       string
+	CSRF        string
+}
+
+// DeviceContent : a device in the devices page, the name is shown and the friendly name is the one it was given,
+// if any. The rates are the shares of its requests that were blocked or for hosts that do not exist and the share
+// of those answered by the cache or an upstream server that were answered by the cache, they are empty when
+// the outcomes are not known
+type DeviceContent struct {
+	Hostname     string
+	Name         string
+	FriendlyName string
+	Owner        string
+	Notes        string
+	Mac          string
+	IP           string
+	Vendor       string
+	Randomized   bool
+	Status       string
+	FirstSeen    *time.Time
+	LastSeen     *time.Time
+	Ignored      bool
+	Aliases      []string
+	Group        string
+	Blocked      string
+	NXDomain     string
+	CacheHits    string
+}
+
+// GroupsContent : the data to include in the groups page
+type GroupsContent struct {
+	Groups []*GroupContent
+	Error  string
+	CSRF   string
+}
+
+// GroupContent : a group in the groups page along with the names of its devices
+type GroupContent struct {
+	Name    string
+	Rules   []*state.Rule
+	Ignored bool
+	Devices []string
+}
+
+// NewDomainsContent : the data to include in the new domains page, the names of the devices by MAC address
+type NewDomainsContent struct {
+	Domains []*state.HostSeen
+	Names   map[string]string
+	Mac     string
+	Days    int
+}
+
+// ActionContent : the data to include in the page for a signed link
+type ActionContent struct {
+	Token  string
+	Action *auth.Action
+	Done   bool
+	Error  string
+}
+
+// LoginContent : the data to include in the login page
+type LoginContent struct {
+	Next    string
+	Error   string
+	NoUsers bool
+}
+
+// HistoryContent : the data to include in the history page, the name is empty when the device is unknown
+type HistoryContent struct {
+	Mac        string
+	Name       string
+	Resolution string
+	Rollups    []*state.Rollup
+}
 
 // Root : Returns a handler for the root URL
 func Root() func(resp http.ResponseWriter, req *http.Request) {
@@ -27,59 +189,466 @@ func Root() func(resp http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// Latest : Returns a handler for rendering the latest requests
+// Latest : Returns a handler for rendering the requests that have not been delivered to a consumer,
+// state.DefaultConsumer unless another is chosen, optionally restricted to a single query type
 func Latest(store *state.Store, root string) func(resp http.ResponseWriter, req *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
-		latest.Execute(resp, &LatestContent{store.GetLatestRequests(false), root})
+		consumer := req.FormValue("consumer")
+		if len(consumer) == 0 {
+			consumer = state.DefaultConsumer
+		}
+		devices, _, err := store.GetRequestsSince(consumer)
+		if err != nil {
+			log.Printf("Error reading latest requests: %v\n", err)
+			http.Error(resp, "Unable to read the latest requests", http.StatusInternalServerError)
+			return
+		}
+		if qtype := req.FormValue("type"); len(qtype) > 0 {
+			devices = state.FilterByType(devices, qtype)
+		}
+		latest.Execute(resp, &LatestContent{devices, root})
+	}
+}
+
+// History : Returns a handler for rendering the hourly or daily request counts of a device,
+// optionally between two dates
+func History(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		mac := req.FormValue("mac")
+		resolution := req.FormValue("resolution")
+		if resolution != state.Daily {
+			resolution = state.Hourly
+		}
+		from := parseDate(req.FormValue("from"), time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC))
+		to := parseDate(req.FormValue("to"), time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+		name := ""
+		if device := store.GetDevice(mac); device != nil {
+			name = device.Name()
+		}
+		history.Execute(resp, &HistoryContent{mac, name, resolution, store.GetRollups(mac, resolution, &from, &to)})
+	}
+}
+
+func parseDate(value string, fallback time.Time) time.Time {
+	if at, err := time.ParseInLocation(dateFormat, value, time.Local); err == nil {
+		return at
+	}
+	return fallback
+}
+
+// Login : Returns a handler for rendering the login page and logging in,
+// the user is redirected to the page given by next after logging in
+func Login(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		next := localPath(req.FormValue("next"))
+		if req.Method != http.MethodPost {
+			login.Execute(resp, &LoginContent{next, "", !store.HasUsers()})
+			return
+		}
+		name := req.PostFormValue("user")
+		if !auth.Authenticate(store, name, req.PostFormValue("password")) {
+			log.Printf("Failed login for %s from %s\n", name, req.RemoteAddr)
+			resp.WriteHeader(http.StatusUnauthorized)
+			login.Execute(resp, &LoginContent{next, "Incorrect user or password", !store.HasUsers()})
+			return
+		}
+		if _, err := auth.StartSession(store, resp, req, name); err != nil {
+			log.Printf("Error starting session: %v\n", err)
+			http.Error(resp, "Unable to start session", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(resp, req, next, http.StatusSeeOther)
+	}
+}
+
+// localPath is the path and query of next when it is a path on this server, otherwise /.
+// Backslashes are rejected as browsers treat them as slashes, so /\host would be //host
+func localPath(next string) string {
+	parsed, err := url.Parse(next)
+	if err != nil || len(parsed.Scheme) > 0 || len(parsed.Host) > 0 || parsed.User != nil ||
+		!strings.HasPrefix(parsed.Path, "/") || strings.HasPrefix(parsed.Path, "//") || strings.Contains(next, "\\") {
+		return "/"
+	}
+	local := &url.URL{Path: parsed.Path, RawQuery: parsed.RawQuery}
+	return local.String()
+}
+
+// Logout : Returns a handler for logging out, it should be wrapped by auth.Require
+func Logout(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
+		auth.EndSession(store, resp, req)
+		http.Redirect(resp, req, "/login", http.StatusSeeOther)
+	}
+}
+
+// Action : Returns a handler for the signed links in emails, they can be used without logging in.
+// The link asks for the action to be confirmed, as mail scanners may follow it, and the action
+// is performed when the confirmation is posted
+func Action(store *state.Store, signer *auth.Signer) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		token := req.FormValue("token")
+		verify := signer.Verify
+		if req.Method == http.MethodPost {
+			verify = signer.Use
+		}
+		found, err := verify(token)
+		if err != nil {
+			log.Printf("Rejected signed link from %s: %v\n", req.RemoteAddr, err)
+			if err == auth.ErrInvalidToken {
+				resp.WriteHeader(http.StatusForbidden)
+			} else {
+				resp.WriteHeader(http.StatusGone)
+			}
+			action.Execute(resp, &ActionContent{Error: err.Error()})
+			return
+		}
+		if req.Method != http.MethodPost {
+			action.Execute(resp, &ActionContent{Token: token, Action: found})
+			return
+		}
+		switch found.Name {
+		case auth.AllowAction:
+			if _, err = state.ParseRule(found.Value); err == nil {
+				store.AuthoriseHost(found.Value)
+			}
+		case auth.IgnoreAction:
+			store.IgnoreDevice(found.Value)
+		default:
+			err = fmt.Errorf("Unknown action: %s", found.Name)
+		}
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			action.Execute(resp, &ActionContent{Action: found, Error: err.Error()})
+			return
+		}
+		log.Printf("Access: %s %s by signed link from %s\n", found.Name, found.Value, req.RemoteAddr)
+		action.Execute(resp, &ActionContent{Action: found, Done: true})
 	}
 }
 
 // GetAuthorizedHosts : Returns a handler for rendering the authorized hosts page
 func GetAuthorizedHosts(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
-		authorizedHosts.Execute(resp, store.GetAuthorisedHosts())
+		authorizedHosts.Execute(resp, &AuthorizedContent{store.GetAuthorisedRules(), "", "", "", auth.CSRFToken(req)})
 	}
 }
 
-// AddAuthorizedHosts : Returns a handler for adding an authorized host
+// AddAuthorizedHosts : Returns a handler for adding an authorized host when posted to,
+// an exact, wildcard, domain or regex rule can be added. Other methods render the page with
+// the form filled in so that links can ask for a host to be added
 func AddAuthorizedHosts(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
+		ruleType := req.FormValue("type")
 		host := req.FormValue("host")
-		store.AuthoriseHost(host)
-		authorizedHosts.Execute(resp, store.GetAuthorisedHosts())
+		if req.Method != http.MethodPost {
+			authorizedHosts.Execute(resp, &AuthorizedContent{store.GetAuthorisedRules(), "", ruleType, host, auth.CSRFToken(req)})
+			return
+		}
+		key, err := state.NewRule(ruleType, host)
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			authorizedHosts.Execute(resp, &AuthorizedContent{store.GetAuthorisedRules(), err.Error(), ruleType, host, auth.CSRFToken(req)})
+			return
+		}
+		store.AuthoriseHost(key)
+		http.Redirect(resp, req, "/authorized-hosts", http.StatusSeeOther)
 	}
 }
 
 // RemoveAuthorizedHosts : Returns a handler for removing an authorized host
 func RemoveAuthorizedHosts(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
 		host := req.FormValue("host")
 		store.DeauthoriseHost(host)
-		authorizedHosts.Execute(resp, store.GetAuthorisedHosts())
+		http.Redirect(resp, req, "/authorized-hosts", http.StatusSeeOther)
 	}
 }
 
 // GetIgnoredDevices : Returns a handler for rendering ignored devices
 func GetIgnoredDevices(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
-		ignoredDevices.Execute(resp, store.GetIgnoredDevices())
+		ignoredDevices.Execute(resp, &IgnoredContent{store.GetIgnoredDevices(), deviceNames(store), "", auth.CSRFToken(req)})
 	}
 }
 
-// AddIgnoredDevice : Returns a handler for adding an ignored device
+// AddIgnoredDevice : Returns a handler for adding an ignored device when posted to,
+// other methods render the page with the form filled in
 func AddIgnoredDevice(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
 		mac := req.FormValue("mac")
+		if req.Method != http.MethodPost || len(mac) == 0 {
+			if req.Method == http.MethodPost {
+				resp.WriteHeader(http.StatusBadRequest)
+			}
+			ignoredDevices.Execute(resp, &IgnoredContent{store.GetIgnoredDevices(), deviceNames(store), mac, auth.CSRFToken(req)})
+			return
+		}
 		store.IgnoreDevice(mac)
-		ignoredDevices.Execute(resp, store.GetIgnoredDevices())
+		http.Redirect(resp, req, "/ignored-devices", http.StatusSeeOther)
 	}
 }
 
 // RemoveIgnoredDevice : Returns a handler for removing an ignored device
 func RemoveIgnoredDevice(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
 		mac := req.FormValue("mac")
 		store.UnIgnoreDevice(mac)
-		ignoredDevices.Execute(resp, store.GetIgnoredDevices())
+		http.Redirect(resp, req, "/ignored-devices", http.StatusSeeOther)
+	}
+}
+
+// NewDomains : Returns a handler for rendering the hosts that devices requested for the first time
+// in the last few days, optionally for a single device
+func NewDomains(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		mac := req.FormValue("mac")
+		days, err := strconv.Atoi(req.FormValue("days"))
+		if err != nil || days <= 0 {
+			days = defaultNewDomainDays
+		}
+		from := time.Now().AddDate(0, 0, -days)
+		newDomains.Execute(resp, &NewDomainsContent{store.GetNewHosts(mac, &from, nil), deviceNames(store), mac, days})
+	}
+}
+
+// deviceNames : the names of the devices by MAC address, including those merged into them
+func deviceNames(store *state.Store) map[string]string {
+	names := make(map[string]string, 0)
+	for _, device := range store.GetDevices() {
+		names[device.Mac] = device.Name()
+		for _, alias := range device.Aliases {
+			names[alias] = device.Name()
+		}
+	}
+	return names
+}
+
+// GetDevices : Returns a handler for rendering the devices along with their status, optionally only
+// those that are quarantined or approved, the merges are only suggested when asked for (suggest=true)
+// as every pair of devices is compared
+func GetDevices(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		renderDevices(store, resp, req, "")
+	}
+}
+
+func renderDevices(store *state.Store, resp http.ResponseWriter, req *http.Request, message string) {
+	status := req.FormValue("status")
+	statuses := store.GetDeviceStatuses()
+	content := &DevicesContent{make([]*DeviceContent, 0), req.FormValue("suggest") == "true", nil, make([]string, 0), status, message, auth.CSRFToken(req)}
+	if content.Suggest {
+		content.Suggestions = store.SuggestMerges()
+	}
+	for _, group := range store.GetGroups() {
+		content.Groups = append(content.Groups, group.Name)
+	}
+	for _, device := range store.GetDevices() {
+		row := &DeviceContent{device.Hostname, device.Name(), "", device.Owner(), device.Notes(), device.Mac, device.IP, device.Vendor(), device.Randomized(), state.Approved,
+			device.At, store.LastSeen(device.Mac), store.IsIgnored(device.Mac), device.Aliases, device.Group, "", "", ""}
+		if device.Profile != nil {
+			row.FriendlyName = device.Profile.Name
+		}
+		row.Blocked, row.NXDomain, row.CacheHits = outcomeRates(device.OutcomeCounts(), device.OriginCounts())
+		if recorded, exists := statuses[device.Mac]; exists {
+			row.Status = recorded.Status
+			row.FirstSeen = recorded.FirstSeen
+		}
+		if len(status) == 0 || row.Status == status {
+			content.Devices = append(content.Devices, row)
+		}
+	}
+	devices.Execute(resp, content)
+}
+
+// outcomeRates are the shares of the requests that were blocked or for hosts that do not exist,
+// and the share of those answered by the cache or an upstream server that were answered by the cache
+func outcomeRates(outcomes map[string]int, origins map[string]int) (string, string, string) {
+	total := 0
+	for _, count := range outcomes {
+		total += count
+	}
+	cached := origins[state.Cached]
+	return percent(outcomes[state.Blocked], total), percent(outcomes[state.NXDomain], total), percent(cached, cached+origins[state.Forwarded])
+}
+
+func percent(count int, total int) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%%", float64(count)*100/float64(total))
+}
+
+// SetDeviceStatus : Returns a handler for approving or quarantining a device
+func SetDeviceStatus(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
+		if err := store.SetDeviceStatus(req.FormValue("mac"), req.FormValue("status")); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(resp, req, "/devices", http.StatusSeeOther)
+	}
+}
+
+// SetDeviceProfile : Returns a handler for giving a device a friendly name, owner and notes,
+// empty values remove them
+func SetDeviceProfile(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
+		profile := &state.Profile{Name: strings.TrimSpace(req.FormValue("name")),
+			Owner: strings.TrimSpace(req.FormValue("owner")), Notes: req.FormValue("notes")}
+		if err := store.SetProfile(req.FormValue("mac"), profile); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(resp, req, "/devices", http.StatusSeeOther)
+	}
+}
+
+// MergeDevices : Returns a handler for merging a device into another that it is the same as,
+// so that they share their requests and are approved, named and ignored together
+func MergeDevices(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
+		if err := store.MergeDevices(req.FormValue("into"), req.FormValue("mac")); err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			renderDevices(store, resp, req, err.Error())
+			return
+		}
+		http.Redirect(resp, req, "/devices", http.StatusSeeOther)
+	}
+}
+
+// SplitDevice : Returns a handler for splitting a device from the device it was merged into
+func SplitDevice(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
+		if err := store.SplitDevice(req.FormValue("mac")); err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			renderDevices(store, resp, req, err.Error())
+			return
+		}
+		http.Redirect(resp, req, "/devices", http.StatusSeeOther)
+	}
+}
+
+// SetDeviceGroup : Returns a handler for moving a device to a group, an empty group removes it from its group
+func SetDeviceGroup(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
+		if err := store.SetDeviceGroup(req.FormValue("mac"), req.FormValue("group")); err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			renderDevices(store, resp, req, err.Error())
+			return
+		}
+		http.Redirect(resp, req, "/devices", http.StatusSeeOther)
+	}
+}
+
+// GetGroups : Returns a handler for rendering the device groups with their authorized hosts and devices
+func GetGroups(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		renderGroups(store, resp, req, "")
+	}
+}
+
+func renderGroups(store *state.Store, resp http.ResponseWriter, req *http.Request, message string) {
+	content := &GroupsContent{make([]*GroupContent, 0), message, auth.CSRFToken(req)}
+	byName := make(map[string]*GroupContent, 0)
+	for _, group := range store.GetGroups() {
+		row := &GroupContent{group.Name, group.Rules(), group.Ignored, make([]string, 0)}
+		byName[group.Name] = row
+		content.Groups = append(content.Groups, row)
+	}
+	for _, device := range store.GetDevices() {
+		if row, exists := byName[device.Group]; exists {
+			row.Devices = append(row.Devices, device.Name())
+		}
+	}
+	groups.Execute(resp, content)
+}
+
+// AddGroup : Returns a handler for adding a device group
+func AddGroup(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return groupAction(store, func(req *http.Request) error {
+		return store.AddGroup(req.FormValue("name"))
+	})
+}
+
+// RemoveGroup : Returns a handler for removing a device group, its devices are no longer in a group
+func RemoveGroup(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return groupAction(store, func(req *http.Request) error {
+		return store.RemoveGroup(req.FormValue("name"))
+	})
+}
+
+// SetGroupIgnored : Returns a handler for ignoring, or no longer ignoring, the devices in a group
+func SetGroupIgnored(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return groupAction(store, func(req *http.Request) error {
+		return store.SetGroupIgnored(req.FormValue("name"), req.FormValue("ignored") == "true")
+	})
+}
+
+// AddGroupHost : Returns a handler for adding an exact, wildcard, domain or regex rule to the
+// authorized hosts of a group
+func AddGroupHost(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return groupAction(store, func(req *http.Request) error {
+		key, err := state.NewRule(req.FormValue("type"), req.FormValue("host"))
+		if err != nil {
+			return err
+		}
+		return store.AuthoriseGroupHost(req.FormValue("name"), key)
+	})
+}
+
+// RemoveGroupHost : Returns a handler for removing a rule from the authorized hosts of a group
+func RemoveGroupHost(store *state.Store) func(resp http.ResponseWriter, req *http.Request) {
+	return groupAction(store, func(req *http.Request) error {
+		return store.DeauthoriseGroupHost(req.FormValue("name"), req.FormValue("host"))
+	})
+}
+
+// groupAction is a handler that makes a change to the groups when posted to and returns to the
+// groups page, which shows the error when the change cannot be made
+func groupAction(store *state.Store, change func(req *http.Request) error) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !requirePost(resp, req) {
+			return
+		}
+		if err := change(req); err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			renderGroups(store, resp, req, err.Error())
+			return
+		}
+		http.Redirect(resp, req, "/groups", http.StatusSeeOther)
+	}
+}
+
+// requirePost responds with 405 unless the request is a POST
+func requirePost(resp http.ResponseWriter, req *http.Request) bool {
+	if req.Method == http.MethodPost {
+		return true
 	}
+	resp.Header().Set("Allow", http.MethodPost)
+	http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
 }
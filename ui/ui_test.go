@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmullender/network-log-monitor/state"
+)
+
+func TestLocalPath(t *testing.T) {
+	for next, expected := range map[string]string{
+		"/devices?mac=AA:BB":    "/devices?mac=AA:BB",
+		"/latest#top":           "/latest",
+		"":                      "/",
+		"devices":               "/",
+		"//evil.com":            "/",
+		"/\\evil.com":           "/",
+		"\\\\evil.com":          "/",
+		"https://evil.com/path": "/",
+		"/%2Fevil.com":          "/",
+	} {
+		if actual := localPath(next); actual != expected {
+			t.Errorf("Expected %q for %q, found %q", expected, next, actual)
+		}
+	}
+}
+
+func TestFriendlyNames(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	at := time.Now()
+	device := store.AddDevice(&at, "laptop", "192.168.0.2", "AA:BB:CC:DD:EE:FF")
+	store.RecordRequest(device, &state.Request{At: &at, Host: "www.google.com", Type: "A"})
+	store.SetProfile("AA:BB:CC:DD:EE:FF", &state.Profile{Name: "laptop", Owner: "Sam"})
+	store.IgnoreDevice("AA:BB:CC:DD:EE:FF")
+	for path, handler := range map[string]func(http.ResponseWriter, *http.Request){
+		"/ignored-devices":               GetIgnoredDevices(store),
+		"/history?mac=AA:BB:CC:DD:EE:FF": History(store),
+		"/devices?mac=AA:BB:CC:DD:EE:FF": GetDevices(store),
+	} {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest(http.MethodGet, path, nil))
+		if !strings.Contains(resp.Body.String(), "laptop") {
+			t.Errorf("%s: expected the name to be shown %s", path, resp.Body.String())
+		}
+	}
+	resp := httptest.NewRecorder()
+	GetDevices(store)(resp, httptest.NewRequest(http.MethodGet, "/devices", nil))
+	if !strings.Contains(resp.Body.String(), `name="name" value="laptop"`) {
+		t.Errorf("expected the friendly name to fill in the form %s", resp.Body.String())
+	}
+}
+
+func TestSuggestMerges(t *testing.T) {
+	store, _ := state.NewStore("memory://")
+	defer store.Close()
+	at := time.Now()
+	store.AddDevice(&at, "android-1234", "192.168.0.2", "02:00:00:00:00:01")
+	store.AddDevice(&at, "android-1234", "192.168.0.3", "02:00:00:00:00:02")
+	resp := httptest.NewRecorder()
+	GetDevices(store)(resp, httptest.NewRequest(http.MethodGet, "/devices", nil))
+	if strings.Contains(resp.Body.String(), "same DHCP hostname") || !strings.Contains(resp.Body.String(), "suggest=true") {
+		t.Errorf("expected the merges to be suggested when asked for %s", resp.Body.String())
+	}
+	resp = httptest.NewRecorder()
+	GetDevices(store)(resp, httptest.NewRequest(http.MethodGet, "/devices?suggest=true", nil))
+	if !strings.Contains(resp.Body.String(), "same DHCP hostname") {
+		t.Errorf("expected the merges to be suggested %s", resp.Body.String())
+	}
+}